@@ -22,6 +22,29 @@ var files = []string{
 	"./xrips/examples/stringlev.rul",
 	"./xrips/examples/stringlong.rul",
 	"./xrips/examples/scenariox.rul",
+	"./xrips/examples/builtins.rul",
+	"./xrips/examples/durations.rul",
+	"./xrips/examples/first.rul",
+	"./xrips/examples/funcs.rul",
+	"./xrips/examples/include.rul",
+	"./xrips/examples/maps.rul",
+	"./xrips/examples/math.rul",
+	"./xrips/examples/mathnan.rul",
+	"./xrips/examples/msgfield.rul",
+	"./xrips/examples/pure.rul",
+	"./xrips/examples/reorder.rul",
+	"./xrips/examples/rulelabel.rul",
+	"./xrips/examples/sets.rul",
+	"./xrips/examples/softafter.rul",
+	"./xrips/examples/stop.rul",
+	"./xrips/examples/strfuncs.rul",
+	"./xrips/examples/timer.rul",
+	"./xrips/examples/topics.rul",
+	"./xrips/examples/transitions.rul",
+	//"./xrips/examples/ttl.rul", the remaining times in the output differ
+	"./xrips/examples/uint.rul",
+	"./xrips/examples/uintshift.rul",
+	"./xrips/examples/windows.rul",
 }
 
 func TestAB(t *testing.T) {
@@ -0,0 +1,109 @@
+package diag
+
+// Codes are the codes of the diagnostics by their format. The
+// errors are E1xx when parsing, E2xx typing, E3xx folding constants
+// and E4xx checking the levels. The ones not here get the code of
+// their stage, E100 to E400. The lint warnings are W0xx.
+var Codes = map[string]string{
+	//parser
+	"expected %s but err: %s":                                         "E101",
+	"expected %s found %s":                                            "E102",
+	"expected level id, error: %s":                                    "E103",
+	"declaring %s: %s":                                                "E104",
+	"level %s: only soft levels de-escalate after a time":             "E105",
+	"level %s: the quiet period should be positive":                   "E106",
+	"level %s is the lowest, it cannot de-escalate":                   "E107",
+	"transition %s -> %s to the same level":                           "E108",
+	"transition %s -> %s already declared":                            "E109",
+	"%s is not a declared level":                                      "E110",
+	"%s is not a type":                                                "E111",
+	"expected 'of' after set":                                         "E112",
+	"only sets of string are supported":                               "E113",
+	"expected '[' after map":                                          "E114",
+	"only maps from string are supported":                             "E115",
+	"expected ']' after map[string":                                   "E116",
+	"expected the type of the elements of the map":                    "E117",
+	"%s is not a valid type for the elements of a map":                "E118",
+	"no action for rule":                                              "E119",
+	"unknown section mode %s":                                         "E120",
+	"bad rule":                                                        "E121",
+	"include: %s":                                                     "E122",
+	"found %s at start of section":                                    "E123",
+	"no levels declared":                                              "E124",
+	"error in %s section ":                                            "E125",
+	"undeclared symbol '%s'":                                          "E126",
+	"incorrect expression %s...: %s":                                  "E127",
+	"the bound of the keys of a map should be a positive int, not %s": "E128",
+	"expected ')' after the bound of the map":                         "E129",
+
+	//types
+	"unknown secid type %s":                                               "E201",
+	"incorrect trigger expression should be boolean %s":                   "E202",
+	"incorrect action %s, can only be a function call":                    "E203",
+	"incorrect action %s, an expression cannot be an action":              "E204",
+	"var %s used but not set (should be constant)":                        "E205",
+	"var %s set and not used":                                             "E206",
+	"var %s unused and unset":                                             "E207",
+	"%t should be a variable initialized to {}\n":                         "E208",
+	"type error in initializer expression %s of type %s\n":                "E209",
+	"%t incompatible initializer %s of type %s\n":                         "E210",
+	"rule %s already declared at %s":                                      "E211",
+	"undeclared rule %s":                                                  "E212",
+	"countif can only be called in a rule":                                "E213",
+	"countif takes one argument, the window":                              "E214",
+	"function %s cannot call action %s\n":                                 "E215",
+	"function %s cannot be recursive, calls %s\n":                         "E216",
+	"%t incompatible with return type %s of function %s\n":                "E217",
+	"function %s mixes expressions of different sections\n":               "E218",
+	"%t (incorrect) in section type  %s\n":                                "E219",
+	"%s expected expression, not an action\n":                             "E220",
+	"%s bad function call\n":                                              "E221",
+	"bad number of args for function, %s expected %d, got %d\n":           "E222",
+	"not enough args to variadic function %s, min %d\n":                   "E223",
+	"arg %t of %s of incorrect type %s in section type  %s\n":             "E224",
+	"arg %d of %s of incorrect type %s in section type  %s\n":             "E225",
+	"%s: lval %t is not a variable\n":                                     "E226",
+	"%s: %t is an element of a map, only variables expire\n":              "E227",
+	"%s: cannot set map %t, set its elements\n":                           "E228",
+	"%s: lval %t rval %t in section type %s\n":                            "E229",
+	"cannot set builtin var %s\n":                                         "E230",
+	"%s: %t is not a variable that can expire\n":                          "E231",
+	"%t is not a map\n":                                                   "E232",
+	"%t is not an SLevel\n":                                               "E233",
+	"%t: %t is not a valid unnamed constant string path for executable\n": "E234",
+	"%t: %t is not a valid unnamed constant string path for yara rule\n":  "E235",
+	"%t in section type %s\n":                                             "E236",
+	"%t no unary operators for strings\n":                                 "E237",
+	"%t elements should be strings\n":                                     "E238",
+	"symbol should not be here %s\n":                                      "E239",
+
+	//constants
+	"%t is not a constant expression\n":              "E301",
+	"%t not enough arguments\n":                      "E302",
+	"%t is not a constant string for yara rule\n":    "E303",
+	"%t is not a valid yara rule yara error: [%s]\n": "E304",
+	"%t is not a constant string for regexp\n":       "E305",
+	"%t is not a valid regexp %s\n":                  "E306",
+	"%t is not a valid msg field path: %s\n":         "E307",
+	"%t result is %v\n":                              "E308",
+	"division by zero %s\n":                          "E309",
+	"negative shift count %s\n":                      "E310",
+	"%t: %v does not fit in %s\n":                    "E311",
+	"%t: window %s is not positive\n":                "E312",
+
+	//levels
+	"%s can never change the level, there is no transition to %s from%s": "E401",
+	"level %s de-escalates after %s but there is no transition %s -> %s": "E402",
+	"level %s not reachable":  "E403",
+	"level %s has no way out": "E404",
+
+	//lint
+	"const %s is never used":                                        "W001",
+	"the actions after %s are never run":                            "W002",
+	"%s when CurrLevel is %s does nothing":                          "W003",
+	"regexp %q can never match a topic name":                        "W004",
+	"duplicate rule, the same as the one at %s":                     "W005",
+	"condition %s is always true":                                   "W006",
+	"condition %s is always false, the rule is dropped":             "W007",
+	"%s as an int (nanoseconds) is deprecated, use a %s or int(%s)": "W008",
+}
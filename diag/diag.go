@@ -0,0 +1,249 @@
+// Package diag has the diagnostics of the compiler, the errors
+// and the lint warnings of a rule file.
+//
+// A diagnostic has a code (E201, W003, see Codes) and the range of
+// the source it is about. The stages report them to a List, which
+// prints them with the offending line underlined or as JSON for
+// the editors.
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"rips/rips/lex"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// The stages of the compiler, for the codes of the errors
+// without their own
+type Stage int
+
+const (
+	NoStage Stage = iota
+	Parse
+	Types
+	Fold
+	Levels
+)
+
+type Diagnostic struct {
+	Code     string
+	Severity Severity
+	Pos      lex.Position //where it starts
+	End      lex.Position //the last rune, zero if only Pos is known
+	Msg      string
+}
+
+// New makes a diagnostic with the code of its format, if it has one
+func New(sev Severity, pos lex.Position, end lex.Position, format string, v ...interface{}) *Diagnostic {
+	msg := strings.TrimSpace(fmt.Sprintf(format, v...))
+	return &Diagnostic{Code: Codes[format], Severity: sev, Pos: pos, End: end, Msg: msg}
+}
+
+func (d *Diagnostic) String() string {
+	s := fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Msg)
+	if d.Pos.Line == 0 {
+		return s
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.Pos.File, d.Pos.Line, d.Pos.Col, s)
+}
+
+// the runes underlined, at least one
+func (d *Diagnostic) width() int {
+	if d.End.Line != d.Pos.Line || d.End.File != d.Pos.File || d.End.Col < d.Pos.Col {
+		return 1
+	}
+	return d.End.Col - d.Pos.Col + 1
+}
+
+type Reporter interface {
+	Report(d *Diagnostic)
+}
+
+// Errorf reports an error to out if it is a Reporter, if not
+// it writes it as file:line: msg
+func Errorf(out io.Writer, pos lex.Position, end lex.Position, format string, v ...interface{}) {
+	if rep, ok := out.(Reporter); ok {
+		rep.Report(New(Error, pos, end, format, v...))
+		return
+	}
+	if lex.DOut {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "%s: "+format+"\n", append([]interface{}{pos}, v...)...)
+}
+
+// List collects the diagnostics, the errors without a code
+// get the one of the Stage
+type List struct {
+	Stage Stage
+	Diags []*Diagnostic
+}
+
+func (l *List) Report(d *Diagnostic) {
+	if d.Code == "" {
+		d.Code = fmt.Sprintf("E%d00", l.Stage)
+	}
+	l.Diags = append(l.Diags, d)
+}
+
+// Write is for the errors written as text, each line an error
+// without position
+func (l *List) Write(b []byte) (n int, err error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) != "" {
+			l.Report(&Diagnostic{Severity: Error, Msg: strings.TrimSpace(line)})
+		}
+	}
+	return len(b), nil
+}
+
+func (l *List) NErrors() (nerr int) {
+	for _, d := range l.Diags {
+		if d.Severity == Error {
+			nerr++
+		}
+	}
+	return nerr
+}
+
+// Sort orders ds by file and position
+func Sort(ds []*Diagnostic) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		pi, pj := ds[i].Pos, ds[j].Pos
+		if pi.File != pj.File {
+			return pi.File < pj.File
+		}
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Col < pj.Col
+	})
+}
+
+// The text of the files, for the snippets. The ones missing
+// are read when needed.
+type Sources map[string][]byte
+
+// the line of pos, found by its offset
+func (srcs Sources) line(pos lex.Position) (line string, ok bool) {
+	src, ok := srcs[pos.File]
+	if !ok {
+		src, _ = os.ReadFile(pos.File)
+		srcs[pos.File] = src
+	}
+	off := pos.Offset
+	if pos.Col == 0 {
+		off++ //after the '\n', at the start of the line
+	}
+	if off < 0 || off > len(src) {
+		return "", false
+	}
+	start := bytes.LastIndexByte(src[:off], '\n') + 1
+	end := bytes.IndexByte(src[off:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += off
+	}
+	return string(src[start:end]), true
+}
+
+// Span is the text from pos to end, its last rune included,
+// with the spaces and newlines of the lines joined as one space
+func (srcs Sources) Span(pos lex.Position, end lex.Position) (text string, ok bool) {
+	src, ok := srcs[pos.File]
+	if !ok {
+		src, _ = os.ReadFile(pos.File)
+		srcs[pos.File] = src
+	}
+	if end.File != pos.File || pos.Offset < 0 || end.Offset < pos.Offset || end.Offset >= len(src) {
+		return "", false
+	}
+	_, size := utf8.DecodeRune(src[end.Offset:])
+	return strings.Join(strings.Fields(string(src[pos.Offset:end.Offset+size])), " "), true
+}
+
+// Snippet is the line of d and a line with its range underlined
+//
+//	12 |	nmsg > "x" ?
+//	   |	^^^^^^^^^^
+func (d *Diagnostic) Snippet(srcs Sources) string {
+	if d.Pos.Line == 0 || srcs == nil {
+		return ""
+	}
+	line, ok := srcs.line(d.Pos)
+	if !ok {
+		return ""
+	}
+	gutter := fmt.Sprintf("%5d | ", d.Pos.Line)
+	under := strings.Repeat(" ", len(gutter)-2) + "| "
+	col := 1
+	for _, r := range line {
+		if col >= d.Pos.Col {
+			break
+		}
+		if r == '\t' {
+			under += "\t"
+		} else {
+			under += " "
+		}
+		col++
+	}
+	under += strings.Repeat("^", d.width())
+	return gutter + line + "\n" + under + "\n"
+}
+
+// Print writes ds with their snippets
+func Print(w io.Writer, ds []*Diagnostic, srcs Sources) {
+	for _, d := range ds {
+		fmt.Fprintf(w, "%s\n%s", d, d.Snippet(srcs))
+	}
+}
+
+type jsonDiag struct {
+	Code      string `json:"code"`
+	Severity  string `json:"severity"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Col       int    `json:"col"`
+	Offset    int    `json:"offset"`
+	EndLine   int    `json:"end_line"`
+	EndCol    int    `json:"end_col"`
+	EndOffset int    `json:"end_offset"`
+	Msg       string `json:"msg"`
+}
+
+// JSON is for editors and other tools (rips -lj), the end is
+// the start if it is not known
+func JSON(ds []*Diagnostic) ([]byte, error) {
+	jds := []jsonDiag{}
+	for _, d := range ds {
+		end := d.End
+		if end.Line == 0 {
+			end = d.Pos
+		}
+		jds = append(jds, jsonDiag{d.Code, d.Severity.String(), d.Pos.File, d.Pos.Line, d.Pos.Col, d.Pos.Offset,
+			end.Line, end.Col, end.Offset, d.Msg})
+	}
+	return json.MarshalIndent(jds, "", "\t")
+}
@@ -0,0 +1,128 @@
+package diag_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"rips/rips/diag"
+	"rips/rips/lex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const src = "vars:\n\tn int = 3;\nrules Msg:\n\tn > \"x\" ?\n\t\tset(n, 0);\n"
+
+func TestPrint(t *testing.T) {
+	l := &diag.List{Stage: diag.Types}
+	pos := lex.Position{File: "x.rul", Line: 4, Col: 2, Offset: 31}
+	end := lex.Position{File: "x.rul", Line: 4, Col: 8, Offset: 37}
+	diag.Errorf(l, pos, end, "incorrect trigger expression should be boolean %s", "n > \"x\"")
+	diag.Errorf(l, lex.Position{File: "x.rul", Line: 2, Col: 10, Offset: 15}, lex.Position{}, "no code %d", 1)
+	if l.NErrors() != 2 {
+		t.Fatalf("%d errors, should be 2", l.NErrors())
+	}
+	var out bytes.Buffer
+	diag.Print(&out, l.Diags, diag.Sources{"x.rul": []byte(src)})
+	want := `x.rul:4:2: error[E202]: incorrect trigger expression should be boolean n > "x"
+    4 | 	n > "x" ?
+      | 	^^^^^^^
+x.rul:2:10: error[E200]: no code 1
+    2 | 	n int = 3;
+      | 	        ^
+`
+	if out.String() != want {
+		t.Fatalf("bad print:\n%s\nshould be:\n%s", out.String(), want)
+	}
+	js, err := diag.JSON(l.Diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"code": "E202"`, `"end_col": 8`, `"end_offset": 37`, `"end_col": 10`} {
+		if !bytes.Contains(js, []byte(s)) {
+			t.Fatalf("%s should be in the json:\n%s", s, js)
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	srcs := diag.Sources{"x.rul": []byte(src)}
+	pos := lex.Position{File: "x.rul", Line: 4, Col: 2, Offset: 30}
+	end := lex.Position{File: "x.rul", Line: 4, Col: 8, Offset: 36}
+	if text, ok := srcs.Span(pos, end); !ok || text != `n > "x"` {
+		t.Fatalf("span %q, should be the condition", text)
+	}
+	//the lines are joined
+	end = lex.Position{File: "x.rul", Line: 5, Col: 12, Offset: 51}
+	if text, ok := srcs.Span(pos, end); !ok || text != `n > "x" ? set(n, 0);` {
+		t.Fatalf("span %q, should be the rule in a line", text)
+	}
+	if _, ok := srcs.Span(pos, lex.Position{File: "x.rul", Offset: 100}); ok {
+		t.Fatal("a span out of the source should fail")
+	}
+}
+
+// without a List the errors are written as before
+func TestErrorfWriter(t *testing.T) {
+	var out bytes.Buffer
+	diag.Errorf(&out, lex.Position{File: "x.rul", Line: 2, Col: 3}, lex.Position{}, "var %s set and not used", "n")
+	if out.String() != "x.rul:2: var n set and not used\n" {
+		t.Fatalf("bad error %q", out.String())
+	}
+}
+
+// the formats of the errors and warnings (the string constant
+// argument of the Errorf and warnf calls) have a code
+func TestCodes(t *testing.T) {
+	fset := token.NewFileSet()
+	fnames, _ := filepath.Glob("../tree/*.go")
+	pnames, _ := filepath.Glob("../parser/*.go")
+	nformat := 0
+	for _, fname := range append(fnames, pnames...) {
+		if strings.HasSuffix(fname, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, fname, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Errorf" && sel.Sel.Name != "warnf" {
+				return true
+			}
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == "fmt" {
+				return true
+			}
+			for _, arg := range call.Args {
+				lit, ok := arg.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				format, _ := strconv.Unquote(lit.Value)
+				if _, ok := diag.Codes[format]; !ok && format != "%s" {
+					t.Errorf("%s: %q has no code", fset.Position(lit.Pos()), format)
+				}
+				nformat++
+				break
+			}
+			return true
+		})
+	}
+	if nformat < len(diag.Codes) {
+		t.Fatalf("only %d formats found, there are %d codes", nformat, len(diag.Codes))
+	}
+	codes := map[string]string{}
+	for format, code := range diag.Codes {
+		if f, ok := codes[code]; ok {
+			t.Errorf("code %s for %q and %q", code, f, format)
+		}
+		codes[code] = format
+	}
+}
@@ -1,9 +1,12 @@
 package extern_test
 
 import (
+	"bytes"
 	"os"
 	"rips/rips/extern"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExec(t *testing.T) {
@@ -22,3 +25,33 @@ func TestExec(t *testing.T) {
 		t.Fatalf("Exec'ing false should give false for %#v\n", rosmsg)
 	}
 }
+
+func TestDeescalate(t *testing.T) {
+	context := extern.NewContext(nil, "examples/scripts", 3, os.Stderr, nil)
+	for _, l := range []string{"ALEV", "B", "C"} {
+		context.AddLevel(l)
+	}
+	out := bytes.NewBufferString("")
+	context.RConn = out
+	afters := []time.Duration{0, time.Minute, 0}
+	if !extern.Trigger(context, "B", 1, "ALEV", 0, false) {
+		t.Fatal("cannot trigger B")
+	}
+	if extern.Deescalate(context, afters, time.Now()) || context.CurrLevel != 1 {
+		t.Fatal("B should not de-escalate before it is quiet")
+	}
+	//re-triggered, the quiet period starts again
+	extern.Trigger(context, "B", 1, "B", 1, true)
+	if extern.Deescalate(context, afters, time.Now().Add(50*time.Second)) {
+		t.Fatal("B should not de-escalate after a re-trigger")
+	}
+	if !extern.Deescalate(context, afters, time.Now().Add(2*time.Minute)) || context.CurrLevel != 0 {
+		t.Fatal("B should de-escalate to ALEV after 1m")
+	}
+	if !strings.Contains(out.String(), "level: 'ALEV'") {
+		t.Fatalf("the monitor should get the new level, got %q", out.String())
+	}
+	if extern.Deescalate(context, afters, time.Now().Add(time.Hour)) {
+		t.Fatal("ALEV is the lowest level")
+	}
+}
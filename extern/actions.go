@@ -69,6 +69,27 @@ func Crash(context *Ctx, msg string) bool {
 	return true
 }
 
+// Rules with a label are enabled until they are disabled.
+func Enable(context *Ctx, rule string) bool {
+	dprintfActions("enable: %s\n", rule)
+	delete(context.Disabled, rule)
+	return true
+}
+
+func Disable(context *Ctx, rule string) bool {
+	dprintfActions("disable: %s\n", rule)
+	context.Disabled[rule] = true
+	return true
+}
+
+// The rest of the rules of the section are not evaluated for this event,
+// the actions of the current rule are.
+func Stop(context *Ctx) bool {
+	dprintfActions("stop\n")
+	context.Stopped = true
+	return true
+}
+
 const (
 	LevelFromFmt = "%s.from"
 	LevelToFmt   = "%s.to"
@@ -79,9 +100,21 @@ const (
 	R_OK = 4
 )
 
+// A change of level, From and To are the ids of the levels
+type Transition struct {
+	From int
+	To   int
+}
+
+// The changes of level declared in the transitions section.
+// If there is none (nil) trigger can escalate to any higher level
+// or de-escalate one step from a soft level.
+type Transitions map[Transition]bool
+
 func Trigger(context *Ctx, levelto string, levelidto int, levelfrom string, levelidfrom int, fromsoft bool) bool {
 	isfirst := !context.Init
 	if levelto == levelfrom && !isfirst {
+		context.LastTrigger = time.Now() //re-triggered, quiet no more
 		return true
 	}
 	if levelidto > context.NLevels || levelidto < 0 {
@@ -91,7 +124,12 @@ func Trigger(context *Ctx, levelto string, levelidto int, levelfrom string, leve
 	context.Init = true
 	tohigher := levelidto > levelidfrom || isfirst
 	tolower := fromsoft && (levelidfrom-1 == levelidto)
-	if !tohigher && !tolower {
+	if context.Transitions != nil && !isfirst {
+		if !context.Transitions[Transition{From: levelidfrom, To: levelidto}] {
+			context.Printf("trigger: error, transition %s -> %s is not declared in transitions\n", levelfrom, levelto)
+			return false
+		}
+	} else if !tohigher && !tolower {
 		context.Printf("trigger: error, trying to descalate too much %s[%d]soft:%v -> %s[%d]\n", levelfrom, levelidfrom, fromsoft, levelto, levelidto)
 		return false
 	}
@@ -126,6 +164,7 @@ func Trigger(context *Ctx, levelto string, levelidto int, levelfrom string, leve
 		return false
 	}
 	context.CurrLevel = int64(levelidto)
+	context.LastTrigger = time.Now()
 	y := yameler{w: context.RConn}
 	max := context.NLevels - 1
 	if max == 0 {
@@ -136,3 +175,32 @@ func Trigger(context *Ctx, levelto string, levelidto int, levelfrom string, leve
 	fmt.Fprintf(y, "level: '%s'\ngravity: %f", levelto, grav) //TODO think about timeouts, etc.
 	return true
 }
+
+// TriggerAction is Trigger for the trigger action of the rules,
+// saying it when the level is not changed
+func TriggerAction(context *Ctx, levelto string, levelidto int, levelfrom string, levelidfrom int, fromsoft bool) bool {
+	if Trigger(context, levelto, levelidto, levelfrom, levelidfrom, fromsoft) {
+		return true
+	}
+	context.Printf("trigger: could not change level %s[%d] -> %s[%d]\n", levelfrom, levelidfrom, levelto, levelidto)
+	return false
+}
+
+// A soft level declared with after (afters, by level) which was not
+// triggered for that long drops to the previous level, through Trigger.
+// If it fails it is tried again after another quiet period.
+func Deescalate(context *Ctx, afters []time.Duration, now time.Time) bool {
+	curr := int(context.CurrLevel)
+	if !context.Init || curr <= 0 || curr >= len(afters) || curr >= len(context.Levels) {
+		return false
+	}
+	if afters[curr] == 0 || now.Sub(context.LastTrigger) < afters[curr] {
+		return false
+	}
+	dprintfActions("deescalate: from:%s[%d] quiet for %s\n", context.Levels[curr], curr, afters[curr])
+	if !Trigger(context, context.Levels[curr-1], curr-1, context.Levels[curr], curr, true) {
+		context.LastTrigger = now
+		return false
+	}
+	return true
+}
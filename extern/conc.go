@@ -61,27 +61,97 @@ type Dispatch struct {
 	Mc       <-chan *Msg
 	Mcr      chan<- *Msg
 	Pathsc   <-chan string
+	Timers   []time.Duration //periods of the Timer sections
+	Afters   []time.Duration //quiet periods of the soft levels, by level, see Deescalate
 }
 
 const PollInterval = 200 * time.Millisecond
 
+// The periods of the timers are multiples of this, it is
+// also the shortest poll, see PollPeriod
+const TimerResolution = 10 * time.Millisecond
+
+// The section run every period
+func TimerName(period time.Duration) string {
+	return fmt.Sprintf("Timer(%s)", period)
+}
+
+func gcd(a time.Duration, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// The poll runs every PollInterval or more often, so it falls
+// on the ticks of all the timers (their gcd), but not more
+// often than every TimerResolution.
+func PollPeriod(timers []time.Duration) (tick time.Duration) {
+	tick = PollInterval
+	for _, period := range timers {
+		tick = gcd(tick, period)
+	}
+	if tick < TimerResolution {
+		tick = TimerResolution
+	}
+	return tick
+}
+
+// TimerTicks sends the period of each timer to the channel every
+// period, each one with its own ticker, until done is closed.
+// The ticks of a busy timer are dropped, like those of a time.Ticker.
+func TimerTicks(timers []time.Duration, done <-chan struct{}) <-chan time.Duration {
+	tc := make(chan time.Duration)
+	for _, period := range timers {
+		go func(period time.Duration) {
+			t := time.NewTicker(period)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+				case <-done:
+					return
+				}
+				select {
+				case tc <- period:
+				case <-done:
+					return
+				}
+			}
+		}(period)
+	}
+	return tc
+}
+
+// Every PollPeriod the program is run without message (External)
+// and every period of a timer its Timer section.
 func Dispatcher(context *Ctx, d *Dispatch) {
 	var iserr int
 	<-d.Mc //receive for kick-off from msg decoder
 
-	ct := make(chan int, 0)
-	go func() {
-		for range ct {
-			time.Sleep(PollInterval)
-		}
-	}()
-	defer close(ct)
+	poll := time.NewTicker(PollPeriod(d.Timers))
+	defer poll.Stop()
+	done := make(chan struct{})
+	defer close(done)
+	tc := TimerTicks(d.Timers, done)
 OutFor:
 	for {
 		select {
 		case path := <-d.Pathsc:
 			context.Paths[path] = true
-		case ct <- 0:
+		case period := <-tc:
+			//the expired vars and the level are updated when the program runs next
+			context.Expire(time.Now())
+			Deescalate(context, d.Afters, time.Now())
+			context.Update(nil)
+			context.Timer = TimerName(period)
+			context.Stats.Start(stats.Executing)
+			d.Coremain(context)
+			context.Stats.End(stats.Executing)
+			context.Timer = ""
+		case <-poll.C:
+			context.Expire(time.Now())
+			Deescalate(context, d.Afters, time.Now())
 			//here we will poll whatever needs to be polled
 			//call program without msg
 			context.Update(nil)
@@ -0,0 +1,49 @@
+package extern_test
+
+import (
+	"rips/rips/extern"
+	"testing"
+	"time"
+)
+
+func TestTimerTicks(t *testing.T) {
+	done := make(chan struct{})
+	tc := extern.TimerTicks([]time.Duration{20 * time.Millisecond, time.Hour}, done)
+	nticks := map[time.Duration]int{}
+	end := time.After(110 * time.Millisecond)
+For:
+	for {
+		select {
+		case period := <-tc:
+			nticks[period]++
+		case <-end:
+			break For
+		}
+	}
+	close(done)
+	if n := nticks[20*time.Millisecond]; n < 2 || n > 5 {
+		t.Fatalf("20ms timer ticked %d times in 110ms", n)
+	}
+	if n := nticks[time.Hour]; n != 0 {
+		t.Fatalf("1h timer ticked %d times in 110ms", n)
+	}
+}
+
+func TestPollPeriod(t *testing.T) {
+	tests := []struct {
+		timers []time.Duration
+		poll   time.Duration
+	}{
+		{nil, extern.PollInterval},
+		{[]time.Duration{time.Second}, extern.PollInterval},
+		{[]time.Duration{300 * time.Millisecond, 10 * time.Second}, 100 * time.Millisecond},
+		{[]time.Duration{30 * time.Millisecond}, 10 * time.Millisecond},
+		//not a multiple of TimerResolution, bounded
+		{[]time.Duration{15 * time.Millisecond}, extern.TimerResolution},
+	}
+	for _, tt := range tests {
+		if poll := extern.PollPeriod(tt.timers); poll != tt.poll {
+			t.Errorf("poll period for %v should be %s, is %s", tt.timers, tt.poll, poll)
+		}
+	}
+}
@@ -26,6 +26,16 @@ type Ctx struct {
 	Init        bool
 	Fatal       func()
 	Stats       *stats.Stats
+	Disabled    map[string]bool //labels of the disabled rules
+	Stopped     bool            //stop() was called, ends the current rule section
+	Timer       string          //the Timer section to run, "" if none, see Dispatcher
+	Windows     *Windows        //counters of count, rate and countif, nil until used
+	Expiries    *Expiries       //deadlines of the vars set with setfor, nil until used
+	LastTrigger time.Time       //the current level was triggered, see Deescalate
+	Transitions Transitions     //allowed changes of level, nil if not declared
+
+	NoMemo bool            //the pure builtins run for every call, see Memo
+	memo   map[memoKey]any //results of the pure builtins for the event, see Memo
 }
 
 func DefFatal() {
@@ -43,6 +53,7 @@ func NewContext(msg *Msg, spath string, nlevels int, errout io.Writer, stats *st
 		NLevels:     nlevels,
 		Fatal:       DefFatal,
 		Stats:       stats,
+		Disabled:    make(map[string]bool),
 	}
 }
 
@@ -56,6 +67,7 @@ func (c *Ctx) Printf(s string, v ...interface{}) (int, error) {
 
 func (context *Ctx) Update(msg *Msg) {
 	context.CurrentMsg = msg
+	context.ResetMemo()
 }
 
 func (context *Ctx) AddLevel(s string) {
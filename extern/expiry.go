@@ -0,0 +1,80 @@
+package extern
+
+import (
+	"sort"
+	"time"
+)
+
+// Variables set with setfor, by name.
+// Every tick the Dispatcher moves the variables whose deadline
+// passed to the expired ones, the program gets them with Expired
+// when it runs next and restores their declared initial value.
+// setfor again moves the deadline, set does not cancel it.
+type Expiries struct {
+	deadlines map[string]time.Time
+	expired   []string
+}
+
+func NewExpiries() *Expiries {
+	return &Expiries{deadlines: make(map[string]time.Time)}
+}
+
+func (context *Ctx) expiries() *Expiries {
+	if context.Expiries == nil {
+		context.Expiries = NewExpiries()
+	}
+	return context.Expiries
+}
+
+// the variables whose deadline is before now expire,
+// returns how many there are waiting to be restored
+func (context *Ctx) Expire(now time.Time) int {
+	es := context.Expiries
+	if es == nil {
+		return 0
+	}
+	var names []string
+	for name, dl := range es.deadlines {
+		if !dl.After(now) {
+			names = append(names, name)
+			delete(es.deadlines, name)
+		}
+	}
+	sort.Strings(names) //restored in the same order by interpreter and generated code
+	es.expired = append(es.expired, names...)
+	return len(es.expired)
+}
+
+// the expired variables, to be restored, forgotten once returned
+func (context *Ctx) Expired() (names []string) {
+	es := context.Expiries
+	if es == nil {
+		return nil
+	}
+	names, es.expired = es.expired, nil
+	return names
+}
+
+// the value of the variable name was set, it expires in d (nanoseconds)
+func SetFor(context *Ctx, name string, d int64) bool {
+	context.expiries().deadlines[name] = time.Now().Add(time.Duration(d))
+	dprintfExpr("expression SetFor: %s %s\n", name, time.Duration(d))
+	return true
+}
+
+// time (in nanoseconds) left for the variable name to expire, 0 if none
+func TTL(context *Ctx, name string) int64 {
+	if context.Expiries == nil {
+		return 0
+	}
+	dl, ok := context.Expiries.deadlines[name]
+	if !ok {
+		return 0
+	}
+	d := time.Until(dl)
+	if d < 0 {
+		d = 0
+	}
+	dprintfExpr("expression TTL: %s %s\n", name, d)
+	return int64(d)
+}
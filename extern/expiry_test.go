@@ -0,0 +1,32 @@
+package extern_test
+
+import (
+	"rips/rips/extern"
+	"testing"
+	"time"
+)
+
+func TestExpiries(t *testing.T) {
+	context := extern.NewContext(nil, "", 0, nil, nil)
+	if d := extern.TTL(context, "a"); d != 0 {
+		t.Fatalf("ttl %d of a var without setfor should be 0", d)
+	}
+	extern.SetFor(context, "b", int64(time.Minute))
+	extern.SetFor(context, "a", int64(time.Second))
+	if d := extern.TTL(context, "a"); d <= 0 || d > int64(time.Second) {
+		t.Fatalf("ttl %s should be at most 1s", time.Duration(d))
+	}
+	if n := context.Expire(time.Now()); n != 0 {
+		t.Fatalf("%d vars expired too soon", n)
+	}
+	if n := context.Expire(time.Now().Add(time.Hour)); n != 2 {
+		t.Fatalf("%d vars expired, should be 2", n)
+	}
+	names := context.Expired()
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("expired %v, should be [a b]", names)
+	}
+	if names := context.Expired(); names != nil || extern.TTL(context, "a") != 0 {
+		t.Fatalf("expired vars should be forgotten, got %v", names)
+	}
+}
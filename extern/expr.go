@@ -10,7 +10,8 @@ import (
 	"strings"
 )
 
-const DebExpr = true
+// trace of the expressions, it allocates for every call
+const DebExpr = false
 
 func dprintfExpr(s string, v ...interface{}) {
 	if !DebExpr {
@@ -38,45 +39,22 @@ func isEmpty(as []string) bool {
 	return len(as) == 1 && as[0] == ""
 }
 
-// may be repeated, etc.
-// this is the best aproximation
-func matchSubset(as []string, subset []string) bool {
-	//empty set
-	if isEmpty(subset) {
-		return true
-	}
-	amap := make(map[string]bool, len(as))
-	for _, a := range as {
-		amap[a] = true
-	}
-	for _, b := range subset {
-		if !amap[b] {
-			return false
-		}
-	}
-	return true
-}
+// The functions taking a set of strings have two versions, a variadic
+// one and one taking the set (XxxSet), the compiler folds the constant
+// arguments into a set so it is built only once, see tree/const.go.
 
-// may be repeated, etc.
-// What should be done if a=("a", "a") and b=("a")?
-// they are also not ordered
-func matchAll(as []string, bs []string) bool {
-	return matchSubset(as, bs) && matchSubset(bs, as)
+func MsgTypeIn(context *Ctx, msgtypes ...string) bool {
+	return MsgTypeInSet(context, NewStrSet(msgtypes...))
 }
 
-func MsgTypeIn(context *Ctx, msgtypes ...string) bool {
+func MsgTypeInSet(context *Ctx, msgtypes StrSet) bool {
 	dprintfExpr("expression MsgTypeIn: %s\n", msgtypes)
 	topic := context.CurrentMsg.rosm.FromTopic
 	rostype := context.RosType(topic)
 	if len(msgtypes) == 0 {
 		return true
 	}
-	for _, t := range msgtypes {
-		if rostype == t {
-			return true
-		}
-	}
-	return false
+	return msgtypes[rostype]
 }
 
 func Plugin(context *Ctx, path string) bool {
@@ -161,29 +139,29 @@ func PublisherCount(context *Ctx, min int64, max int64) bool {
 	return int64(len(pubs)) >= min && int64(len(pubs)) <= max
 }
 
-// TODO PUBLISER (and similar) convert
-//
-//	some sets of strings into maps in compilation
-//	look for matchALL
 func Publishers(context *Ctx, pubs ...string) bool {
+	return PublishersSet(context, NewStrSet(pubs...))
+}
+
+func PublishersSet(context *Ctx, pubs StrSet) bool {
 	dprintfExpr("expression Publishers: %s\n", pubs)
 	topic := context.CurrentMsg.Topic()
 	contextpubs := context.Publishers(topic)
 	dprintfExpr("-> Publishers: ros says %s\n", contextpubs)
-	return matchAll(pubs, contextpubs)
+	return matchAllSet(contextpubs, pubs)
 }
 
-// TODO (PUBLISHERSINCLUDE and similar) convert
-//
-//	some sets of strings into maps in compilation
-//	look for matchSubset
 func PublishersInclude(context *Ctx, pubs ...string) bool {
+	return PublishersIncludeSet(context, NewStrSet(pubs...))
+}
+
+func PublishersIncludeSet(context *Ctx, pubs StrSet) bool {
 	dprintfExpr("PublishersInclude: %s\n", pubs)
 	//empty set, is included
 	topic := context.CurrentMsg.Topic()
 	contextpubs := context.Publishers(topic)
 	dprintfExpr("-> PublishersInclude: ros says %s\n", contextpubs)
-	return matchSubset(contextpubs, pubs)
+	return listHasAll(contextpubs, pubs)
 }
 
 //Unimplementable
@@ -198,31 +176,42 @@ func SubscriberCount(context *Ctx, min int64, max int64) bool {
 }
 
 func Subscribers(context *Ctx, subs ...string) bool {
+	return SubscribersSet(context, NewStrSet(subs...))
+}
+func SubscribersSet(context *Ctx, subs StrSet) bool {
 	dprintfExpr("expression Subscribers: %s\n", subs)
 	topic := context.CurrentMsg.Topic()
 	contextsubs := context.Subscribers(topic)
 	dprintfExpr("-> Subscribers: ros says %s\n", contextsubs)
-	return matchAll(subs, contextsubs)
+	return matchAllSet(contextsubs, subs)
 }
 func SubscribersInclude(context *Ctx, subs ...string) bool {
+	return SubscribersIncludeSet(context, NewStrSet(subs...))
+}
+func SubscribersIncludeSet(context *Ctx, subs StrSet) bool {
 	dprintfExpr("SubscribersInclude: %s\n", subs)
 	//empty set, is included
 	topic := context.CurrentMsg.Topic()
 	contextsubs := context.Subscribers(topic)
 	dprintfExpr("-> SubscribersInclude: ros says %s\n", contextsubs)
-	return matchSubset(subs, contextsubs)
+	return setHasAll(subs, contextsubs)
 }
 func TopicIn(context *Ctx, topics ...string) (ispres bool) {
+	return TopicInSet(context, NewStrSet(topics...))
+}
+func TopicInSet(context *Ctx, topics StrSet) (ispres bool) {
 	dprintfExpr("expression TopicIn: %s\n", topics)
-	for _, t := range topics {
-		if context.CurrentMsg.Topic() == t {
-			return true
-		}
-	}
-	return false
+	return topics[context.CurrentMsg.Topic()]
 }
 
 // https://github.com/google/re2/wiki/Syntax
+// the topic of the current message, to index maps
+func TopicName(context *Ctx) string {
+	topic := context.CurrentMsg.Topic()
+	dprintfExpr("expression TopicName: %s\n", topic)
+	return topic
+}
+
 func TopicMatches(context *Ctx, restr string, re *regexp.Regexp) (ism bool) {
 	topic := context.CurrentMsg.Topic()
 	dprintfExpr("expression TopicMatches: %s for %s\n", restr, topic)
@@ -254,17 +243,25 @@ func nodeNames(nodes []RosNode) (names []string) {
 }
 
 func Nodes(context *Ctx, nodes ...string) bool {
+	return NodesSet(context, NewStrSet(nodes...))
+}
+
+func NodesSet(context *Ctx, nodes StrSet) bool {
 	dprintfExpr("expression Nodes: %s\n", nodes)
 	contextns := context.Nodes()
 	nodenames := nodeNames(contextns)
-	return matchAll(nodes, nodenames)
+	return matchAllSet(nodenames, nodes)
 }
 
 func NodesInclude(context *Ctx, nodes ...string) bool {
+	return NodesIncludeSet(context, NewStrSet(nodes...))
+}
+
+func NodesIncludeSet(context *Ctx, nodes StrSet) bool {
 	dprintfExpr("NodesInclude: %s\n", nodes)
 	contextns := context.Nodes()
 	nodenames := nodeNames(contextns)
-	return matchSubset(nodenames, nodes)
+	return listHasAll(nodenames, nodes)
 }
 func Service(context *Ctx, node string, service string) bool {
 	dprintfExpr("expression Services: %s\n", service)
@@ -286,17 +283,25 @@ func serviceNames(ss []RosService) (names []string) {
 }
 
 func Services(context *Ctx, node string, services ...string) bool {
+	return ServicesSet(context, node, NewStrSet(services...))
+}
+
+func ServicesSet(context *Ctx, node string, services StrSet) bool {
 	dprintfExpr("expression Services: %s\n", services)
 	n := context.RosNode(node)
 	servicesnames := serviceNames(n.Services)
-	return matchAll(services, servicesnames)
+	return matchAllSet(servicesnames, services)
 }
 
 func ServicesInclude(context *Ctx, node string, services ...string) bool {
+	return ServicesIncludeSet(context, node, NewStrSet(services...))
+}
+
+func ServicesIncludeSet(context *Ctx, node string, services StrSet) bool {
 	dprintfExpr("expression ServicesInclude: %s\n", services)
 	n := context.RosNode(node)
 	servicesnames := serviceNames(n.Services)
-	return matchSubset(servicesnames, services)
+	return listHasAll(servicesnames, services)
 }
 
 func TopicCount(context *Ctx, min int64, max int64) bool {
@@ -312,27 +317,43 @@ func TopicPublisherCount(context *Ctx, topic string, min int64, max int64) bool
 }
 
 func TopicPublishers(context *Ctx, topic string, pubs ...string) bool {
+	return TopicPublishersSet(context, topic, NewStrSet(pubs...))
+}
+
+func TopicPublishersSet(context *Ctx, topic string, pubs StrSet) bool {
 	dprintfExpr("expression TopicPublishers[%s]: %s\n", topic, pubs)
 	contextpubs := context.Publishers(topic)
-	return matchAll(pubs, contextpubs)
+	return matchAllSet(contextpubs, pubs)
 }
 
 func TopicPublishersInclude(context *Ctx, topic string, pubs ...string) bool {
+	return TopicPublishersIncludeSet(context, topic, NewStrSet(pubs...))
+}
+
+func TopicPublishersIncludeSet(context *Ctx, topic string, pubs StrSet) bool {
 	dprintfExpr("expression TopicPublishersInclude[%s]: %s\n", topic, pubs)
 	contextpubs := context.Publishers(topic)
-	return matchSubset(contextpubs, pubs)
+	return listHasAll(contextpubs, pubs)
 }
 
 func Topics(context *Ctx, topics ...string) bool {
+	return TopicsSet(context, NewStrSet(topics...))
+}
+
+func TopicsSet(context *Ctx, topics StrSet) bool {
 	dprintfExpr("expression Topics: %s\n", topics)
 	contexttopics := context.Topics()
-	return matchAll(topics, contexttopics)
+	return matchAllSet(contexttopics, topics)
 }
 
 func TopicsInclude(context *Ctx, topics ...string) bool {
+	return TopicsIncludeSet(context, NewStrSet(topics...))
+}
+
+func TopicsIncludeSet(context *Ctx, topics StrSet) bool {
 	dprintfExpr("expression TopicsInclude: %s\n", topics)
 	contexttopics := context.Topics()
-	return matchSubset(contexttopics, topics)
+	return listHasAll(contexttopics, topics)
 }
 
 func TopicSubscriberCount(context *Ctx, topic string, min int64, max int64) bool {
@@ -342,15 +363,28 @@ func TopicSubscriberCount(context *Ctx, topic string, min int64, max int64) bool
 }
 
 func TopicSubscribers(context *Ctx, topic string, subs ...string) bool {
+	return TopicSubscribersSet(context, topic, NewStrSet(subs...))
+}
+
+func TopicSubscribersSet(context *Ctx, topic string, subs StrSet) bool {
 	dprintfExpr("expression TopicSubscribers[%s]: %s\n", topic, subs)
 	contextsubs := context.Subscribers(topic)
-	return matchAll(subs, contextsubs)
+	return matchAllSet(contextsubs, subs)
 }
 
 func TopicSubscribersInclude(context *Ctx, topic string, subs ...string) bool {
+	return TopicSubscribersIncludeSet(context, topic, NewStrSet(subs...))
+}
+
+func TopicSubscribersIncludeSet(context *Ctx, topic string, subs StrSet) bool {
 	dprintfExpr("expression TopicSubscribersInclude[%s]: %s\n", topic, subs)
 	contextsubs := context.Subscribers(topic)
-	return matchSubset(contextsubs, subs)
+	return listHasAll(contextsubs, subs)
+}
+
+func Enabled(context *Ctx, rule string) bool {
+	dprintfExpr("expression Enabled[%s]: %v\n", rule, !context.Disabled[rule])
+	return !context.Disabled[rule]
 }
 
 func Signal(context *Ctx, sig string) bool {
@@ -210,3 +210,51 @@ func TestTopicMatches(t *testing.T) {
 		t.Fatalf("TestTopicMatches should give false for %#v\n", rosmsg)
 	}
 }
+
+//go:embed examples/onemsg3
+var onemsg3 string
+
+func TestMsgField(t *testing.T) {
+	context := extern.NewContext(nil, "", 0, os.Stderr, nil)
+	rosmsg, err := rosMsg(onemsg3)
+	if err != nil {
+		t.Fatalf("decoding: %s\n", err)
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	if v, ok := extern.MsgFieldFloat(context, "pose.position.y"); !ok || v != -2.25 {
+		t.Fatalf("MsgFieldFloat pose.position.y should be -2.25, is %f %v\n", v, ok)
+	}
+	if v, ok := extern.MsgFieldFloat(context, "pose.position.z"); !ok || v != 0 {
+		t.Fatalf("MsgFieldFloat should promote ints, is %f %v\n", v, ok)
+	}
+	if v, ok := extern.MsgFieldFloat(context, "pose.covariance[2]"); !ok || v != 0.2 {
+		t.Fatalf("MsgFieldFloat pose.covariance[2] should be 0.2, is %f %v\n", v, ok)
+	}
+	if v, ok := extern.MsgFieldFloat(context, "poses[1].position.x"); !ok || v != 4.5 {
+		t.Fatalf("MsgFieldFloat poses[1].position.x should be 4.5, is %f %v\n", v, ok)
+	}
+	if v, ok := extern.MsgFieldInt(context, "header.stamp.sec"); !ok || v != 1700000000 {
+		t.Fatalf("MsgFieldInt header.stamp.sec is %d %v\n", v, ok)
+	}
+	if v, ok := extern.MsgFieldStr(context, "header.frame_id"); !ok || v != "odom" {
+		t.Fatalf("MsgFieldStr header.frame_id is %s %v\n", v, ok)
+	}
+	if _, ok := extern.MsgFieldInt(context, "pose.position.x"); ok {
+		t.Fatalf("MsgFieldInt of a float should not be ok\n")
+	}
+	if _, ok := extern.MsgFieldFloat(context, "pose.covariance[3]"); ok {
+		t.Fatalf("MsgFieldFloat out of range should not be ok\n")
+	}
+	if !extern.MsgBool(context, "moving") || extern.MsgBool(context, "status") {
+		t.Fatalf("MsgBool moving should be true, status false\n")
+	}
+	if !extern.MsgHas(context, "poses[0]") || extern.MsgHas(context, "pose.orientation") {
+		t.Fatalf("MsgHas poses[0] should be true, pose.orientation false\n")
+	}
+	for _, p := range []string{"", ".x", "pose..x", "pose.", "poses[", "poses[a]", "poses[-1]"} {
+		if extern.IsFieldPath(p) == nil {
+			t.Fatalf("IsFieldPath %q should fail\n", p)
+		}
+	}
+}
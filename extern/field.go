@@ -0,0 +1,207 @@
+package extern
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Access to the fields of the decoded message (RosMsg.Msg).
+// A path is a dotted list of names, each one optionally followed
+// by indexes for lists, for example: "pose.position.x" or "ranges[3]"
+// or "poses[2].position.y".
+
+// For generated programs, reading a missing field or one of the
+// wrong type unwinds the whole expression, see UndefRecover.
+var ErrUndef = errors.New("undefined value")
+
+func UndefRecover(v *bool) {
+	if r := recover(); r != nil {
+		if r != ErrUndef {
+			panic(r)
+		}
+		*v = false
+	}
+}
+
+// splits the first element of the path, name is the name of the field
+// (may be empty if it starts with an index) and rest begins with '[', '.' or is empty
+func nextField(path string) (name string, rest string) {
+	i := strings.IndexAny(path, ".[")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i:]
+}
+
+func nextIndex(path string) (idx int, rest string, err error) {
+	i := strings.IndexByte(path, ']')
+	if i < 0 {
+		return 0, "", fmt.Errorf("unterminated index in %s", path)
+	}
+	idx, err = strconv.Atoi(path[1:i])
+	if err != nil || idx < 0 {
+		return 0, "", fmt.Errorf("bad index %s", path[1:i])
+	}
+	return idx, path[i+1:], nil
+}
+
+// yaml.v2 (YAML 1.1) decodes some keys as bools or ints,
+// for example the field "y" of a Pose is decoded as true.
+var yamlBools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"on": true, "On": true, "ON": true,
+	"n": false, "N": false, "no": false, "No": false, "NO": false,
+	"off": false, "Off": false, "OFF": false,
+}
+
+func lookupKey(m map[any]any, name string) (v any) {
+	if v, ok := m[name]; ok {
+		return v
+	}
+	if b, isb := yamlBools[name]; isb {
+		return m[b]
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		return m[n]
+	}
+	return nil
+}
+
+// walks the path, if v is nil only checks the syntax.
+func walkField(v any, path string) (fv any, ok bool, err error) {
+	if path == "" {
+		return nil, false, errors.New("empty path")
+	}
+	isfirst := true
+	for path != "" {
+		switch {
+		case path[0] == '[':
+			idx := 0
+			idx, path, err = nextIndex(path)
+			if err != nil {
+				return nil, false, err
+			}
+			l, isl := v.([]any)
+			if !isl || idx >= len(l) {
+				v = nil
+				break
+			}
+			v = l[idx]
+		case path[0] == '.' && !isfirst:
+			path = path[1:]
+			if path == "" || path[0] == '.' || path[0] == '[' {
+				return nil, false, errors.New("empty field name")
+			}
+			fallthrough
+		default:
+			name := ""
+			name, path = nextField(path)
+			if name == "" {
+				return nil, false, errors.New("empty field name")
+			}
+			m, ism := v.(map[any]any)
+			if !ism {
+				v = nil
+				break
+			}
+			v = lookupKey(m, name)
+		}
+		isfirst = false
+	}
+	return v, v != nil, nil
+}
+
+// Compile time check for paths which are constant
+func IsFieldPath(path string) (err error) {
+	_, _, err = walkField(nil, path)
+	return err
+}
+
+func (m *Msg) Field(path string) (v any, ok bool) {
+	if m == nil || m.rosm == nil {
+		return nil, false
+	}
+	v, ok, err := walkField(m.rosm.Msg, path)
+	if err != nil {
+		return nil, false
+	}
+	return v, ok
+}
+
+func MsgFieldInt(context *Ctx, path string) (v int64, ok bool) {
+	dprintfExpr("expression MsgInt: %s\n", path)
+	fv, _ := context.CurrentMsg.Field(path)
+	switch n := fv.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// ints are promoted to float, yaml does not keep "1.0" as float
+func MsgFieldFloat(context *Ctx, path string) (v float64, ok bool) {
+	dprintfExpr("expression MsgFloat: %s\n", path)
+	fv, _ := context.CurrentMsg.Field(path)
+	switch n := fv.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func MsgFieldStr(context *Ctx, path string) (v string, ok bool) {
+	dprintfExpr("expression MsgStr: %s\n", path)
+	fv, _ := context.CurrentMsg.Field(path)
+	v, ok = fv.(string)
+	return v, ok
+}
+
+func MsgInt(context *Ctx, path string) int64 {
+	v, ok := MsgFieldInt(context, path)
+	if !ok {
+		panic(ErrUndef)
+	}
+	return v
+}
+
+func MsgFloat(context *Ctx, path string) float64 {
+	v, ok := MsgFieldFloat(context, path)
+	if !ok {
+		panic(ErrUndef)
+	}
+	return v
+}
+
+func MsgStr(context *Ctx, path string) string {
+	v, ok := MsgFieldStr(context, path)
+	if !ok {
+		panic(ErrUndef)
+	}
+	return v
+}
+
+// not a bool is false
+func MsgBool(context *Ctx, path string) bool {
+	dprintfExpr("expression MsgBool: %s\n", path)
+	fv, _ := context.CurrentMsg.Field(path)
+	b, _ := fv.(bool)
+	return b
+}
+
+func MsgHas(context *Ctx, path string) bool {
+	dprintfExpr("expression MsgHas: %s\n", path)
+	_, ok := context.CurrentMsg.Field(path)
+	return ok
+}
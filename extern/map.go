@@ -0,0 +1,105 @@
+package extern
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Maps of string to a value, for state per topic, node...
+// The keys come from messages and the graph, so each map is bounded,
+// to the keys given in its declaration or to MaxMapKeys, and the
+// least recently set key is dropped. Reading a key does not change
+// the order, the reads can be reordered (see tree.isSafe).
+// A missing key has the zero value, like in Go.
+const MaxMapKeys = 1024
+
+type mapEntry[V any] struct {
+	key string
+	val V
+}
+
+type Map[V any] struct {
+	elems   map[string]*list.Element
+	lru     *list.List //front is the most recently set
+	maxkeys int
+}
+
+func NewMap[V any](maxkeys int) *Map[V] {
+	if maxkeys <= 0 {
+		maxkeys = MaxMapKeys
+	}
+	return &Map[V]{elems: make(map[string]*list.Element), lru: list.New(), maxkeys: maxkeys}
+}
+
+func (m *Map[V]) Get(key string) (v V) {
+	e, ok := m.elems[key]
+	if !ok {
+		return v
+	}
+	return e.Value.(*mapEntry[V]).val
+}
+
+func (m *Map[V]) Set(key string, v V) {
+	if e, ok := m.elems[key]; ok {
+		e.Value.(*mapEntry[V]).val = v
+		m.lru.MoveToFront(e)
+		return
+	}
+	if len(m.elems) >= m.maxkeys {
+		oldest := m.lru.Back()
+		delete(m.elems, oldest.Value.(*mapEntry[V]).key)
+		m.lru.Remove(oldest)
+	}
+	m.elems[key] = m.lru.PushFront(&mapEntry[V]{key, v})
+}
+
+func (m *Map[V]) Has(key string) bool {
+	_, ok := m.elems[key]
+	return ok
+}
+
+func (m *Map[V]) Delete(key string) {
+	if e, ok := m.elems[key]; ok {
+		delete(m.elems, key)
+		m.lru.Remove(e)
+	}
+}
+
+func (m *Map[V]) Len() int {
+	return len(m.elems)
+}
+
+// sorted by key, so it is the same in the interpreter and the generated code
+func (m *Map[V]) String() string {
+	keys := make([]string, 0, len(m.elems))
+	for k := range m.elems {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		v := m.elems[k].Value.(*mapEntry[V]).val
+		if s, ok := any(v).(string); ok {
+			keys[i] = fmt.Sprintf("%q: %q", k, s)
+		} else {
+			keys[i] = fmt.Sprintf("%q: %v", k, v)
+		}
+	}
+	return "{" + strings.Join(keys, ", ") + "}"
+}
+
+func HasKey[V any](context *Ctx, m *Map[V], key string) bool {
+	v := m.Has(key)
+	dprintfExpr("expression HasKey: %s %v\n", key, v)
+	return v
+}
+
+func Delete[V any](context *Ctx, m *Map[V], key string) bool {
+	m.Delete(key)
+	return true
+}
+
+func MapLen[V any](context *Ctx, m *Map[V]) int64 {
+	return int64(m.Len())
+}
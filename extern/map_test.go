@@ -0,0 +1,50 @@
+package extern_test
+
+import (
+	"fmt"
+	"rips/rips/extern"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	m := extern.NewMap[int64](0)
+	if v := m.Get("a"); v != 0 || m.Has("a") {
+		t.Fatalf("missing key should be zero, got %d", v)
+	}
+	m.Set("b", 2)
+	m.Set("a", 1)
+	m.Set("b", 3)
+	if m.Get("b") != 3 || m.Len() != 2 {
+		t.Fatalf("map should have 2 keys, b should be 3: %s", m)
+	}
+	if s := m.String(); s != `{"a": 1, "b": 3}` {
+		t.Fatalf("bad string for map %s", s)
+	}
+	m.Delete("a")
+	if m.Has("a") || m.Len() != 1 {
+		t.Fatalf("a should be deleted: %s", m)
+	}
+}
+
+func TestMapBound(t *testing.T) {
+	m := extern.NewMap[string](0)
+	for i := 0; i < extern.MaxMapKeys; i++ {
+		m.Set(fmt.Sprint(i), "x")
+	}
+	m.Get("1")      //reading does not change the order
+	m.Set("0", "z") //set again, the least recently set is 1
+	m.Set("new", "y")
+	if m.Len() != extern.MaxMapKeys {
+		t.Fatalf("%d keys, should be bounded by %d", m.Len(), extern.MaxMapKeys)
+	}
+	if !m.Has("0") || m.Has("1") || m.Get("new") != "y" {
+		t.Fatal("the least recently set key should be dropped")
+	}
+	small := extern.NewMap[int64](2)
+	for i := 0; i < 5; i++ {
+		small.Set(fmt.Sprint(i), int64(i))
+	}
+	if small.Len() != 2 || !small.Has("3") || !small.Has("4") {
+		t.Fatalf("the map should keep its own bound of 2 keys: %s", small)
+	}
+}
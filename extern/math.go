@@ -0,0 +1,143 @@
+package extern
+
+import (
+	"math"
+)
+
+// Math builtins, they may be evaluated statically.
+// NaN and Inf are errors, like dividing by zero, see CheckFloat.
+
+func IsBadFloat(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0)
+}
+
+// pos is file:line of the expression
+func CheckFloat(context *Ctx, pos string, v float64) float64 {
+	if IsBadFloat(v) {
+		context.Printf("%s error evaluating, undefined behaviour: result is %v\n", pos, v)
+		context.Fatal()
+	}
+	return v
+}
+
+// The float v converted to an int or a uint is not NaN, Inf or
+// out of their range (go gives an undefined value).
+func FitsInt(v float64) bool {
+	return v >= -(1<<63) && v < 1<<63
+}
+
+func FitsUint(v float64) bool {
+	return v > -1 && v < 1<<64
+}
+
+// int(v) and uint(v) of a float, the values which do not fit
+// are an error, like in CheckFloat. pos is file:line.
+func FloatInt(context *Ctx, pos string, v float64) int64 {
+	if !FitsInt(v) {
+		context.Printf("%s error evaluating, undefined behaviour: %v does not fit in an int\n", pos, v)
+		context.Fatal()
+		return 0
+	}
+	return int64(v)
+}
+
+func FloatUint(context *Ctx, pos string, v float64) uint64 {
+	if !FitsUint(v) {
+		context.Printf("%s error evaluating, undefined behaviour: %v does not fit in a uint\n", pos, v)
+		context.Fatal()
+		return 0
+	}
+	return uint64(v)
+}
+
+// An int shift count, negative counts are an error, like in the
+// interpreter (go panics). pos is file:line of the expression.
+func ShiftCount(context *Ctx, pos string, n int64) uint64 {
+	if n < 0 {
+		context.Printf("%s error evaluating, undefined behaviour: runtime error: negative shift amount\n", pos)
+		context.Fatal()
+		return 0
+	}
+	return uint64(n)
+}
+
+// float % float, go does not have it
+func FMod(x float64, y float64) float64 {
+	return math.Mod(x, y)
+}
+
+func AbsInt(context *Ctx, x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func AbsUint(context *Ctx, x uint64) uint64 {
+	return x
+}
+
+func AbsFloat(context *Ctx, x float64) float64 {
+	return math.Abs(x)
+}
+
+func MinInt(context *Ctx, x int64, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func MinUint(context *Ctx, x uint64, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func MinFloat(context *Ctx, x float64, y float64) float64 {
+	return math.Min(x, y)
+}
+
+func MaxInt(context *Ctx, x int64, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func MaxUint(context *Ctx, x uint64, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func MaxFloat(context *Ctx, x float64, y float64) float64 {
+	return math.Max(x, y)
+}
+
+func Sqrt(context *Ctx, x float64) float64 {
+	return math.Sqrt(x)
+}
+
+func Pow(context *Ctx, x float64, y float64) float64 {
+	return math.Pow(x, y)
+}
+
+func Floor(context *Ctx, x float64) float64 {
+	return math.Floor(x)
+}
+
+func Ceil(context *Ctx, x float64) float64 {
+	return math.Ceil(x)
+}
+
+// half away from zero
+func Round(context *Ctx, x float64) float64 {
+	return math.Round(x)
+}
+
+func Hypot(context *Ctx, x float64, y float64) float64 {
+	return math.Hypot(x, y)
+}
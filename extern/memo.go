@@ -0,0 +1,62 @@
+package extern
+
+// Results of the builtins which are pure for an event (they give
+// the same for the same message and args, like payload or plugin),
+// by builtin and args. A program calling them in several rules runs
+// the YARA scan or the plugin once. The memo is emptied by Update,
+// before each run of the program.
+type memoKey struct {
+	fn   string
+	str  string //the arg of MemoStr
+	args [MaxMemoArgs]any
+}
+
+// calls with more args are not memoized
+const MaxMemoArgs = 3
+
+// Memo is f(args...), computed once for the event for the builtin
+// fn and args. The args are comparable (strings, numbers, bools),
+// f should not have side effects. With NoMemo it is always run.
+func Memo[T any](context *Ctx, fn string, f func(args ...any) T, args ...any) T {
+	if context == nil || context.NoMemo || len(args) > MaxMemoArgs {
+		return f(args...)
+	}
+	key := memoKey{fn: fn}
+	copy(key.args[:], args)
+	if v, ok := context.memo[key]; ok {
+		return v.(T)
+	}
+	v := f(args...)
+	context.memoize(key, v)
+	return v
+}
+
+// MemoStr is Memo for the builtins of one string arg (the paths of
+// the msg fields), the arg is not boxed so a call found in the
+// memo does not allocate.
+func MemoStr[T any](context *Ctx, fn string, f func(arg string) T, arg string) T {
+	if context == nil || context.NoMemo {
+		return f(arg)
+	}
+	key := memoKey{fn: fn, str: arg}
+	if v, ok := context.memo[key]; ok {
+		return v.(T)
+	}
+	v := f(arg)
+	context.memoize(key, v)
+	return v
+}
+
+func (context *Ctx) memoize(key memoKey, v any) {
+	if context.memo == nil {
+		context.memo = make(map[memoKey]any)
+	}
+	context.memo[key] = v
+}
+
+// ResetMemo forgets the results of the last event, keeping the map
+func (context *Ctx) ResetMemo() {
+	for k := range context.memo {
+		delete(context.memo, k)
+	}
+}
@@ -0,0 +1,40 @@
+package extern_test
+
+import (
+	"rips/rips/extern"
+	"testing"
+)
+
+func TestMemo(t *testing.T) {
+	context := extern.NewContext(nil, "", 0, nil, nil)
+	ncalls := 0
+	f := func(args ...any) int64 {
+		ncalls++
+		return args[0].(int64) * 2
+	}
+	for i := 0; i < 3; i++ {
+		if v := extern.Memo(context, "double", f, int64(4)); v != 8 {
+			t.Fatalf("memo gave %d, should be 8", v)
+		}
+	}
+	extern.Memo(context, "double", f, int64(5))
+	extern.Memo(context, "twice", f, int64(4))
+	if ncalls != 3 {
+		t.Fatalf("%d calls, should be 3, one by builtin and args", ncalls)
+	}
+	context.Update(nil)
+	extern.Memo(context, "double", f, int64(4))
+	extern.Memo(context, "double", f, int64(1), int64(2), int64(3), int64(4))
+	extern.Memo(context, "double", f, int64(1), int64(2), int64(3), int64(4))
+	if ncalls != 6 {
+		t.Fatalf("%d calls, should be 6, the memo is for one event and a few args", ncalls)
+	}
+	g := func(path string) int64 {
+		ncalls++
+		return int64(len(path))
+	}
+	extern.MemoStr(context, "len", g, "a.b")
+	if v := extern.MemoStr(context, "len", g, "a.b"); v != 3 || ncalls != 7 {
+		t.Fatalf("memo of a string gave %d in %d calls, should be 3 in 7", v, ncalls)
+	}
+}
@@ -0,0 +1,109 @@
+package extern
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sets of strings, constant sets are built once at compile time
+// (see tree/const.go) and never modified, operations create new sets.
+type StrSet map[string]bool
+
+func NewStrSet(elems ...string) (s StrSet) {
+	s = make(StrSet, len(elems))
+	for _, e := range elems {
+		s[e] = true
+	}
+	return s
+}
+
+// sorted, so it is the same in the interpreter and the generated code
+func (s StrSet) String() string {
+	elems := make([]string, 0, len(s))
+	for e := range s {
+		elems = append(elems, e)
+	}
+	sort.Strings(elems)
+	for i, e := range elems {
+		elems[i] = "\"" + e + "\""
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
+func SetUnion(s1 StrSet, s2 StrSet) (s StrSet) {
+	s = make(StrSet, len(s1)+len(s2))
+	for e := range s1 {
+		s[e] = true
+	}
+	for e := range s2 {
+		s[e] = true
+	}
+	return s
+}
+
+func SetInter(s1 StrSet, s2 StrSet) (s StrSet) {
+	s = make(StrSet)
+	for e := range s1 {
+		if s2[e] {
+			s[e] = true
+		}
+	}
+	return s
+}
+
+func SetEq(s1 StrSet, s2 StrSet) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for e := range s1 {
+		if !s2[e] {
+			return false
+		}
+	}
+	return true
+}
+
+func SetLen(context *Ctx, s StrSet) int64 {
+	return int64(len(s))
+}
+
+// decoding artifact, empty may be one empty string (see isEmpty)
+func isEmptySet(s StrSet) bool {
+	return len(s) == 0 || len(s) == 1 && s[""]
+}
+
+// may be repeated, etc. they are also not ordered
+// all the elements of subset are in as, empty subset is included
+func listHasAll(as []string, subset StrSet) bool {
+	if isEmptySet(subset) {
+		return true
+	}
+	n := 0
+	for e := range subset {
+		for _, a := range as {
+			if a == e {
+				n++
+				break
+			}
+		}
+	}
+	return n == len(subset)
+}
+
+// all the elements of subset are in as, empty subset is included
+func setHasAll(as StrSet, subset []string) bool {
+	if isEmpty(subset) {
+		return true
+	}
+	for _, b := range subset {
+		if !as[b] {
+			return false
+		}
+	}
+	return true
+}
+
+// What should be done if as=("a", "a") and bs=("a")? they match.
+func matchAllSet(as []string, bs StrSet) bool {
+	return listHasAll(as, bs) && setHasAll(bs, as)
+}
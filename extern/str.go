@@ -0,0 +1,65 @@
+package extern
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String builtins, they may be evaluated statically,
+// indexes are in bytes, like in Go.
+
+func Contains(context *Ctx, s string, substr string) bool {
+	return strings.Contains(s, substr)
+}
+
+func HasPrefix(context *Ctx, s string, prefix string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+func HasSuffix(context *Ctx, s string, suffix string) bool {
+	return strings.HasSuffix(s, suffix)
+}
+
+func StrLen(context *Ctx, s string) int64 {
+	return int64(len(s))
+}
+
+func Lower(context *Ctx, s string) string {
+	return strings.ToLower(s)
+}
+
+func Upper(context *Ctx, s string) string {
+	return strings.ToUpper(s)
+}
+
+// n bytes from start, clamped to the string
+func Substr(context *Ctx, s string, start int64, n int64) string {
+	if start < 0 {
+		start = 0
+	}
+	if start > int64(len(s)) {
+		start = int64(len(s))
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n > int64(len(s))-start {
+		n = int64(len(s)) - start
+	}
+	return s[start : start+n]
+}
+
+// all the occurrences
+func Replace(context *Ctx, s string, old string, new string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// number of fields of s separated by sep
+func SplitCount(context *Ctx, s string, sep string) int64 {
+	return int64(len(strings.Split(s, sep)))
+}
+
+// like fmt.Sprintf, durations and times are Duration and Timestamp
+func Format(context *Ctx, format string, args ...any) string {
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,42 @@
+package extern_test
+
+import (
+	"rips/rips/extern"
+	"testing"
+)
+
+func TestSubstr(t *testing.T) {
+	tests := []struct {
+		s        string
+		start, n int64
+		sub      string
+	}{
+		{"/turtle1/pose", 1, 7, "turtle1"},
+		{"/turtle1/pose", 9, 100, "pose"},
+		{"/turtle1/pose", -3, 2, "/t"},
+		{"/turtle1/pose", 20, 2, ""},
+		{"/turtle1/pose", 2, -1, ""},
+		{"", 0, 1, ""},
+	}
+	for _, tt := range tests {
+		if sub := extern.Substr(nil, tt.s, tt.start, tt.n); sub != tt.sub {
+			t.Errorf("substr(%q, %d, %d) = %q, should be %q", tt.s, tt.start, tt.n, sub, tt.sub)
+		}
+	}
+}
+
+func TestSplitCount(t *testing.T) {
+	tests := []struct {
+		s, sep string
+		n      int64
+	}{
+		{"/turtle1/pose", "/", 3},
+		{"a.b.c", "/", 1},
+		{"", ".", 1},
+	}
+	for _, tt := range tests {
+		if n := extern.SplitCount(nil, tt.s, tt.sep); n != tt.n {
+			t.Errorf("splitcount(%q, %q) = %d, should be %d", tt.s, tt.sep, n, tt.n)
+		}
+	}
+}
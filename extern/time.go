@@ -0,0 +1,34 @@
+package extern
+
+import (
+	"time"
+)
+
+// Durations and times are nanoseconds (int64) in the rules,
+// these types are only to print them, see String.
+type Duration int64
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// since the epoch, like time.Now().UnixNano()
+type Timestamp int64
+
+func (t Timestamp) String() string {
+	return time.Unix(0, int64(t)).Format(time.RFC3339)
+}
+
+// in local time, 0 to 23
+func Hour(context *Ctx, t int64) int64 {
+	h := time.Unix(0, t).Hour()
+	dprintfExpr("expression Hour: %d\n", h)
+	return int64(h)
+}
+
+// in local time, 0 is sunday
+func Weekday(context *Ctx, t int64) int64 {
+	wd := time.Unix(0, t).Weekday()
+	dprintfExpr("expression Weekday: %d\n", wd)
+	return int64(wd)
+}
@@ -0,0 +1,225 @@
+package extern
+
+import (
+	"container/list"
+	"time"
+)
+
+// Sliding windows for count, rate and countif.
+// Each counter divides its window in NWinBuckets buckets, the
+// events of a bucket are forgotten when it falls out of the window,
+// so the count is approximate to the size of a bucket.
+const NWinBuckets = 10
+
+// Bound on the memory, the least recently used key is dropped
+const MaxWinCounters = 4096
+
+// The kinds of keys, so the topics, the keys of count and the
+// rules do not collide
+const (
+	WinKey = iota
+	WinTopic
+	WinRule
+)
+
+type winKey struct {
+	kind int
+	key  string
+}
+
+type winCounter struct {
+	window time.Duration
+	width  int64              //of a bucket, in nanoseconds
+	slots  [NWinBuckets]int64 //time/width of the events counted in the bucket
+	counts [NWinBuckets]int64
+}
+
+func newWinCounter(window time.Duration) (wc *winCounter) {
+	width := int64(window) / NWinBuckets
+	if width <= 0 { //windows shorter than NWinBuckets nanoseconds
+		width = 1
+	}
+	wc = &winCounter{window: window, width: width}
+	for i := range wc.slots {
+		wc.slots[i] = -1
+	}
+	return wc
+}
+
+func (wc *winCounter) add(now time.Time) {
+	slot := now.UnixNano() / wc.width
+	i := slot % NWinBuckets
+	if wc.slots[i] != slot {
+		wc.slots[i] = slot
+		wc.counts[i] = 0
+	}
+	wc.counts[i]++
+}
+
+func (wc *winCounter) count(now time.Time) (n int64) {
+	slot := now.UnixNano() / wc.width
+	for i, s := range wc.slots {
+		if s >= 0 && s <= slot && slot-s < NWinBuckets {
+			n += wc.counts[i]
+		}
+	}
+	return n
+}
+
+// The counters of a key, one for each window asked for it
+type winEntry struct {
+	key      winKey
+	counters []*winCounter
+	event    int64 //the last event counted, see NewEvent
+}
+
+// The keys are kept in a list, the most recently used first,
+// to drop the last one in constant time.
+type Windows struct {
+	entries map[winKey]*list.Element
+	lru     *list.List
+	event   int64 //the current event, see NewEvent
+}
+
+func NewWindows() *Windows {
+	return &Windows{entries: make(map[winKey]*list.Element), lru: list.New()}
+}
+
+func (ws *Windows) entry(key winKey) *winEntry {
+	if el, ok := ws.entries[key]; ok {
+		ws.lru.MoveToFront(el)
+		return el.Value.(*winEntry)
+	}
+	if ws.lru.Len() >= MaxWinCounters {
+		last := ws.lru.Back()
+		delete(ws.entries, last.Value.(*winEntry).key)
+		ws.lru.Remove(last)
+	}
+	we := &winEntry{key: key, event: -1}
+	ws.entries[key] = ws.lru.PushFront(we)
+	return we
+}
+
+// NewEvent starts a new event (a message, a run of the timers),
+// each key is added at most once in it.
+func (ws *Windows) NewEvent() {
+	ws.event++
+}
+
+// Add one event of key at now to all its windows, if it was
+// not added already in the current event.
+func (ws *Windows) Add(kind int, key string, now time.Time) {
+	we := ws.entry(winKey{kind, key})
+	if we.event == ws.event {
+		return
+	}
+	we.event = ws.event
+	for _, wc := range we.counters {
+		wc.add(now)
+	}
+}
+
+// Count is the number of events of key in the window ending at
+// now. The events are counted from the first time a window is
+// asked for the key, the one of the current event included.
+func (ws *Windows) Count(kind int, key string, window time.Duration, now time.Time) int64 {
+	we := ws.entry(winKey{kind, key})
+	for _, wc := range we.counters {
+		if wc.window == window {
+			return wc.count(now)
+		}
+	}
+	wc := newWinCounter(window)
+	if we.event == ws.event {
+		wc.add(now)
+	}
+	we.counters = append(we.counters, wc)
+	return wc.count(now)
+}
+
+func (ws *Windows) Len() int {
+	return ws.lru.Len()
+}
+
+func (context *Ctx) windows() *Windows {
+	if context.Windows == nil {
+		context.Windows = NewWindows()
+	}
+	return context.Windows
+}
+
+// WindowEvent starts the event of a run of the program and counts
+// the current message for its topic, see Rate. It is called once
+// for each run, before the rules. Only the topics rate was asked
+// for are counted, the others would take the place of the keys
+// of count and countif.
+func WindowEvent(context *Ctx) {
+	ws := context.windows()
+	ws.NewEvent()
+	msg := context.CurrentMsg
+	if msg == nil || msg.Type() != "Msg" {
+		return
+	}
+	if _, ok := ws.entries[winKey{WinTopic, msg.Topic()}]; ok {
+		ws.Add(WinTopic, msg.Topic(), time.Now())
+	}
+}
+
+// the constant windows are checked by Fold, the others here
+func badWindow(context *Ctx, name string, window int64) bool {
+	if window > 0 {
+		return false
+	}
+	context.Printf("%s: error evaluating, window %v is not positive\n", name, time.Duration(window))
+	context.Fatal()
+	return true
+}
+
+// counts an event for key, once for the run, and returns the
+// number of events of key in the last window (in nanoseconds)
+func Count(context *Ctx, key string, window int64) int64 {
+	if badWindow(context, "count", window) {
+		return 0
+	}
+	ws := context.windows()
+	now := time.Now()
+	ws.Add(WinKey, key, now)
+	n := ws.Count(WinKey, key, time.Duration(window), now)
+	dprintfExpr("expression Count: %s %d\n", key, n)
+	return n
+}
+
+// messages on the topic of the current message in the last window,
+// they are counted by WindowEvent once rate is asked for the topic
+func Rate(context *Ctx, window int64) int64 {
+	if badWindow(context, "rate", window) {
+		return 0
+	}
+	msg := context.CurrentMsg
+	topic := ""
+	if msg != nil {
+		topic = msg.Topic()
+	}
+	ws := context.windows()
+	now := time.Now()
+	if msg != nil && msg.Type() == "Msg" {
+		ws.Add(WinTopic, topic, now) //the first time, see WindowEvent
+	}
+	n := ws.Count(WinTopic, topic, time.Duration(window), now)
+	dprintfExpr("expression Rate: %s %d\n", topic, n)
+	return n
+}
+
+// activations of the rule identified by rule in the last window:
+// the runs getting to its countif, once for the run
+func CountIf(context *Ctx, window int64, rule string) int64 {
+	if badWindow(context, "countif", window) {
+		return 0
+	}
+	ws := context.windows()
+	now := time.Now()
+	ws.Add(WinRule, rule, now)
+	n := ws.Count(WinRule, rule, time.Duration(window), now)
+	dprintfExpr("expression CountIf: %s %d\n", rule, n)
+	return n
+}
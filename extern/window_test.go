@@ -0,0 +1,131 @@
+package extern_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"rips/rips/extern"
+	"strings"
+	"testing"
+	"time"
+)
+
+// an event of key at now, counted in the window
+func addCount(ws *extern.Windows, key string, window time.Duration, now time.Time) int64 {
+	ws.NewEvent()
+	ws.Add(extern.WinKey, key, now)
+	return ws.Count(extern.WinKey, key, window, now)
+}
+
+func TestWindows(t *testing.T) {
+	ws := extern.NewWindows()
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		now := t0.Add(time.Duration(i) * 100 * time.Millisecond)
+		if n := addCount(ws, "a", time.Second, now); n != int64(i+1) {
+			t.Fatalf("count %d should be %d", n, i+1)
+		}
+	}
+	//the first events fall out of the window
+	if n := addCount(ws, "a", time.Second, t0.Add(1250*time.Millisecond)); n != 3 {
+		t.Fatalf("count %d should be 3 after the window", n)
+	}
+	if n := addCount(ws, "a", time.Second, t0.Add(10*time.Second)); n != 1 {
+		t.Fatalf("count %d should be 1 long after the window", n)
+	}
+	//another window is another counter, starting with this event
+	if n := ws.Count(extern.WinKey, "a", time.Minute, t0.Add(10*time.Second)); n != 1 {
+		t.Fatalf("count %d should be 1 for a new window", n)
+	}
+	//the same key of another kind is another one
+	if n := ws.Count(extern.WinRule, "a", time.Second, t0.Add(10*time.Second)); n != 0 {
+		t.Fatalf("count %d should be 0 for a rule", n)
+	}
+}
+
+func TestWindowsEvent(t *testing.T) {
+	ws := extern.NewWindows()
+	t0 := time.Unix(1000, 0)
+	ws.NewEvent()
+	ws.Count(extern.WinTopic, "/a", time.Second, t0)
+	for i := 0; i < 3; i++ {
+		ws.Add(extern.WinTopic, "/a", t0)
+	}
+	//reading does not count
+	for i := 0; i < 3; i++ {
+		if n := ws.Count(extern.WinTopic, "/a", time.Second, t0); n != 1 {
+			t.Fatalf("count %d should be 1, once for the event", n)
+		}
+	}
+	ws.NewEvent()
+	ws.Add(extern.WinTopic, "/a", t0)
+	if n := ws.Count(extern.WinTopic, "/a", time.Second, t0); n != 2 {
+		t.Fatalf("count %d should be 2 after two events", n)
+	}
+}
+
+func TestWindowsBound(t *testing.T) {
+	ws := extern.NewWindows()
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < extern.MaxWinCounters+10; i++ {
+		addCount(ws, fmt.Sprint(i), time.Second, t0.Add(time.Duration(i)))
+	}
+	if ws.Len() != extern.MaxWinCounters {
+		t.Fatalf("%d counters, should be bounded by %d", ws.Len(), extern.MaxWinCounters)
+	}
+	//the oldest were dropped
+	if n := addCount(ws, "0", time.Second, t0.Add(time.Millisecond)); n != 1 {
+		t.Fatalf("count %d should be 1 for a dropped counter", n)
+	}
+	//a used one is kept
+	addCount(ws, "20", time.Second, t0.Add(time.Millisecond))
+	for i := 0; i < extern.MaxWinCounters-1; i++ {
+		addCount(ws, fmt.Sprint("b", i), time.Second, t0.Add(time.Millisecond))
+	}
+	if n := addCount(ws, "20", time.Second, t0.Add(2*time.Millisecond)); n != 3 {
+		t.Fatalf("count %d should be 3 for a recently used counter", n)
+	}
+}
+
+func TestWindowsRate(t *testing.T) {
+	context := extern.NewContext(nil, "", 0, os.Stderr, nil)
+	for _, topic := range []string{"/a", "/b"} {
+		context.Update(extern.NewMsg(&extern.RosMsg{Event: "message", FromTopic: topic}))
+		extern.WindowEvent(context)
+	}
+	//no rate, no topics taking the place of the keys
+	if n := context.Windows.Len(); n != 0 {
+		t.Fatalf("%d topics counted without rate", n)
+	}
+	if n := extern.Rate(context, int64(time.Second)); n != 1 {
+		t.Fatalf("rate %d should be 1, counting from the first call", n)
+	}
+	for i := 0; i < 3; i++ {
+		extern.WindowEvent(context)
+	}
+	if n := extern.Rate(context, int64(time.Second)); n != 4 {
+		t.Fatalf("rate %d should be 4 after the events", n)
+	}
+	if n := context.Windows.Len(); n != 1 {
+		t.Fatalf("%d topics counted, only /b has a rate", n)
+	}
+}
+
+func TestWindowsBad(t *testing.T) {
+	out := bytes.NewBufferString("")
+	context := extern.NewContext(nil, "", 0, out, nil)
+	nfatal := 0
+	context.Fatal = func() { nfatal++ }
+	extern.Rate(context, -int64(5*time.Second))
+	extern.Count(context, "a", 0)
+	extern.CountIf(context, -1, "rule")
+	if nfatal != 3 {
+		t.Fatalf("%d errors for 3 bad windows", nfatal)
+	}
+	if !strings.Contains(out.String(), "rate: error evaluating, window -5s is not positive") {
+		t.Fatalf("bad error %q", out.String())
+	}
+	if context.Windows != nil && context.Windows.Len() != 0 {
+		t.Fatal("bad windows should not be counted")
+	}
+}
@@ -0,0 +1,118 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3 //lines around the changes
+
+type edit struct {
+	op   byte //' ', '-' or '+'
+	line string
+}
+
+// the edits from a to b, by the longest common subsequence
+// (rule files are small)
+func edits(a, b []string) (es []edit) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			es = append(es, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			es = append(es, edit{'-', a[i]})
+			i++
+		default:
+			es = append(es, edit{'+', b[j]})
+			j++
+		}
+	}
+	return es
+}
+
+func lines(src []byte) []string {
+	s := strings.TrimSuffix(string(src), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Diff is a unified diff from a to b, empty if they are equal.
+func Diff(name string, a, b []byte) []byte {
+	es := edits(lines(a), lines(b))
+	var out bytes.Buffer
+	for start := 0; start < len(es); {
+		for start < len(es) && es[start].op == ' ' {
+			start++
+		}
+		if start == len(es) {
+			break
+		}
+		//the hunk ends when there are more than 2*diffContext equal lines
+		end, neq := start, 0
+		for i := start; i < len(es) && neq <= 2*diffContext; i++ {
+			if es[i].op == ' ' {
+				neq++
+				continue
+			}
+			neq = 0
+			end = i + 1
+		}
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(es) {
+			to = len(es)
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", name, name)
+		}
+		aline, bline := 1, 1
+		for _, e := range es[:from] {
+			if e.op != '+' {
+				aline++
+			}
+			if e.op != '-' {
+				bline++
+			}
+		}
+		na, nb := 0, 0
+		for _, e := range es[from:to] {
+			if e.op != '+' {
+				na++
+			}
+			if e.op != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aline, na, bline, nb)
+		for _, e := range es[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+		start = to
+	}
+	return out.Bytes()
+}
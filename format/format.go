@@ -0,0 +1,396 @@
+// Package format prints rule files in the canonical layout (rips fmt).
+//
+// The file is read with the comments as tokens and the blank
+// lines are remembered, so both survive. The layout is:
+//
+//	section headers at the start of the line, a blank line before them
+//	one declaration or level per line, indented with a tab
+//	the condition of a rule (and its label) in a line ending in " ?"
+//	one action per line, indented with two tabs, the connectors
+//	=> and !> at the start of the line and , at the end
+//
+// Nothing is reordered, the order of the levels is their meaning.
+// Comments of their own line stay before what follows them and
+// the ones at the end of a line stay at the end of it.
+// At most one blank line is kept between declarations or rules.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"rips/rips/diag"
+	"rips/rips/lex"
+	"rips/rips/parser"
+	"sort"
+	"strings"
+)
+
+type comment struct {
+	text    string
+	col     int
+	isblank bool //a blank line before it
+}
+
+// a token with the comments around it
+type tok struct {
+	lex.Token
+	comments []comment //of their own line, before the token
+	trailing []string  //after the token, in the same line
+	isblank  bool      //a blank line before it (after its comments)
+}
+
+type formatter struct {
+	fname string
+	toks  []*tok
+	n     int
+	out   bytes.Buffer
+
+	insect bool //some section was printed
+}
+
+// all the tokens to the eof (the last one), with the comments attached
+func tokens(fname string, src []byte) (toks []*tok, err error) {
+	l, err := lex.NewLexerRd(bytes.NewReader(src), fname, nil)
+	if err != nil {
+		return nil, err
+	}
+	l.KeepComments = true
+	var comments []comment
+	lastline := 0
+	for {
+		t, err := l.Lex()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", l.Pos(), err)
+		}
+		isblank := lastline != 0 && t.Pos.Line > lastline+1
+		if t.Type == lex.TokComment {
+			if n := len(toks); n > 0 && t.Pos.Line == lastline && len(comments) == 0 {
+				toks[n-1].trailing = append(toks[n-1].trailing, t.Lexema)
+			} else {
+				comments = append(comments, comment{t.Lexema, t.Pos.Col, isblank})
+			}
+			lastline = t.Pos.Line
+			continue
+		}
+		toks = append(toks, &tok{Token: t, comments: comments, isblank: isblank})
+		comments = nil
+		lastline = t.Pos.Line
+		if t.Type == lex.TokEof {
+			return toks, nil
+		}
+	}
+}
+
+// Source returns src in the canonical layout, an error if it is
+// not a rule file. It is parsed first (with its includes, which are
+// not formatted) and rejected with the errors of the parser.
+func Source(fname string, src []byte) (out []byte, err error) {
+	if err = parse(fname, src); err != nil {
+		return nil, err
+	}
+	toks, err := tokens(fname, src)
+	if err != nil {
+		return nil, err
+	}
+	f := &formatter{fname: fname, toks: toks}
+	if err = f.prog(); err != nil {
+		return nil, err
+	}
+	out = f.out.Bytes()
+	if err = sameTokens(fname, src, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// the errors of the parser, with their lines
+func parse(fname string, src []byte) error {
+	dl := &diag.List{Stage: diag.Parse}
+	parser.ParseBuffer(fname, src, dl)
+	if dl.NErrors() == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	diag.Print(&out, dl.Diags, diag.Sources{fname: src})
+	return errors.New(strings.TrimSuffix(out.String(), "\n"))
+}
+
+func (f *formatter) peek() *tok {
+	return f.toks[f.n]
+}
+
+func (f *formatter) next() (t *tok) {
+	t = f.toks[f.n]
+	if t.Type != lex.TokEof {
+		f.n++
+	}
+	return t
+}
+
+func (f *formatter) errorf(t *tok, str string, v ...interface{}) error {
+	return fmt.Errorf("%s: %s", t.Pos, fmt.Sprintf(str, v...))
+}
+
+func (f *formatter) blank() {
+	if f.out.Len() > 0 && !bytes.HasSuffix(f.out.Bytes(), []byte("\n\n")) {
+		f.out.WriteString("\n")
+	}
+}
+
+// the comments of their own line before what is printed next
+func (f *formatter) comments(cs []comment, indent string, isfirst bool) {
+	for i, c := range cs {
+		if c.isblank && (i > 0 || !isfirst) {
+			f.blank()
+		}
+		f.out.WriteString(indent + c.text + "\n")
+	}
+}
+
+// The comments before a section, an include or the eof.
+// The indented ones first are of the end of the previous section,
+// the rest (returned) are not indented.
+func (f *formatter) topComments(t *tok) (cs []comment) {
+	cs = t.comments
+	t.comments = nil
+	n := 0
+	for f.insect && n < len(cs) && cs[n].col > 1 {
+		n++
+	}
+	f.comments(cs[:n], "\t", false)
+	return cs[n:]
+}
+
+// a line with the tokens, the comments of the tokens after the
+// first go before it and their trailing ones at its end
+func (f *formatter) line(indent string, toks []*tok, isfirst bool) {
+	var cs []comment
+	var trailing []string
+	for i, t := range toks {
+		if i > 0 {
+			for _, c := range t.comments {
+				c.isblank = false
+				c.col = 0
+				cs = append(cs, c)
+			}
+		}
+		trailing = append(trailing, t.trailing...)
+	}
+	f.comments(toks[0].comments, indent, isfirst)
+	if toks[0].isblank && !isfirst {
+		f.blank()
+	}
+	f.comments(cs, indent, true)
+	f.out.WriteString(indent + join(toks))
+	if len(trailing) > 0 {
+		f.out.WriteString(" " + strings.Join(trailing, " "))
+	}
+	f.out.WriteString("\n")
+}
+
+func isSection(t lex.TokType) bool {
+	switch t {
+	case lex.TokLevels, lex.TokTransitions, lex.TokVars, lex.TokConsts, lex.TokFuncs, lex.TokRules:
+		return true
+	}
+	return false
+}
+
+func (f *formatter) prog() (err error) {
+	for {
+		t := f.peek()
+		switch {
+		case t.Type == lex.TokEof:
+			cs := f.topComments(t)
+			f.comments(cs, "", false)
+			return nil
+		case t.Type == lex.TokInclude:
+			cs := f.topComments(t)
+			f.comments(cs, "", false)
+			toks, err := f.upTo(lex.TokSemi)
+			if err != nil {
+				return err
+			}
+			f.line("", toks, false)
+		case isSection(t.Type):
+			if err = f.section(); err != nil {
+				return err
+			}
+		default:
+			return f.errorf(t, "unexpected %s, expected a section", t.Lexema)
+		}
+	}
+}
+
+// the tokens until end (included), outside parenthesis
+func (f *formatter) upTo(end ...lex.TokType) (toks []*tok, err error) {
+	depth := 0
+	for {
+		t := f.next()
+		switch t.Type {
+		case lex.TokEof:
+			return nil, f.errorf(t, "unexpected eof")
+		case lex.TokLPar, lex.TokLBrack, lex.TokLBrace:
+			depth++
+		case lex.TokRPar, lex.TokRBrack, lex.TokRBrace:
+			depth--
+			if depth < 0 {
+				return nil, f.errorf(t, "unbalanced %s", t.Lexema)
+			}
+		}
+		toks = append(toks, t)
+		if depth > 0 {
+			continue
+		}
+		for _, e := range end {
+			if t.Type == e {
+				return toks, nil
+			}
+		}
+	}
+}
+
+func (f *formatter) section() (err error) {
+	hdr := f.peek()
+	cs := f.topComments(hdr)
+	f.blank()
+	f.comments(cs, "", true)
+	if len(cs) > 0 && hdr.isblank {
+		f.blank()
+	}
+	toks, err := f.upTo(lex.TokColon)
+	if err != nil {
+		return err
+	}
+	f.line("", toks, true)
+	f.insect = true
+	for isfirst := true; ; isfirst = false {
+		t := f.peek()
+		if t.Type == lex.TokEof || t.Type == lex.TokInclude || isSection(t.Type) {
+			return nil
+		}
+		if hdr.Type != lex.TokRules {
+			toks, err = f.upTo(lex.TokSemi)
+			if err != nil {
+				return err
+			}
+			f.line("\t", toks, isfirst)
+			continue
+		}
+		if err = f.rule(isfirst); err != nil {
+			return err
+		}
+	}
+}
+
+// the condition in a line, the actions in the next ones
+func (f *formatter) rule(isfirst bool) (err error) {
+	cond, err := f.upTo(lex.TokQuest)
+	if err != nil {
+		return err
+	}
+	f.line("\t", cond, isfirst)
+	var conn *tok //=> or !>, starts the next action
+	for {
+		act, err := f.upTo(lex.TokThen, lex.TokNThen, lex.TokComma, lex.TokSemi)
+		if err != nil {
+			return err
+		}
+		end := act[len(act)-1]
+		if len(act) == 1 {
+			return f.errorf(end, "missing action before %s", end.Lexema)
+		}
+		if conn != nil {
+			act = append([]*tok{conn}, act...)
+			conn = nil
+		}
+		if end.Type == lex.TokThen || end.Type == lex.TokNThen {
+			act = act[:len(act)-1]
+			conn = end
+		}
+		f.line("\t\t", act, true)
+		if end.Type == lex.TokSemi {
+			return nil
+		}
+	}
+}
+
+func isOperand(t *tok) bool {
+	switch t.Type {
+	case lex.TokId, lex.TokIntVal, lex.TokFloatVal, lex.TokStrVal, lex.TokBoolVal,
+		lex.TokDurVal, lex.TokUintVal, lex.TokRPar, lex.TokRBrack, lex.TokRBrace:
+		return true
+	}
+	return false
+}
+
+// the tokens separated by a space, but for calls, indexing,
+// parenthesis, unary operators and punctuation
+func join(toks []*tok) string {
+	s := ""
+	for i, t := range toks {
+		if i > 0 && hasSpace(toks, i) {
+			s += " "
+		}
+		s += t.Lexema
+	}
+	return s
+}
+
+func hasSpace(toks []*tok, i int) bool {
+	a, b := toks[i-1], toks[i]
+	switch b.Type {
+	case lex.TokRPar, lex.TokRBrack, lex.TokRBrace, lex.TokComma, lex.TokSemi, lex.TokColon, lex.TokLBrack:
+		return false
+	case lex.TokLPar:
+		if a.Type == lex.TokId {
+			return false
+		}
+	}
+	switch a.Type {
+	case lex.TokLPar, lex.TokLBrack, lex.TokLBrace, lex.TokLogNeg, lex.TokCompl:
+		return false
+	case lex.TokRBrack:
+		return b.Type != lex.TokId //map[string]int
+	case lex.TokMin:
+		return i > 1 && isOperand(toks[i-2])
+	}
+	return true
+}
+
+// the output has to be the same tokens and comments as the input,
+// comments may be moved before a line
+func sameTokens(fname string, src []byte, out []byte) error {
+	stoks, err := tokens(fname, src)
+	if err != nil {
+		return err
+	}
+	otoks, err := tokens(fname, out)
+	if err != nil {
+		return fmt.Errorf("formatted file does not lex: %s", err)
+	}
+	if len(stoks) != len(otoks) {
+		return fmt.Errorf("%s: formatting changed the number of tokens", fname)
+	}
+	var scs, ocs []string
+	for i, st := range stoks {
+		if st.Type != otoks[i].Type || st.Lexema != otoks[i].Lexema {
+			return fmt.Errorf("%s: formatting changed %s to %s", st.Pos, st.Lexema, otoks[i].Lexema)
+		}
+		for _, c := range st.comments {
+			scs = append(scs, c.text)
+		}
+		for _, c := range otoks[i].comments {
+			ocs = append(ocs, c.text)
+		}
+		scs = append(scs, st.trailing...)
+		ocs = append(ocs, otoks[i].trailing...)
+	}
+	sort.Strings(scs)
+	sort.Strings(ocs)
+	if strings.Join(scs, "\n") != strings.Join(ocs, "\n") {
+		return fmt.Errorf("%s: formatting changed the comments", fname)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package format_test
+
+import (
+	"os"
+	"path/filepath"
+	"rips/rips/format"
+	"strings"
+	"testing"
+)
+
+const messy = `#!/bin/rips
+levels:
+  A;   # first
+
+
+  B soft after 5m;
+
+vars: x int=-1;   y int=-x+ 2*-3;
+  m map [ string ] int={};
+rules Msg first:
+  # a rule
+  x>0 && (y<0 || # why
+   !(x in {1,2})) ?
+  trigger(B) → set(x,x-1) ↛ set(y,0),set(m["a"],1);
+
+
+
+rules Timer( 1s ):
+  rule r: true? True(1);
+# eof comment
+`
+
+const canonical = `#!/bin/rips
+levels:
+	A; # first
+
+	B soft after 5m;
+
+vars:
+	x int = -1;
+	y int = -x + 2 * -3;
+	m map[string]int = {};
+
+rules Msg first:
+	# a rule
+	x > 0 && (y < 0 || !(x in {1, 2})) ? # why
+		trigger(B)
+		=> set(x, x - 1)
+		!> set(y, 0),
+		set(m["a"], 1);
+
+rules Timer(1s):
+	rule r: true ?
+		True(1);
+# eof comment
+`
+
+func TestSource(t *testing.T) {
+	out, err := format.Source("messy.rul", []byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != canonical {
+		t.Fatalf("bad format:\n%s", format.Diff("messy.rul", []byte(canonical), out))
+	}
+	_, err = format.Source("bad.rul", []byte("vars:\n\tx int = (1;\n"))
+	if err == nil || !strings.Contains(err.Error(), "bad.rul:") {
+		t.Fatalf("unbalanced parenthesis should fail with the position, got %v", err)
+	}
+	//the tokens are fine, but it is not a rule file
+	_, err = format.Source("bad.rul", []byte("vars:\n\tx int = 1;\nrules Msg:\n\tx > ?\n\t\tset(x, 0);\n"))
+	if err == nil || !strings.Contains(err.Error(), "bad.rul:4:") || !strings.Contains(err.Error(), "error[E1") {
+		t.Fatalf("a bad condition should fail with the errors of the parser, got %v", err)
+	}
+}
+
+// formatted twice is the same
+func TestExamples(t *testing.T) {
+	fnames, err := filepath.Glob("../xrips/examples/*.rul")
+	if err != nil || len(fnames) == 0 {
+		t.Fatalf("no examples: %v", err)
+	}
+	for _, fname := range fnames {
+		src, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := format.Source(fname, src)
+		if err != nil {
+			if strings.Contains(filepath.Base(fname), "err") {
+				continue //syntax errors
+			}
+			t.Fatal(err)
+		}
+		out2, err := format.Source(fname, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := format.Diff(fname, out, out2); len(d) != 0 {
+			t.Errorf("formatting again changes it:\n%s", d)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	diff := `--- f.orig
++++ f
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if d := format.Diff("f", []byte(a), []byte(b)); string(d) != diff {
+		t.Fatalf("bad diff:\n%s", d)
+	}
+	if d := format.Diff("f", []byte(a), []byte(a)); len(d) != 0 {
+		t.Fatalf("equal files should have no diff:\n%s", d)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -27,6 +29,9 @@ type RuneScanner interface {
 type Position struct {
 	File string
 	Line int
+	Col  int //of the last rune read, 0 at the start of a line
+	//in bytes from the start of the file, of the last rune read
+	Offset int
 }
 
 type LexPeeker interface {
@@ -34,17 +39,36 @@ type LexPeeker interface {
 	Lex() (t Token, err error)
 	LexWhileNot(tps ...TokType) (t Token, err error)
 	Pos() Position
+	End() Position
 	Errout() io.Writer
+	Include(fname string) error
+}
+
+// where the lexer was when it started with an included file
+type inclSource struct {
+	pos  Position
+	next int
+	r    RuneScanner
+	f    *os.File
 }
 
 type Lexer struct {
 	pos      Position
 	r        RuneScanner
+	f        *os.File //nil if not opened by the lexer
 	lastrune rune
+	prevpos  Position  //before the last rune, for unget
+	next     int       //offset of the next rune
+	end      Position  //of the last token lexed, not peeked
 	errout   io.Writer //for errors (used mainly by the parser)
 
+	KeepComments bool //comments are TokComment tokens (for rips fmt), not skipped
+
 	accepted []rune
 	tokSaved *Token
+
+	incls    []inclSource    //stack of the files including the current one
+	included map[string]bool //all the files read, to include them only once
 }
 
 type TokType rune
@@ -71,10 +95,16 @@ const (
 	TokComma    = TokType(',')
 	TokLogNeg   = TokType('!')
 	TokCompl    = TokType('~')
+	TokLBrace   = TokType('{')
+	TokRBrace   = TokType('}')
+	TokLBrack   = TokType('[')
+	TokRBrack   = TokType(']')
 	TokFloatVal = TokType(unicode.MaxRune + 1 + iota)
 	TokIntVal
 	TokStrVal
 	TokBoolVal
+	TokDurVal  // 500ms, 1h30m
+	TokUintVal // 0xff, 0o17, 0b101
 	TokId
 	TokLogAnd // &&
 	TokLogOr  // ||
@@ -82,22 +112,33 @@ const (
 	TokNEq    // !=
 	TokGEq    // >=
 	TokLEq    // <=
+	TokLShift // <<
+	TokRShift // >>
 	TokThen   // =>
 	TokNThen  // !>
+	TokArrow  // ->
+	TokIn     // in
 	TokLevels
 	TokSoft
+	TokTransitions
 	TokVars
 	TokConsts
+	TokFuncs
 	TokRules
+	TokRule
+	TokInclude
+	TokComment // # until the end of the line
 	TokEof
 	RuneEof = -1
 )
 
 type Token struct {
+	Pos         Position //where it starts
+	End         Position //its last rune
 	Lexema      string
 	Type        TokType
 	TokFloatVal float64
-	TokIntVal   int64
+	TokIntVal   int64 //also for TokDurVal, in nanoseconds, and TokUintVal (the bits)
 	TokStrVal   string
 	TokBoolVal  bool
 }
@@ -114,6 +155,10 @@ func TypeName(tT TokType) string {
 		return "FloatVal"
 	case TokIntVal:
 		return "IntVal"
+	case TokDurVal:
+		return "DurVal"
+	case TokUintVal:
+		return "UintVal"
 	case TokStrVal:
 		return "StrVal"
 	case TokId:
@@ -168,8 +213,24 @@ func TypeName(tT TokType) string {
 		return "TokThen"
 	case TokNThen:
 		return "TokNThen"
+	case TokArrow:
+		return "TokArrow"
+	case TokIn:
+		return "TokIn"
+	case TokLBrace:
+		return "TokLBrace"
+	case TokRBrace:
+		return "TokRBrace"
+	case TokLBrack:
+		return "TokLBrack"
+	case TokRBrack:
+		return "TokRBrack"
 	case TokLEq:
 		return "TokLEq"
+	case TokLShift:
+		return "TokLShift"
+	case TokRShift:
+		return "TokRShift"
 	case TokLogNeg:
 		return "TokLogNeg"
 	case TokBad:
@@ -178,28 +239,50 @@ func TypeName(tT TokType) string {
 		return "TokLevels"
 	case TokSoft:
 		return "TokSoft"
+	case TokTransitions:
+		return "TokTransitions"
 	case TokVars:
 		return "TokVars"
+	case TokFuncs:
+		return "TokFuncs"
 	case TokRules:
 		return "TokRules"
+	case TokRule:
+		return "TokRule"
+	case TokInclude:
+		return "TokInclude"
+	case TokComment:
+		return "TokComment"
 	default:
 		return "TokUnk"
 	}
 }
 
 var keywords = map[string]Token{
-	"true":   {Type: TokBoolVal, TokBoolVal: true},
-	"false":  {Type: TokBoolVal, TokBoolVal: false},
-	"levels": {Type: TokLevels},
-	"soft":   {Type: TokSoft},
-	"vars":   {Type: TokVars},
-	"consts": {Type: TokConsts},
-	"rules":  {Type: TokRules},
+	"true":        {Type: TokBoolVal, TokBoolVal: true},
+	"false":       {Type: TokBoolVal, TokBoolVal: false},
+	"levels":      {Type: TokLevels},
+	"soft":        {Type: TokSoft},
+	"transitions": {Type: TokTransitions},
+	"vars":        {Type: TokVars},
+	"consts":      {Type: TokConsts},
+	"funcs":       {Type: TokFuncs},
+	"rules":       {Type: TokRules},
+	"rule":        {Type: TokRule},
+	"include":     {Type: TokInclude},
+	"in":          {Type: TokIn},
 }
 
 func (l *Lexer) Pos() Position {
 	return l.pos
 }
+
+// End is where the last token lexed ends, unlike Pos
+// it is not moved by Peek
+func (l *Lexer) End() Position {
+	return l.end
+}
+
 func (l *Lexer) Errout() io.Writer {
 	return l.errout
 }
@@ -222,6 +305,10 @@ func (t Token) String() string {
 		s += fmt.Sprintf(" %v", t.TokBoolVal)
 	case TokIntVal:
 		s += fmt.Sprintf(" %d", t.TokIntVal)
+	case TokDurVal:
+		s += fmt.Sprintf(" %s", time.Duration(t.TokIntVal))
+	case TokUintVal:
+		s += fmt.Sprintf(" %d", uint64(t.TokIntVal))
 	case TokFloatVal:
 		s += fmt.Sprintf(" %f", t.TokFloatVal)
 	case TokStrVal:
@@ -233,15 +320,20 @@ func (t Token) String() string {
 }
 
 func (l *Lexer) get() (r rune) {
-	var err error
-	r, _, err = l.r.ReadRune()
+	r, size, err := l.r.ReadRune()
 	if err == nil {
 		if DGet {
 			fmt.Fprintf(os.Stderr, "Lex: get: %c\n", r)
 		}
 		l.lastrune = r
+		l.prevpos = l.pos
+		l.pos.Offset = l.next
+		l.next += size
 		if r == '\n' {
 			l.pos.Line++
+			l.pos.Col = 0
+		} else {
+			l.pos.Col++
 		}
 	}
 	if err == io.EOF {
@@ -264,8 +356,9 @@ func (l *Lexer) unget() {
 		fmt.Fprintf(os.Stderr, "Lex: unget: %c\n", l.lastrune)
 	}
 	err = l.r.UnreadRune()
-	if err == nil && l.lastrune == '\n' {
-		l.pos.Line--
+	if err == nil {
+		l.next = l.pos.Offset
+		l.pos = l.prevpos
 	}
 	l.lastrune = unicode.ReplacementChar
 	if len(l.accepted) != 0 {
@@ -282,9 +375,59 @@ func NewLexerRd(r RuneScanner, fname string, errout io.Writer) (l *Lexer, err er
 	l = &Lexer{pos: pos, errout: errout}
 	l.pos.File = fname
 	l.r = r
+	l.included = map[string]bool{filepath.Clean(fname): true}
 	return l, nil
 }
 
+// The lexer continues with the file fname (relative to the
+// current one) and goes back to the current one at its end.
+// The files including it are not reincluded (there would be a cycle),
+// and a file cannot be included twice.
+func (l *Lexer) Include(fname string) (err error) {
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(filepath.Dir(l.pos.File), fname)
+	}
+	fname = filepath.Clean(fname)
+	var files []string
+	for _, incl := range l.incls {
+		files = append(files, filepath.Clean(incl.pos.File))
+	}
+	files = append(files, filepath.Clean(l.pos.File))
+	for i, f := range files {
+		if f == fname {
+			chain := strings.Join(append(files[i:], fname), " -> ")
+			return fmt.Errorf("include cycle: %s", chain)
+		}
+	}
+	if l.included[fname] {
+		return fmt.Errorf("file %s already included", fname)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	l.included[fname] = true
+	l.incls = append(l.incls, inclSource{pos: l.pos, next: l.next, r: l.r, f: l.f})
+	l.pos = Position{File: fname, Line: 1}
+	l.next = 0
+	l.r = bufio.NewReader(f)
+	l.f = f
+	return nil
+}
+
+// at the end of an included file
+func (l *Lexer) popInclude() bool {
+	if len(l.incls) == 0 {
+		return false
+	}
+	l.f.Close()
+	incl := l.incls[len(l.incls)-1]
+	l.incls = l.incls[:len(l.incls)-1]
+	l.pos, l.next, l.r, l.f = incl.pos, incl.next, incl.r, incl.f
+	l.lastrune = unicode.ReplacementChar
+	return true
+}
+
 func NewLexer(fname string, errout io.Writer) (l *Lexer, err error) {
 	if DToks {
 		fmt.Fprintf(os.Stderr, "newLex\n")
@@ -388,6 +531,26 @@ func isdigitBased(r rune, tnum rune) bool {
 	return false
 }
 
+// first rune of the units of a duration, see time.ParseDuration
+const durUnits = "nuµmsh"
+
+// The number and the first rune of the unit are accepted,
+// the rest may be more units and numbers (1h30m)
+func (l *Lexer) lexDuration() (t Token, err error) {
+	r := l.get()
+	for ; unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.'; r = l.get() {
+	}
+	l.unget()
+	t.Lexema = l.accept()
+	d, err := time.ParseDuration(t.Lexema)
+	if err != nil {
+		return t, errors.New("bad duration [" + t.Lexema + "]")
+	}
+	t.TokIntVal = int64(d)
+	t.Type = TokDurVal
+	return t, nil
+}
+
 func (l *Lexer) lexNum() (t Token, err error) {
 	const (
 		Es    = "Ee"
@@ -420,6 +583,9 @@ func (l *Lexer) lexNum() (t Token, err error) {
 		for r = l.get(); unicode.IsDigit(r); r = l.get() {
 		}
 	}
+	if !isquote && strings.ContainsRune(durUnits, r) {
+		return l.lexDuration()
+	}
 	switch {
 	case strings.ContainsRune(Es, r):
 		r = l.get()
@@ -432,8 +598,15 @@ func (l *Lexer) lexNum() (t Token, err error) {
 	case !hasDot: //may be an int
 		l.unget()
 		if isquote {
+			//hex, octal and binary are unsigned
 			t.Lexema = l.accept()
-			t.TokIntVal, err = strconv.ParseInt(t.Lexema, 0, 64)
+			u, err := strconv.ParseUint(t.Lexema, 0, 64)
+			if err != nil {
+				return t, errors.New("bad uint [" + t.Lexema + "]")
+			}
+			t.TokIntVal = int64(u)
+			t.Type = TokUintVal
+			return t, nil
 		} else {
 			t.Lexema = l.accept()
 			t.TokIntVal, err = strconv.ParseInt(t.Lexema, 10, 64)
@@ -462,7 +635,9 @@ func (l *Lexer) lexNum() (t Token, err error) {
 }
 
 func (l *Lexer) Peek() (t Token, err error) {
+	end := l.end
 	t, err = l.Lex()
+	l.end = end
 	if err == nil {
 		l.tokSaved = &t
 	}
@@ -472,19 +647,18 @@ func (l *Lexer) Peek() (t Token, err error) {
 	return t, err
 }
 
-func (l *Lexer) eatComment() {
-	// comment, eat until eol included
-	// do not eat eof
+// the # is already read, the comment goes until the end of
+// the line, neither the '\n' nor the eof are eaten
+func (l *Lexer) eatComment() (t Token) {
 	for rx := l.get(); ; rx = l.get() {
-		if rx == RuneEof {
+		if rx == RuneEof || rx == '\n' {
 			l.unget()
 			break
 		}
-		l.accept()
-		if rx == '\n' {
-			break
-		}
 	}
+	t.Type = TokComment
+	t.Lexema = strings.TrimRightFunc(l.accept(), unicode.IsSpace)
+	return t
 }
 
 func (l *Lexer) mayLexDouble(t *Token) (err error) {
@@ -500,8 +674,14 @@ func (l *Lexer) mayLexDouble(t *Token) (err error) {
 		t.Type = TokGEq
 	case "<=":
 		t.Type = TokLEq
+	case "<<":
+		t.Type = TokLShift
+	case ">>":
+		t.Type = TokRShift
 	case "=>":
 		t.Type = TokThen
+	case "->":
+		t.Type = TokArrow
 	case "&&":
 		t.Type = TokLogAnd
 	case "||":
@@ -561,8 +741,15 @@ func (l *Lexer) Lex() (t Token, err error) {
 		}
 	}()
 	if t, ok := l.takeSaved(); ok {
+		l.end = t.End
 		return t, nil
 	}
+	var start Position
+	defer func() {
+		t.Pos = start
+		t.End = l.pos
+		l.end = t.End
+	}()
 LoopTok:
 	for r := l.get(); ; r = l.get() {
 		if DFlex {
@@ -572,6 +759,7 @@ LoopTok:
 			l.accept()
 			continue
 		}
+		start = l.pos
 
 		switch r {
 		case arrowThen, arrowNThen: //Synonyms
@@ -579,20 +767,26 @@ LoopTok:
 			t = synomTok[r]
 			return t, nil
 		case '#':
-			l.eatComment()
+			t = l.eatComment()
+			if l.KeepComments {
+				return t, nil
+			}
 			continue
-		case '(', ')', '*', '/', '+', '-', '^', ',', '%', ';', ':', '?', '~':
+		case '(', ')', '*', '/', '+', '^', ',', '%', ';', ':', '?', '~', '{', '}', '[', ']':
 			t.Type = TokType(r)
 			t.Lexema = l.accept()
 			return t, nil
-		case '=', '!', '>', '<', '&', '|':
+		case '=', '!', '>', '<', '&', '|', '-':
 			t.Type = TokType(r)
 			err = l.mayLexDouble(&t)
 			t.Lexema = l.accept()
 			return t, err
 		case RuneEof:
-			t.Type = TokEof
 			l.accept()
+			if l.popInclude() {
+				continue
+			}
+			t.Type = TokEof
 			return t, nil
 		case '"':
 			l.unget()
@@ -652,6 +846,10 @@ func (t UTokType) String() string {
 		return "TokFloatVal"
 	case TokIntVal:
 		return "TokIntVal"
+	case TokDurVal:
+		return "TokDurVal"
+	case TokUintVal:
+		return "TokUintVal"
 	case TokStrVal:
 		return "TokStrVal"
 	case TokId:
@@ -704,8 +902,24 @@ func (t UTokType) String() string {
 		return "=>"
 	case TokNThen:
 		return "!>"
+	case TokArrow:
+		return "->"
+	case TokIn:
+		return "in"
+	case TokLBrace:
+		return "{"
+	case TokRBrace:
+		return "}"
+	case TokLBrack:
+		return "["
+	case TokRBrack:
+		return "]"
 	case TokLEq:
 		return "<="
+	case TokLShift:
+		return "<<"
+	case TokRShift:
+		return ">>"
 	case TokLogNeg:
 		return "!"
 	case TokBad:
@@ -714,12 +928,22 @@ func (t UTokType) String() string {
 		return "Levels"
 	case TokSoft:
 		return "Soft"
+	case TokTransitions:
+		return "Transitions"
 	case TokVars:
 		return "Vars"
 	case TokConsts:
 		return "Consts"
+	case TokFuncs:
+		return "Funcs"
 	case TokRules:
 		return "Rules"
+	case TokRule:
+		return "Rule"
+	case TokInclude:
+		return "Include"
+	case TokComment:
+		return "Comment"
 	default:
 		return "TokUnk"
 	}
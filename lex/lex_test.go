@@ -31,8 +31,8 @@ var genToks = []tokExamp{
 	{"", []lex.TokType{lex.TokEof}},
 	{"3.8e-12", []lex.TokType{lex.TokFloatVal}},
 	{"69", []lex.TokType{lex.TokIntVal}},
-	{"0x16", []lex.TokType{lex.TokIntVal}},
-	{"0xff", []lex.TokType{lex.TokIntVal}},
+	{"0x16", []lex.TokType{lex.TokUintVal}},
+	{"0xff", []lex.TokType{lex.TokUintVal}},
 	{`"hola\nperola"`, []lex.TokType{lex.TokStrVal}},
 	{`"hola\tperola"`, []lex.TokType{lex.TokStrVal}},
 	{`"hola\t"`, []lex.TokType{lex.TokStrVal}},
@@ -43,6 +43,11 @@ var genToks = []tokExamp{
 	{`"hola\"otro"`, []lex.TokType{lex.TokStrVal}},
 	{"true", []lex.TokType{lex.TokBoolVal}},
 	{"==", []lex.TokType{lex.TokEq}},
+	{"<<", []lex.TokType{lex.TokLShift}},
+	{"A -> B", []lex.TokType{lex.TokId, lex.TokArrow, lex.TokId}},
+	{"a-1", []lex.TokType{lex.TokId, lex.TokMin, lex.TokIntVal}},
+	{">>", []lex.TokType{lex.TokRShift}},
+	{"1<<3", []lex.TokType{lex.TokIntVal, lex.TokLShift, lex.TokIntVal}},
 	{"=", []lex.TokType{lex.TokAsig}},
 	{"=,", []lex.TokType{lex.TokAsig}},
 	{">=", []lex.TokType{lex.TokGEq}},
@@ -53,6 +58,14 @@ var genToks = []tokExamp{
 	{"→", []lex.TokType{lex.TokThen}},
 	{"#hola hola\n>", []lex.TokType{lex.TokG}},
 	{"~", []lex.TokType{lex.TokCompl}},
+	{`{"a", "b"}`, []lex.TokType{lex.TokLBrace, lex.TokStrVal, lex.TokComma, lex.TokStrVal, lex.TokRBrace}},
+	{`m[topic()]`, []lex.TokType{lex.TokId, lex.TokLBrack, lex.TokId, lex.TokLPar, lex.TokRPar, lex.TokRBrack}},
+	{`"a" in x`, []lex.TokType{lex.TokStrVal, lex.TokIn, lex.TokId}},
+	{"inside", []lex.TokType{lex.TokId}},
+	{"funcs:", []lex.TokType{lex.TokFuncs, lex.TokColon}},
+	{"rule x:", []lex.TokType{lex.TokRule, lex.TokId, lex.TokColon}},
+	{"rulex", []lex.TokType{lex.TokId}},
+	{`include "a.rul";`, []lex.TokType{lex.TokInclude, lex.TokStrVal, lex.TokSemi}},
 	{"\xff\n>", []lex.TokType{lex.TokBad}},      //bad rune token
 	{`"\xff\n>"`, []lex.TokType{lex.TokStrVal}}, //bad rune inside string (valid)
 }
@@ -182,14 +195,17 @@ type tokExampInt struct {
 
 var intToks = []tokExampInt{
 	{"12", []lex.TokType{lex.TokIntVal}, 12},
-	{"0xff", []lex.TokType{lex.TokIntVal}, 0xff},
-	{"0xff a", []lex.TokType{lex.TokIntVal}, 0xff},
+	{"0xff", []lex.TokType{lex.TokUintVal}, 0xff},
+	{"0xff a", []lex.TokType{lex.TokUintVal}, 0xff},
+	{"0xffffffffffffffff", []lex.TokType{lex.TokUintVal}, -1},
+	{"0x1ffffffffffffffff", []lex.TokType{lex.TokBad}, 0},
+	{"0o17", []lex.TokType{lex.TokUintVal}, 15},
 	{"01a", []lex.TokType{lex.TokIntVal}, 1},
 	{"0ba", []lex.TokType{lex.TokBad}, 1},
 	{"0oa", []lex.TokType{lex.TokBad}, 1},
 	{"000", []lex.TokType{lex.TokIntVal}, 0},
 	{"017", []lex.TokType{lex.TokIntVal}, 17},
-	{"0b11", []lex.TokType{lex.TokIntVal}, 3},
+	{"0b11", []lex.TokType{lex.TokUintVal}, 3},
 	{"0b", []lex.TokType{lex.TokBad}, 3},
 }
 
@@ -197,6 +213,22 @@ func TestIntToks(t *testing.T) {
 	exampleTestInt(t, intToks)
 }
 
+var durToks = []tokExampInt{
+	{"500ms", []lex.TokType{lex.TokDurVal}, 500 * 1000 * 1000},
+	{"30s", []lex.TokType{lex.TokDurVal}, 30 * 1000 * 1000 * 1000},
+	{"5m", []lex.TokType{lex.TokDurVal}, 5 * 60 * 1000 * 1000 * 1000},
+	{"1h30m", []lex.TokType{lex.TokDurVal}, 90 * 60 * 1000 * 1000 * 1000},
+	{"1.5s", []lex.TokType{lex.TokDurVal}, 1500 * 1000 * 1000},
+	{"1s+1s", []lex.TokType{lex.TokDurVal, lex.TokAdd, lex.TokDurVal}, 1000 * 1000 * 1000},
+	{"3 s", []lex.TokType{lex.TokIntVal, lex.TokId}, 3},
+	{"5mx", []lex.TokType{lex.TokBad}, 0},
+	{"0x5s", []lex.TokType{lex.TokUintVal, lex.TokId}, 5},
+}
+
+func TestDurToks(t *testing.T) {
+	exampleTestInt(t, durToks)
+}
+
 func exampleTestInt(t *testing.T, examples []tokExampInt) {
 	var l *lex.Lexer
 	defer recovCrashFail(t, l)
@@ -211,7 +243,7 @@ func exampleTestInt(t *testing.T, examples []tokExampInt) {
 				errs := fmt.Sprintf("%s %s -> %s [%d] %s", l, err, ex.input, i, tokT)
 				t.Fatal(errs)
 			}
-			if err == nil && tokT != lex.TokBad && tokT == lex.TokIntVal {
+			if err == nil && tokT != lex.TokBad && (tokT == lex.TokIntVal || tokT == lex.TokDurVal) {
 				if tok.TokIntVal != ex.i {
 					t.Fatalf("%s should be %d and is %d", ex.input, ex.i, tok.TokIntVal)
 				}
@@ -251,6 +283,31 @@ func TestLexWhileNot(t *testing.T) {
 
 }
 
+func TestKeepComments(t *testing.T) {
+	l, err := lex.NewFakeLexer("a # one \n  #two\nb", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.KeepComments = true
+	want := []struct {
+		lexema    string
+		line, col int
+	}{{"a", 1, 1}, {"# one", 1, 3}, {"#two", 2, 3}, {"b", 3, 1}}
+	for _, w := range want {
+		tok, err := l.Lex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Lexema != w.lexema || tok.Pos.Line != w.line || tok.Pos.Col != w.col {
+			t.Fatalf("token %s at %d:%d, should be %q at %d:%d",
+				tok, tok.Pos.Line, tok.Pos.Col, w.lexema, w.line, w.col)
+		}
+	}
+	if tok, _ := l.Lex(); tok.Type != lex.TokEof {
+		t.Fatalf("should be eof, is %s", tok)
+	}
+}
+
 //go:embed examples/example.rul
 var fuzzfile string
 
@@ -304,3 +361,30 @@ func FuzzRipsLex(f *testing.F) {
 
 //TODO PEEK test
 //TODO, lexId, Couples
+
+func TestOffsets(t *testing.T) {
+	l, err := lex.NewFakeLexer("ñu x\n  y", ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		lexema         string
+		col, offset    int
+		endcol, endoff int
+	}{{"ñu", 1, 0, 2, 2}, {"x", 4, 4, 4, 4}, {"y", 3, 8, 3, 8}}
+	for _, w := range want {
+		tok, err := l.Lex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := tok.End
+		if tok.Lexema != w.lexema || tok.Pos.Col != w.col || tok.Pos.Offset != w.offset {
+			t.Fatalf("token %s at col %d offset %d, should be %q at col %d offset %d",
+				tok, tok.Pos.Col, tok.Pos.Offset, w.lexema, w.col, w.offset)
+		}
+		if end.Col != w.endcol || end.Offset != w.endoff {
+			t.Fatalf("token %s ends at col %d offset %d, should be col %d offset %d",
+				tok, end.Col, end.Offset, w.endcol, w.endoff)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package lsp
+
+import (
+	"fmt"
+	"os"
+	"rips/rips/diag"
+	"rips/rips/lex"
+	"rips/rips/parser"
+	"rips/rips/tree"
+	"rips/rips/types"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// a rule file open in the editor
+type document struct {
+	uri   string
+	fname string
+	text  string
+	lines []string
+	prog  *tree.Prog //of the last check, nil if it could not be parsed
+}
+
+func newDocument(uri string, text string) *document {
+	return &document{uri: uri, fname: uriToPath(uri), text: text, lines: strings.Split(text, "\n")}
+}
+
+// The diagnostics of the stages of xrips.BuildAst, each one only
+// if the previous one has no errors, and then the warnings.
+func (d *document) check() (diags []Diagnostic) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.prog = nil
+			pos := lex.Position{File: d.fname, Line: 1}
+			msg := fmt.Sprintf("rips internal error: %s", r)
+			diags = append(diags, d.diagnostic(&diag.Diagnostic{Severity: diag.Error, Pos: pos, Msg: msg}))
+		}
+	}()
+	dl := &diag.List{Stage: diag.Parse}
+	prog := parser.ParseBuffer(d.fname, []byte(d.text), dl)
+	d.prog = prog
+	if prog != nil {
+		prog.Sources = diag.Sources{d.fname: []byte(d.text)}
+	}
+	if dl.NErrors() == 0 && prog != nil {
+		dl.Stage = diag.Types
+		nerr := prog.TypeCheck(dl)
+		if nerr == 0 {
+			dl.Stage = diag.Fold
+			nerr = prog.Fold(dl)
+		}
+		if nerr == 0 {
+			dl.Stage = diag.Levels
+			nerr = prog.StatesCheck(dl)
+		}
+		if nerr == 0 {
+			dl.Diags = append(dl.Diags, prog.Lint()...)
+		}
+	}
+	for _, dg := range dl.Diags {
+		diags = append(diags, d.diagnostic(dg))
+	}
+	return diags
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// the rune of the line at the character (in UTF-16 units)
+func runeCol(line string, char int) (col int) {
+	for _, r := range line {
+		if char <= 0 {
+			break
+		}
+		char -= utf16.RuneLen(r)
+		col++
+	}
+	return col
+}
+
+// the range of the text in the line, the whole line if col is 0
+func lineRange(lines []string, nline int, col int, text string) (r Range) {
+	if nline < 1 {
+		nline = 1
+	}
+	if nline > len(lines) {
+		nline = len(lines)
+	}
+	line := lines[nline-1]
+	r.Start.Line, r.End.Line = nline-1, nline-1
+	if col == 0 {
+		indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		r.Start.Character = utf16Len(line[:indent])
+		r.End.Character = utf16Len(line)
+		return r
+	}
+	runes := []rune(line)
+	start := col - 1
+	if start > len(runes) {
+		start = len(runes)
+	}
+	r.Start.Character = utf16Len(string(runes[:start]))
+	r.End.Character = r.Start.Character + utf16Len(text)
+	return r
+}
+
+// the position after the first n runes of the line
+func charPos(lines []string, nline int, n int) (pos Position) {
+	if nline < 1 || nline > len(lines) {
+		return Position{Line: nline - 1}
+	}
+	runes := []rune(lines[nline-1])
+	if n > len(runes) {
+		n = len(runes)
+	}
+	if n < 0 {
+		n = 0
+	}
+	return Position{Line: nline - 1, Character: utf16Len(string(runes[:n]))}
+}
+
+// the range from the rune at pos to the one at end, the whole
+// line of pos if end is not known
+func posRange(lines []string, pos lex.Position, end lex.Position) Range {
+	if pos.Col == 0 || end.Line < pos.Line || end.Line == pos.Line && end.Col < pos.Col {
+		return lineRange(lines, pos.Line, 0, "")
+	}
+	return Range{Start: charPos(lines, pos.Line, pos.Col-1), End: charPos(lines, end.Line, end.Col)}
+}
+
+// the errors of an included file are in the first line with their place
+func (d *document) diagnostic(dg *diag.Diagnostic) Diagnostic {
+	severity := severityError
+	if dg.Severity == diag.Warning {
+		severity = severityWarning
+	}
+	r := posRange(d.lines, dg.Pos, dg.End)
+	msg := dg.Msg
+	if dg.Pos.File != d.fname {
+		msg = fmt.Sprintf("%s: %s", dg.Pos, msg)
+		r = lineRange(d.lines, 1, 0, "")
+	}
+	return Diagnostic{
+		Range:    r,
+		Severity: severity,
+		Code:     dg.Code,
+		Source:   "rips",
+		Message:  msg,
+	}
+}
+
+// the tokens of the text, until the first lexing error
+func tokens(text string) (toks []lex.Token) {
+	l, err := lex.NewFakeLexer(text, nil)
+	if err != nil {
+		return nil
+	}
+	for {
+		t, err := l.Lex()
+		if err != nil || t.Type == lex.TokEof {
+			return toks
+		}
+		toks = append(toks, t)
+	}
+}
+
+// the identifier at the position (or just before it)
+func (d *document) identAt(pos Position) (tok lex.Token, ok bool) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return tok, false
+	}
+	col := runeCol(d.lines[pos.Line], pos.Character) + 1
+	for _, t := range tokens(d.text) {
+		if t.Pos.Line != pos.Line+1 || t.Type != lex.TokId {
+			continue
+		}
+		if t.Pos.Col <= col && col <= t.Pos.Col+len([]rune(t.Lexema)) {
+			return t, true
+		}
+	}
+	return tok, false
+}
+
+// the builtins and the predefined variables, see Parser.Parse
+var builtinEnv = func() (envs tree.StkEnv) {
+	envs.PushEnv()
+	envs.Builtins(tree.Builtins)
+	envs.PredefVars()
+	return envs
+}()
+
+func (d *document) lookup(name string) (s *tree.Sym) {
+	if d.prog != nil && d.prog.Env != nil {
+		if s = d.prog.Env[name]; s != nil {
+			return s
+		}
+	}
+	return builtinEnv.GetSym(name)
+}
+
+func (d *document) definition(pos Position) (loc *Location) {
+	t, ok := d.identAt(pos)
+	if !ok {
+		return nil
+	}
+	s := d.lookup(t.Lexema)
+	if s == nil || s.IsBuiltin || s.Pos.Line == 0 {
+		return nil
+	}
+	lines := d.lines
+	if s.Pos.File != d.fname {
+		src, err := os.ReadFile(s.Pos.File)
+		if err != nil {
+			return nil
+		}
+		lines = strings.Split(string(src), "\n")
+	}
+	loc = &Location{URI: pathToURI(s.Pos.File), Range: posRange(lines, s.Pos, s.End)}
+	if s.Pos.File == d.fname {
+		loc.URI = d.uri
+	}
+	return loc
+}
+
+func typeName(tp types.Type) string {
+	if tp.TVal == types.TypeVals[types.TVSet] {
+		return "set of string"
+	}
+	return tp.TVal.String()
+}
+
+// the kind of rules where the builtin can be called, eexpr for any
+func builtinSect(b *tree.Builtin) (te *types.TypeExpr) {
+	te = b.RetType.TExpr
+	for _, at := range b.ArgTypes {
+		te = te.Join(at.TExpr)
+	}
+	return te
+}
+
+// the names of the rules sections of the kind, nil for any
+func sectNames(te *types.TypeExpr) (names []string) {
+	if te == types.TypeExprs[types.TEExpr] {
+		return nil
+	}
+	for name, ste := range types.TypeExprFromNames {
+		if te.IsTypeExprCompat(ste) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func signature(b *tree.Builtin) string {
+	var args []string
+	for _, at := range b.ArgTypes {
+		args = append(args, typeName(at))
+	}
+	if b.IsVariadic && len(args) > 0 {
+		args[len(args)-1] += "..."
+	}
+	return fmt.Sprintf("%s(%s) %s", b.Name, strings.Join(args, ", "), typeName(b.RetType))
+}
+
+func builtinDoc(b *tree.Builtin) string {
+	var doc []string
+	if b.IsAction {
+		doc = append(doc, "action")
+	}
+	if names := sectNames(builtinSect(b)); names != nil {
+		doc = append(doc, "only in rules "+strings.Join(names, ", "))
+	}
+	return strings.Join(doc, ", ")
+}
+
+func findBuiltin(name string) *tree.Builtin {
+	for _, b := range tree.Builtins {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// what the user declared, or the builtin
+func describe(s *tree.Sym) string {
+	switch s.SType {
+	case tree.SLevel:
+		str := "level " + s.Name
+		if s.IsSoft {
+			str += " soft"
+		}
+		if s.After != 0 {
+			str += fmt.Sprintf(" after %s", s.After)
+		}
+		return str
+	case tree.SVar:
+		if s.IsBuiltin {
+			return fmt.Sprintf("predefined %s %s", s.Name, typeName(s.DataType))
+		}
+		return fmt.Sprintf("var %s %s", s.Name, typeName(s.DataType))
+	case tree.SConst:
+		return fmt.Sprintf("const %s %s", s.Name, typeName(s.DataType))
+	case tree.SRule:
+		return "rule " + s.Name
+	case tree.SSect:
+		return "rules " + s.Name
+	case tree.SFunc:
+		if b := findBuiltin(s.Name); s.Body == nil && b != nil {
+			if doc := builtinDoc(b); doc != "" {
+				return signature(b) + "\n\n" + doc
+			}
+			return signature(b)
+		}
+		var params []string
+		for _, p := range s.Params {
+			params = append(params, p.Name+" "+typeName(p.DataType))
+		}
+		return fmt.Sprintf("func %s(%s) %s", s.Name, strings.Join(params, ", "), typeName(s.DataType))
+	}
+	return s.Name
+}
+
+func (d *document) hover(pos Position) *Hover {
+	t, ok := d.identAt(pos)
+	if !ok {
+		return nil
+	}
+	s := d.lookup(t.Lexema)
+	if s == nil {
+		return nil
+	}
+	r := lineRange(d.lines, t.Pos.Line, t.Pos.Col, t.Lexema)
+	return &Hover{Contents: MarkupContent{Kind: "plaintext", Value: describe(s)}, Range: &r}
+}
+
+// The kind of expressions of the rules section at the position,
+// as in Prog.TypeCheck, nil outside of the rules.
+func (d *document) sectAt(pos Position) (te *types.TypeExpr) {
+	toks := tokens(d.text)
+	for i, t := range toks {
+		if t.Pos.Line > pos.Line+1 {
+			break
+		}
+		switch {
+		case t.Type == lex.TokRules && i+1 < len(toks):
+			name := toks[i+1].Lexema
+			te = types.TypeExprFromNames[name]
+			if name == "Message" {
+				te = types.MsgGraphType.TExpr
+			}
+			if name == "Timer" {
+				te = types.TypeExprs[types.TEExternal]
+			}
+		case t.Type == lex.TokLevels, t.Type == lex.TokTransitions, t.Type == lex.TokVars,
+			t.Type == lex.TokConsts, t.Type == lex.TokFuncs:
+			te = nil
+		}
+	}
+	return te
+}
+
+var keywordItems = []string{"levels", "transitions", "consts", "vars", "funcs", "rules", "rule", "include", "soft", "in"}
+
+// The builtins which can be called in the rules section at the
+// position (the expressions for any one outside), the declared
+// names and the keywords.
+func (d *document) completion(pos Position) (items []CompletionItem) {
+	te := d.sectAt(pos)
+	for _, b := range tree.Builtins {
+		bte := builtinSect(b)
+		if te == nil && bte != types.TypeExprs[types.TEExpr] || te != nil && !bte.IsTypeExprCompat(te) {
+			continue
+		}
+		items = append(items, CompletionItem{Label: b.Name, Kind: kindFunction,
+			Detail: signature(b), Documentation: builtinDoc(b)})
+	}
+	var syms []*tree.Sym
+	for _, s := range builtinEnv[0] {
+		if s.SType == tree.SVar {
+			syms = append(syms, s)
+		}
+	}
+	if d.prog != nil {
+		for _, s := range d.prog.Env {
+			syms = append(syms, s)
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Name < syms[j].Name })
+	for _, s := range syms {
+		kind := kindVariable
+		switch s.SType {
+		case tree.SLevel:
+			kind = kindEnum
+		case tree.SConst:
+			kind = kindConstant
+		case tree.SFunc:
+			kind = kindFunction
+		case tree.SSect:
+			continue
+		}
+		items = append(items, CompletionItem{Label: s.Name, Kind: kind, Detail: describe(s)})
+	}
+	for _, k := range keywordItems {
+		items = append(items, CompletionItem{Label: k, Kind: kindKeyword})
+	}
+	return items
+}
@@ -0,0 +1,139 @@
+// Package lsp is a language server for the rule files (rips lsp).
+//
+// It speaks the language server protocol on a stream, usually the
+// standard input and output of rips started by the editor. The
+// documents are checked on every change with the same stages as
+// the compiler and their errors and lint warnings published as
+// diagnostics. It completes builtins (the ones of the kind of
+// rules section), declared names and keywords, goes to the
+// definition of levels, consts, vars and funcs and shows the type
+// of a symbol on hover.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type Server struct {
+	out  io.Writer
+	docs map[string]*document //by uri
+}
+
+func NewServer(out io.Writer) *Server {
+	return &Server{out: out, docs: make(map[string]*document)}
+}
+
+// Serve answers the requests in until exit or the end of in
+func (srv *Server) Serve(in io.Reader) error {
+	r := bufio.NewReader(in)
+	for {
+		content, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("bad message: %s", err)
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := srv.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+func (srv *Server) reply(req *request, result interface{}, rerr *respError) error {
+	if req.ID == nil {
+		return nil //a notification
+	}
+	resp := response{JSONRPC: "2.0", ID: req.ID, Error: rerr}
+	if rerr == nil {
+		js, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = js
+	}
+	return writeMessage(srv.out, resp)
+}
+
+func (srv *Server) publish(doc *document, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{} //no diagnostics clears them
+	}
+	params := publishParams{URI: doc.uri, Diagnostics: diags}
+	return writeMessage(srv.out, notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+func (srv *Server) handle(req *request) (err error) {
+	switch req.Method {
+	case "initialize":
+		caps := map[string]interface{}{
+			"textDocumentSync":   syncFull,
+			"completionProvider": map[string]interface{}{},
+			"definitionProvider": true,
+			"hoverProvider":      true,
+		}
+		result := map[string]interface{}{
+			"capabilities": caps,
+			"serverInfo":   map[string]string{"name": "rips"},
+		}
+		return srv.reply(req, result, nil)
+	case "shutdown":
+		return srv.reply(req, nil, nil)
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(req.Params, &params); err != nil {
+			return nil //bad notifications are ignored
+		}
+		doc := newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		srv.docs[doc.uri] = doc
+		return srv.publish(doc, doc.check())
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		doc := newDocument(params.TextDocument.URI, text)
+		srv.docs[doc.uri] = doc
+		return srv.publish(doc, doc.check())
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		doc, ok := srv.docs[params.TextDocument.URI]
+		if !ok {
+			return nil
+		}
+		delete(srv.docs, doc.uri)
+		return srv.publish(doc, nil)
+	case "textDocument/completion", "textDocument/definition", "textDocument/hover":
+		var params positionParams
+		if err = json.Unmarshal(req.Params, &params); err != nil {
+			return srv.reply(req, nil, &respError{errInvalidParams, err.Error()})
+		}
+		doc, ok := srv.docs[params.TextDocument.URI]
+		if !ok {
+			return srv.reply(req, nil, nil)
+		}
+		switch req.Method {
+		case "textDocument/completion":
+			return srv.reply(req, doc.completion(params.Position), nil)
+		case "textDocument/definition":
+			return srv.reply(req, doc.definition(params.Position), nil)
+		default:
+			return srv.reply(req, doc.hover(params.Position), nil)
+		}
+	}
+	return srv.reply(req, nil, &respError{errMethodNotFound, "method not found: " + req.Method})
+}
@@ -0,0 +1,169 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"rips/rips/lsp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///tmp/rules.rul"
+
+const rules = `levels:
+	ALEV;
+	ALERT soft after 1m;
+
+consts:
+	MaxMsgs int = 3;
+
+vars:
+	nmsg int = 0;
+
+rules Msg:
+	nmsg > MaxMsgs ?
+		trigger(ALERT);
+	true ?
+		set(nmsg, nmsg + 1);
+
+rules External:
+	true ?
+		set(nmsg, 0);
+`
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+func send(w io.Writer, id int, method string, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		msg["id"] = id
+	}
+	js, _ := json.Marshal(msg)
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(js), js)
+}
+
+func messages(t *testing.T, out []byte) (msgs []message) {
+	r := bufio.NewReader(bytes.NewReader(out))
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return msgs
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("bad header %q", line)
+		}
+		r.ReadString('\n')
+		content := make([]byte, n)
+		io.ReadFull(r, content)
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func position(line int, char int) interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+	}
+}
+
+func TestServer(t *testing.T) {
+	var in, out bytes.Buffer
+	send(&in, 1, "initialize", map[string]interface{}{})
+	send(&in, 0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "text": rules},
+	})
+	bad := strings.Replace(rules, "set(nmsg, 0)", "set(nmsg, msgint(\"x\"))", 1)
+	send(&in, 0, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]string{"uri": uri},
+		"contentChanges": []map[string]string{{"text": bad}},
+	})
+	send(&in, 2, "textDocument/completion", position(12, 2))
+	send(&in, 3, "textDocument/completion", position(18, 2))
+	send(&in, 4, "textDocument/definition", position(11, 10))
+	send(&in, 5, "textDocument/hover", position(12, 12))
+	send(&in, 6, "textDocument/hover", position(11, 2))
+	send(&in, 7, "nosuchmethod", nil)
+	send(&in, 8, "shutdown", nil)
+	send(&in, 0, "exit", nil)
+	if err := lsp.NewServer(&out).Serve(&in); err != nil {
+		t.Fatal(err)
+	}
+	msgs := messages(t, out.Bytes())
+	if len(msgs) != 10 {
+		t.Fatalf("%d messages, should be 10", len(msgs))
+	}
+	if !strings.Contains(string(msgs[0].Result), `"hoverProvider":true`) {
+		t.Fatalf("bad capabilities %s", msgs[0].Result)
+	}
+	var diags struct {
+		Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+	}
+	json.Unmarshal(msgs[1].Params, &diags)
+	if msgs[1].Method != "textDocument/publishDiagnostics" || len(diags.Diagnostics) != 0 {
+		t.Fatalf("the rules should have no diagnostics: %s", msgs[1].Params)
+	}
+	json.Unmarshal(msgs[2].Params, &diags)
+	if len(diags.Diagnostics) != 1 || diags.Diagnostics[0].Range.Start.Line != 18 {
+		t.Fatalf("msgint should be an error in the line 19: %s", msgs[2].Params)
+	}
+	want := lsp.Range{Start: lsp.Position{Line: 18, Character: 12}, End: lsp.Position{Line: 18, Character: 23}}
+	if d := diags.Diagnostics[0]; d.Range != want || d.Code == "" {
+		t.Fatalf("msgint(\"x\") should be the range of the error: %s", msgs[2].Params)
+	}
+
+	var items []lsp.CompletionItem
+	json.Unmarshal(msgs[3].Result, &items)
+	labels := map[string]string{}
+	for _, it := range items {
+		labels[it.Label] = it.Detail
+	}
+	if labels["msgint"] != "msgint(string) int" || labels["MaxMsgs"] != "const MaxMsgs int" {
+		t.Fatalf("rules Msg should complete msgint and MaxMsgs: %s", msgs[3].Result)
+	}
+	items = nil
+	json.Unmarshal(msgs[4].Result, &items)
+	for _, it := range items {
+		if it.Label == "msgint" {
+			t.Fatal("rules External should not complete msgint")
+		}
+	}
+
+	var loc lsp.Location
+	json.Unmarshal(msgs[5].Result, &loc)
+	want = lsp.Range{Start: lsp.Position{Line: 5, Character: 1}, End: lsp.Position{Line: 5, Character: 8}}
+	if loc.URI != uri || loc.Range != want {
+		t.Fatalf("MaxMsgs should be defined in the line 6: %s", msgs[5].Result)
+	}
+	var hover lsp.Hover
+	json.Unmarshal(msgs[6].Result, &hover)
+	if hover.Contents.Value != "level ALERT soft after 1m0s" {
+		t.Fatalf("bad hover for ALERT: %s", msgs[6].Result)
+	}
+	json.Unmarshal(msgs[7].Result, &hover)
+	if hover.Contents.Value != "var nmsg int" {
+		t.Fatalf("bad hover for nmsg: %s", msgs[7].Result)
+	}
+	if msgs[8].Error == nil || msgs[8].Error.Code != -32601 {
+		t.Fatalf("unknown methods should fail: %+v", msgs[8])
+	}
+	if *msgs[9].ID != 8 || string(msgs[9].Result) != "null" {
+		t.Fatalf("bad shutdown: %+v", msgs[9])
+	}
+}
@@ -1,14 +1,18 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"rips/rips/diag"
+	"rips/rips/extern"
 	"rips/rips/lex"
 	"rips/rips/tree"
 	"rips/rips/types"
 	"strings"
+	"time"
 )
 
 var DebugDesc = false
@@ -22,7 +26,7 @@ type Parser struct {
 }
 
 func NewParser(l lex.LexPeeker) *Parser {
-	return &Parser{l, 0, nil, 0, nil}
+	return &Parser{l: l}
 }
 
 func (p *Parser) match(tt lex.TokType) (tok lex.Token, err error, ismatch bool) {
@@ -61,7 +65,7 @@ func (p *Parser) matchErr(tt lex.TokType) (tok lex.Token, ismatch bool) {
 //
 //	ε
 //
-// ATTROPT :=	'soft'
+// ATTROPT :=	'soft' AFTEROPT
 //
 //	ε
 func (p *Parser) LevelDecls(prog *tree.Prog) (err error) {
@@ -90,22 +94,115 @@ func (p *Parser) LevelDecls(prog *tree.Prog) (err error) {
 	level.SLevel = prog.AddLevel(level)
 	level.DataType = types.IntType     //in case they are compared
 	level.IntVal = int64(level.SLevel) //in case they are compared
-	level.Pos = p.l.Pos()
+	level.Pos, level.End = tokid.Pos, tokid.End
 	t, err := p.l.Peek()
 	if t.Type == lex.TokSoft {
 		level.IsSoft = true
 		p.l.Lex() //already peeked
+		isafter := false
+		if level.After, isafter = p.levelAfter(level); !isafter {
+			return p.LevelDecls(prog) //recovered in levelAfter
+		}
+	} else if t.Type == lex.TokId && t.Lexema == "after" {
+		p.Errorf("level %s: only soft levels de-escalate after a time", level.Name)
+		p.NextSync()
+		return p.LevelDecls(prog)
 	}
 	//instantiate new level
 	p.matchErr(lex.TokSemi) //continue in case it recovered
 	return p.LevelDecls(prog)
 }
 
+// AFTEROPT :=	'after' DURATION
+//
+//	ε
+//
+// after is not a keyword, it is only special after soft
+func (p *Parser) levelAfter(level *tree.Sym) (after time.Duration, ok bool) {
+	t, _ := p.l.Peek()
+	if t.Type != lex.TokId || t.Lexema != "after" {
+		return 0, true
+	}
+	p.l.Lex() //already peeked
+	tokafter, isdur := p.matchErr(lex.TokDurVal)
+	if !isdur {
+		return 0, false
+	}
+	after = time.Duration(tokafter.TokIntVal)
+	if after <= 0 {
+		p.Errorf("level %s: the quiet period should be positive", level.Name)
+		return 0, true
+	}
+	if level.SLevel == 0 {
+		p.Errorf("level %s is the lowest, it cannot de-escalate", level.Name)
+		return 0, true
+	}
+	return after, true
+}
+
+// TRANSDECLS :=	ID '->' ID ';' TRANSDECLS
+//
+//	ε
+func (p *Parser) TransitionDecls(prog *tree.Prog) (err error) {
+	var tokfrom lex.Token
+	p.pushTrace("TransitionDecls")
+	defer p.popTrace(&err)
+	if prog.Transitions == nil {
+		//declared, even if empty
+		prog.Transitions = make(extern.Transitions)
+	}
+	istokid := false
+	if tokfrom, err, istokid = p.match(lex.TokId); !istokid && err == nil {
+		//ε
+		return err
+	}
+	if err != nil {
+		p.Errorf("expected level id, error: %s", err)
+		p.NextSync()
+		return p.TransitionDecls(prog)
+	}
+	if _, isarrow := p.matchErr(lex.TokArrow); !isarrow {
+		return p.TransitionDecls(prog) //recovered in matchErr
+	}
+	tokto, isid := p.matchErr(lex.TokId)
+	if !isid {
+		return p.TransitionDecls(prog) //recovered in matchErr
+	}
+	from := p.levelSym(tokfrom)
+	to := p.levelSym(tokto)
+	if from != nil && to != nil {
+		tr := extern.Transition{From: from.SLevel, To: to.SLevel}
+		switch {
+		case from == to:
+			p.Errorf("transition %s -> %s to the same level", from.Name, to.Name)
+		case prog.Transitions[tr]:
+			p.Errorf("transition %s -> %s already declared", from.Name, to.Name)
+		default:
+			prog.Transitions[tr] = true
+		}
+	}
+	p.matchErr(lex.TokSemi) //continue in case it recovered
+	return p.TransitionDecls(prog)
+}
+
+// the level named by tok, declared in the levels section
+func (p *Parser) levelSym(tok lex.Token) (level *tree.Sym) {
+	level = p.Envs.GetSym(tok.Lexema)
+	if level == nil || level.SType != tree.SLevel {
+		p.Errorf("%s is not a declared level", tok.Lexema)
+		return nil
+	}
+	return level
+}
+
 var tokEndSect = []lex.TokType{
 	lex.TokLevels,
+	lex.TokTransitions,
 	lex.TokVars,
 	lex.TokConsts,
+	lex.TokFuncs,
 	lex.TokRules,
+	lex.TokInclude,
 	lex.TokEof,
 }
 
@@ -113,13 +210,81 @@ func (p *Parser) IsEndSection() (isend bool, err error) {
 	tok, err := p.l.Peek() //for recovery, try to see if it is end of section
 	switch tok.Type {
 	//same as tokEndSect, just for efficiency a switch
-	case lex.TokLevels, lex.TokVars, lex.TokConsts, lex.TokRules, lex.TokEof:
+	case lex.TokLevels, lex.TokTransitions, lex.TokVars, lex.TokConsts, lex.TokFuncs, lex.TokRules, lex.TokInclude, lex.TokEof:
 		return true, err
 	}
 	return false, err
 }
 
-// CONSTDECLS :=	ID ID '=' EXPR ';' CONSTDECLS
+// TYPE :=	ID
+//
+//	'set' 'of' 'string'
+//	'map' '[' 'string' ']' ID
+//	'map' '[' 'string' ']' ID '(' INTVAL ')'
+//
+// maxkeys is the bound of the keys of a map, see extern.Map
+func (p *Parser) declType(typename lex.Token) (tv *types.TypeVal, maxkeys int) {
+	if typename.Lexema == "map" {
+		return p.mapType()
+	}
+	tv, ok := types.TypeValsFromNames[typename.Lexema]
+	if !ok {
+		p.Errorf("%s is not a type", typename.Lexema)
+		return types.TypeVals[types.TVUndef], 0 //for later...
+	}
+	if tv != types.TypeVals[types.TVSet] {
+		return tv, 0
+	}
+	if tok, _, isid := p.match(lex.TokId); !isid || tok.Lexema != "of" {
+		p.Errorf("expected 'of' after set")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	if tok, _, isid := p.match(lex.TokId); !isid || tok.Lexema != "string" {
+		p.Errorf("only sets of string are supported")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	return tv, 0
+}
+
+func (p *Parser) mapType() (tv *types.TypeVal, maxkeys int) {
+	if _, _, isbrack := p.match(lex.TokLBrack); !isbrack {
+		p.Errorf("expected '[' after map")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	if tok, _, isid := p.match(lex.TokId); !isid || tok.Lexema != "string" {
+		p.Errorf("only maps from string are supported")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	if _, _, isbrack := p.match(lex.TokRBrack); !isbrack {
+		p.Errorf("expected ']' after map[string")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	tok, _, isid := p.match(lex.TokId)
+	if !isid {
+		p.Errorf("expected the type of the elements of the map")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	tv = types.MapOf(types.TypeValsFromNames[tok.Lexema])
+	if tv == nil {
+		p.Errorf("%s is not a valid type for the elements of a map", tok.Lexema)
+		return types.TypeVals[types.TVUndef], 0
+	}
+	if _, _, islpar := p.match(lex.TokLPar); !islpar {
+		return tv, extern.MaxMapKeys
+	}
+	tok, _, isint := p.match(lex.TokIntVal)
+	if _, _, isrpar := p.match(lex.TokRPar); !isrpar {
+		p.Errorf("expected ')' after the bound of the map")
+		return types.TypeVals[types.TVUndef], 0
+	}
+	if !isint || tok.TokIntVal <= 0 {
+		p.Errorf("the bound of the keys of a map should be a positive int, not %s", tok.Lexema)
+		return types.TypeVals[types.TVUndef], 0
+	}
+	return tv, int(tok.TokIntVal)
+}
+
+// CONSTDECLS :=	ID TYPE '=' EXPR ';' CONSTDECLS
 //
 //	ε
 func (p *Parser) ConstDecls(prog *tree.Prog) (err error) {
@@ -151,18 +316,14 @@ func (p *Parser) ConstDecls(prog *tree.Prog) (err error) {
 		return p.ConstDecls(prog)
 	}
 	typename = tokid
-	consttype, ok := types.TypeValsFromNames[typename.Lexema]
-	if !ok {
-		p.Errorf("%s is not a type", typename.Lexema)
-		consttype = types.TypeVals[types.TVUndef] //for later...
-	}
+	consttype, _ := p.declType(typename)
 	sconst, err := p.Envs.NewConst(constname.Lexema, consttype)
 	if err != nil {
 		p.Errorf("declaring %s: %s", typename.Lexema, err)
 		sconst = tree.NewAnonSym(tree.SConst) //inject fake var
 		sconst.DataType.TVal = consttype
 	}
-	sconst.Pos = p.l.Pos()
+	sconst.Pos, sconst.End = constname.Pos, constname.End
 	if _, istokas := p.matchErr(lex.TokAsig); !istokas {
 		return p.ConstDecls(prog) //in case it recovered
 	}
@@ -187,7 +348,7 @@ func (p *Parser) ConstDecls(prog *tree.Prog) (err error) {
 	return p.ConstDecls(prog)
 }
 
-// VARDECLS :=	ID ID '=' EXPR ';' VARDECLS
+// VARDECLS :=	ID TYPE '=' EXPR ';' VARDECLS
 //
 //	ε
 func (p *Parser) VarDecls(prog *tree.Prog) (err error) {
@@ -219,18 +380,15 @@ func (p *Parser) VarDecls(prog *tree.Prog) (err error) {
 		return p.VarDecls(prog)
 	}
 	typename = tokid
-	vartype, ok := types.TypeValsFromNames[typename.Lexema]
-	if !ok {
-		p.Errorf("%s is not a type", typename.Lexema)
-		vartype = types.TypeVals[types.TVUndef] //for later...
-	}
+	vartype, maxkeys := p.declType(typename)
 	svar, err := p.Envs.NewVar(varname.Lexema, vartype)
 	if err != nil {
 		p.Errorf("declaring %s: %s", typename.Lexema, err)
 		svar = tree.NewAnonSym(tree.SVar) //inject fake var
 		svar.DataType.TVal = vartype
 	}
-	svar.Pos = p.l.Pos()
+	svar.Pos, svar.End = varname.Pos, varname.End
+	svar.MaxKeys = maxkeys
 	if _, istokas := p.matchErr(lex.TokAsig); !istokas {
 		return p.VarDecls(prog) //in case it recovered
 	}
@@ -248,7 +406,6 @@ func (p *Parser) VarDecls(prog *tree.Prog) (err error) {
 	}
 	p.dPrintf("value: %s\n", expr)
 	svar.Val = expr //HACK, calculated from RVal in constant folding, this is for debugging
-	svar.Val.Pos = p.l.Pos()
 	svar.DataType = types.UnivType
 	svar.DataType.TVal = vartype
 	//Decl repeats info in svar.Val, important for debug, reformatting, etc
@@ -258,6 +415,105 @@ func (p *Parser) VarDecls(prog *tree.Prog) (err error) {
 	return p.VarDecls(prog)
 }
 
+// PARAMS :=	ID TYPE ',' PARAMS
+//
+//	ID TYPE
+//	ε
+func (p *Parser) Params(f *tree.Sym) (err error) {
+	p.pushTrace("Params")
+	defer p.popTrace(&err)
+	paramname, err, isid := p.match(lex.TokId)
+	if err != nil || !isid {
+		//ε
+		return err
+	}
+	typename, isid := p.matchErr(lex.TokId)
+	if !isid {
+		return errors.New("bad parameter")
+	}
+	paramtype, _ := p.declType(typename)
+	param, err := p.Envs.NewVar(paramname.Lexema, paramtype)
+	if err != nil {
+		p.Errorf("declaring %s: %s", paramname.Lexema, err)
+		param = tree.NewAnonSym(tree.SVar) //inject fake param
+		param.DataType.TVal = paramtype
+	}
+	param.Pos, param.End = paramname.Pos, paramname.End
+	param.IsSet = true
+	f.AddParam(param)
+	if _, _, iscomma := p.match(lex.TokComma); !iscomma {
+		return nil
+	}
+	return p.Params(f)
+}
+
+// FUNCDECLS :=	ID '(' PARAMS ')' TYPE '=' EXPR ';' FUNCDECLS
+//
+//	ε
+func (p *Parser) FuncDecls(prog *tree.Prog) (err error) {
+	p.pushTrace("FuncDecls")
+	defer p.popTrace(&err)
+	tokid, err, istokid := p.match(lex.TokId)
+	if !istokid {
+		//ε
+		if isend, err := p.IsEndSection(); isend || err != nil {
+			//in case of recover, no endless recurring
+			return err
+		}
+		t, _ := p.NextSync()
+		if err != nil || t.Type == lex.TokEof {
+			return errors.New("unexpected eof or error")
+		}
+		return p.FuncDecls(prog)
+	}
+	f, err := p.Envs.NewUserFunc(tokid.Lexema)
+	if err != nil {
+		p.Errorf("declaring %s: %s", tokid.Lexema, err)
+		f = tree.NewAnonSym(tree.SFunc) //inject fake func
+	}
+	f.Pos, f.End = tokid.Pos, tokid.End
+	p.Envs.PushEnv() //for the params, be careful, pop cannot be deferred (it is recursive)
+	if _, islpar := p.matchErr(lex.TokLPar); !islpar {
+		p.Envs.PopEnv()
+		return p.FuncDecls(prog) //in case it recovered
+	}
+	if err = p.Params(f); err != nil {
+		p.Envs.PopEnv()
+		return p.FuncDecls(prog) //recovered in Params
+	}
+	if _, isrpar := p.matchErr(lex.TokRPar); !isrpar {
+		p.Envs.PopEnv()
+		return p.FuncDecls(prog)
+	}
+	typename, istokid := p.matchErr(lex.TokId)
+	if !istokid {
+		p.Envs.PopEnv()
+		return p.FuncDecls(prog)
+	}
+	f.DataType = types.UnivType
+	f.DataType.TVal, _ = p.declType(typename)
+	if _, istokas := p.matchErr(lex.TokAsig); !istokas {
+		p.Envs.PopEnv()
+		return p.FuncDecls(prog)
+	}
+	expr, err := p.Expr(-1)
+	p.Envs.PopEnv()
+	if expr == nil || err != nil {
+		err = fmt.Errorf("incorrect expression %s...: %s", (*tree.USym)(expr), err)
+		p.Errorf("%s", err)
+		//try to resynchronize
+		if t, errrecov := p.NextSync(); errrecov != nil || t.Type != lex.TokSemi {
+			return errrecov
+		}
+		return p.FuncDecls(prog)
+	}
+	p.dPrintf("body: %s\n", expr)
+	f.Body = expr
+	prog.AddFunc(f)
+	p.matchErr(lex.TokSemi) //on error continue (recovered)
+	return p.FuncDecls(prog)
+}
+
 func IsConnector(con lex.TokType) bool {
 	switch con {
 	case lex.TokThen, lex.TokNThen, lex.TokComma:
@@ -318,7 +574,26 @@ func (p *Parser) declareCurrRule(nrulesects int, nrules int) {
 	sconst.StrVal = rulename
 }
 
-// ACTIONDECLS :=	EXPR '?' RULER ';' ACTIONDECLS
+// LABEL :=	'rule' ID ':'
+//
+//	ε
+func (p *Parser) ruleLabel() (label *tree.Sym, ok bool) {
+	if _, _, isrule := p.match(lex.TokRule); !isrule {
+		return nil, true
+	}
+	tokid, isid := p.matchErr(lex.TokId)
+	if !isid {
+		return nil, false
+	}
+	label = tree.NewLabel(tokid.Lexema, tokid.Pos)
+	label.End = tokid.End
+	if _, iscolon := p.matchErr(lex.TokColon); !iscolon {
+		return nil, false
+	}
+	return label, true
+}
+
+// ACTIONDECLS :=	LABEL EXPR '?' RULER ';' ACTIONDECLS
 //
 //	ε
 func (p *Parser) ActionDecls(rs *tree.RuleSect, prog *tree.Prog) (err error) {
@@ -333,11 +608,23 @@ func (p *Parser) ActionDecls(rs *tree.RuleSect, prog *tree.Prog) (err error) {
 		_, err = p.NextSync()
 		return err
 	}
-	if !isExprTok(tok) {
+	if !isExprTok(tok) && tok.Type != lex.TokRule {
 		p.Envs.PopEnv()
 		//ε
 		return nil
 	}
+	label, ok := p.ruleLabel()
+	if !ok {
+		p.Envs.PopEnv()
+		return p.ActionDecls(rs, prog) //on error continue (recovered)
+	}
+	start, err := p.l.Peek() //of the condition
+	if err != nil {
+		p.Envs.PopEnv()
+		p.Error(err)
+		_, err = p.NextSync(lex.TokQuest)
+		return p.ActionDecls(rs, prog) //on error continue (recovered)
+	}
 	expr, err := p.Expr(-1)
 	if err != nil {
 		p.Envs.PopEnv()
@@ -346,6 +633,8 @@ func (p *Parser) ActionDecls(rs *tree.RuleSect, prog *tree.Prog) (err error) {
 		return p.ActionDecls(rs, prog) //on error continue (recovered)
 	}
 	rule := tree.NewRule(p.l.Pos(), expr)
+	rule.Start, rule.End = start.Pos, p.l.End()
+	rule.Label = label
 	rs.AddRule(rule)
 	if _, istokq := p.matchErr(lex.TokQuest); !istokq {
 		p.Envs.PopEnv()
@@ -397,8 +686,47 @@ func (p *Parser) PredefVars() {
 	p.Envs.PredefVars()
 }
 
-func (p *Parser) RuleSect(prog *tree.Prog, tokid lex.Token) (err error) {
-	rs, err := p.Envs.NewRuleSect(tokid.Lexema, p.l.Pos())
+// SECTMODE :=	'first'
+//
+//	ε
+//
+// first is not a keyword, it is only special after the section name
+func (p *Parser) sectMode() (isfirst bool) {
+	tokmode, _, ismode := p.match(lex.TokId)
+	if !ismode {
+		return false
+	}
+	if tokmode.Lexema != "first" {
+		p.Errorf("unknown section mode %s", tokmode.Lexema)
+		return false
+	}
+	return true
+}
+
+// TIMER :=	'(' DURATION ')'
+//
+// after rules Timer
+func (p *Parser) timerPeriod() (period time.Duration, ok bool) {
+	if _, islpar := p.matchErr(lex.TokLPar); !islpar {
+		return 0, false
+	}
+	tokperiod, isdur := p.matchErr(lex.TokDurVal)
+	if !isdur {
+		return 0, false
+	}
+	if _, isrpar := p.matchErr(lex.TokRPar); !isrpar {
+		return 0, false
+	}
+	return time.Duration(tokperiod.TokIntVal), true
+}
+
+func (p *Parser) RuleSect(prog *tree.Prog, tokid lex.Token, isfirst bool, period time.Duration) (err error) {
+	var rs *tree.RuleSect
+	if tokid.Lexema == "Timer" {
+		rs, err = p.Envs.NewTimerSect(period, p.l.Pos())
+	} else {
+		rs, err = p.Envs.NewRuleSect(tokid.Lexema, p.l.Pos())
+	}
 	if err != nil {
 		p.Errorf("%s", err)
 	}
@@ -407,15 +735,39 @@ func (p *Parser) RuleSect(prog *tree.Prog, tokid lex.Token) (err error) {
 		_, errrecov := p.NextSection()
 		return errrecov
 	}
+	rs.IsFirst = isfirst
 	prog.AddRuleSect(rs)
 	err = p.ActionDecls(rs, prog)
 	return err
 }
 
+// INCLUDE :=	'include' STRING ';'
+//
+// the sections of the included file are lexed as if they were here
+func (p *Parser) Include() (err error) {
+	p.pushTrace("Include")
+	defer p.popTrace(&err)
+
+	tokpath, ispath := p.matchErr(lex.TokStrVal)
+	if !ispath {
+		return nil
+	}
+	if _, issemi := p.matchErr(lex.TokSemi); !issemi {
+		return nil
+	}
+	if err := p.l.Include(tokpath.TokStrVal); err != nil {
+		p.Errorf("include: %s", err)
+	}
+	return nil
+}
+
 // PROG :=	'levels' ':' LEVELDECLS'  PROG
 //
+//	'transitions' ':' TRANSDECLS PROG
 //	'vars' ':' VARDECLS PROG
+//	'funcs' ':' FUNCDECLS PROG
 //	RULES PROG
+//	INCLUDE PROG
 //	ε
 func (p *Parser) Prog(prog *tree.Prog) (err error) {
 	p.pushTrace("Prog")
@@ -426,9 +778,11 @@ func (p *Parser) Prog(prog *tree.Prog) (err error) {
 		return err
 	}
 	istokid := false
+	isfirst := false
+	period := time.Duration(0)
 	var tokid lex.Token
 	switch tok.Type {
-	case lex.TokLevels, lex.TokVars, lex.TokConsts:
+	case lex.TokLevels, lex.TokTransitions, lex.TokVars, lex.TokConsts, lex.TokFuncs:
 		p.l.Lex()
 	case lex.TokRules:
 		p.l.Lex()
@@ -436,6 +790,20 @@ func (p *Parser) Prog(prog *tree.Prog) (err error) {
 			p.NextSection() //the whole section is compromised
 			return p.Prog(prog)
 		}
+		if tokid.Lexema == "Timer" {
+			isperiod := false
+			if period, isperiod = p.timerPeriod(); !isperiod {
+				p.NextSection()
+				return p.Prog(prog)
+			}
+		}
+		isfirst = p.sectMode()
+	case lex.TokInclude:
+		p.l.Lex()
+		if err = p.Include(); err != nil {
+			return err
+		}
+		return p.Prog(prog)
 	case lex.TokEof:
 		return nil
 	default:
@@ -458,12 +826,16 @@ func (p *Parser) Prog(prog *tree.Prog) (err error) {
 		if len(prog.Levels) == 0 {
 			p.Errorf("no levels declared")
 		}
+	case lex.TokTransitions:
+		err = p.TransitionDecls(prog)
 	case lex.TokConsts:
 		err = p.ConstDecls(prog)
 	case lex.TokVars:
 		err = p.VarDecls(prog)
+	case lex.TokFuncs:
+		err = p.FuncDecls(prog)
 	case lex.TokRules:
-		err = p.RuleSect(prog, tokid)
+		err = p.RuleSect(prog, tokid, isfirst, period)
 	case lex.TokEof:
 		return nil
 	default:
@@ -487,6 +859,19 @@ const (
 	ErrTooMany = "too many errors"
 )
 
+// ParseBuffer parses src, the contents of the file fname (for the
+// positions and the included files). The errors are reported to
+// diags instead of written, prog is nil if it could not go on.
+func ParseBuffer(fname string, src []byte, diags *diag.List) (prog *tree.Prog) {
+	l, _ := lex.NewLexerRd(bytes.NewReader(src), fname, diags)
+	p := NewParser(l)
+	prog, err := p.Parse()
+	if err != nil && err.Error() != ErrTooMany {
+		diag.Errorf(diags, l.Pos(), lex.Position{}, "%s", err)
+	}
+	return prog
+}
+
 func (p *Parser) Parse() (prog *tree.Prog, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -554,12 +939,7 @@ func (p *Parser) popTrace(e *error) {
 const maxErrors = 10
 
 func (p *Parser) Errorf(s string, v ...interface{}) {
-	place := fmt.Sprintf("%s: ", p.l.Pos())
-	out := p.l.Errout()
-	if lex.DOut {
-		out = os.Stderr
-	}
-	fmt.Fprintf(out, place+s+"\n", v...)
+	diag.Errorf(p.l.Errout(), p.l.Pos(), lex.Position{}, s, v...)
 	p.nErr++
 	if p.nErr >= maxErrors {
 		panic(ErrTooMany)
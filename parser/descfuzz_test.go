@@ -125,10 +125,18 @@ func (f *DropLexer) Pos() lex.Position {
 	return f.l.Pos()
 }
 
+func (f *DropLexer) End() lex.Position {
+	return f.l.End()
+}
+
 func (f *DropLexer) Errout() io.Writer {
 	return f.l.Errout()
 }
 
+func (f *DropLexer) Include(fname string) error {
+	return f.l.Include(fname)
+}
+
 type InjectLexer struct {
 	l           lex.LexPeeker
 	n           int
@@ -164,10 +172,18 @@ func (f *InjectLexer) Pos() lex.Position {
 	return f.l.Pos()
 }
 
+func (f *InjectLexer) End() lex.Position {
+	return f.l.End()
+}
+
 func (f *InjectLexer) Errout() io.Writer {
 	return f.l.Errout()
 }
 
+func (f *InjectLexer) Include(fname string) error {
+	return f.l.Include(fname)
+}
+
 func LexTokenN(fname string, fcontent string, n int) (t lex.Token, err error) {
 	var l lex.LexPeeker
 	rd := strings.NewReader(examplefuzz)
@@ -276,10 +292,18 @@ func (f *NopLexer) Pos() lex.Position {
 	return f.l.Pos()
 }
 
+func (f *NopLexer) End() lex.Position {
+	return f.l.End()
+}
+
 func (f *NopLexer) Errout() io.Writer {
 	return f.l.Errout()
 }
 
+func (f *NopLexer) Include(fname string) error {
+	return f.l.Include(fname)
+}
+
 //go:embed descfuzz_test.go
 var descfuzz string
 
@@ -32,12 +32,16 @@ var precTab = map[lex.TokType]int{
 	lex.TokLEq:    10 * MaxNTerms,
 	lex.TokG:      10 * MaxNTerms,
 	lex.TokL:      10 * MaxNTerms,
+	lex.TokIn:     10 * MaxNTerms,
+	lex.TokLShift: 12 * MaxNTerms,
+	lex.TokRShift: 12 * MaxNTerms,
 	'+':           13 * MaxNTerms,
 	'-':           13 * MaxNTerms,
 	'*':           14 * MaxNTerms,
 	'/':           14 * MaxNTerms,
 	'%':           14 * MaxNTerms,
 	'(':           18 * MaxNTerms,
+	'[':           18 * MaxNTerms, //index of a map
 }
 
 // operators which appear here (are Unary) and be binary
@@ -84,9 +88,16 @@ var exprTokTab = map[lex.TokType]bool{
 	lex.TokLogAnd: true,
 	lex.TokLogOr:  true,
 	lex.TokCompl:  true,
+	lex.TokIn:     true,
+	lex.TokLShift: true,
+	lex.TokRShift: true,
+	lex.TokLBrace: true,
+	lex.TokLBrack: true,
 	//vals
 	lex.TokFloatVal: true,
 	lex.TokIntVal:   true,
+	lex.TokDurVal:   true,
+	lex.TokUintVal:  true,
 	lex.TokStrVal:   true,
 	lex.TokBoolVal:  true,
 	lex.TokId:       true,
@@ -117,6 +128,65 @@ func (p *Parser) parenExpr(tok lex.Token) (expr *tree.Sym, err error) {
 	return expr, nil
 }
 
+// helper for Led, the map is the left of expr
+// IndexExpr := Expr '[' Expr ']'
+func (p *Parser) indexExpr(expr *tree.Sym) (sexpr *tree.Sym, err error) {
+	key, err := p.Expr(defRbp)
+	if err != nil {
+		return expr, err
+	}
+	if key == nil {
+		return expr, errors.New("missing key for index")
+	}
+	expr.AddRight(key)
+	_, err, isclosed := p.match(lex.TokRBrack)
+	if err != nil {
+		return expr, err
+	}
+	if !isclosed {
+		return expr, errors.New("missing ']' at end of index")
+	}
+	return expr, nil
+}
+
+// helper for Nud
+// SetExpr := '{' Args '}'
+func (p *Parser) setExpr(pos lex.Position) (expr *tree.Sym, err error) {
+	p.pushTrace("Set")
+	defer p.popTrace(&err)
+	expr = tree.NewSetExpr()
+	expr.Pos = pos
+	if err = p.funcArgs(expr); err != nil {
+		return expr, err
+	}
+	_, err, isclosed := p.match(lex.TokRBrace)
+	if err != nil {
+		return expr, err
+	}
+	if !isclosed {
+		return expr, errors.New("missing '}' at end of set")
+	}
+	expr.End = p.l.End()
+	return expr, nil
+}
+
+// helper for Nud, rules are referred to by label,
+// which may be declared later (see tree.Prog.checkLabels)
+// RuleArg := ID
+func (p *Parser) ruleArg(expr *tree.Sym) (err error) {
+	tok, err, isid := p.match(lex.TokId)
+	if err != nil {
+		return err
+	}
+	if !isid {
+		return errors.New("expected rule name")
+	}
+	label := tree.NewLabel(tok.Lexema, tok.Pos)
+	label.End = tok.End
+	expr.AddArg(label)
+	return nil
+}
+
 // helper for Nud
 // Args :== Arg, Args	| empty
 func (p *Parser) funcArgs(expr *tree.Sym) (err error) {
@@ -163,6 +233,12 @@ func (p *Parser) SetExprVal(expr *tree.Sym, tok lex.Token, pos lex.Position) (sv
 	case lex.TokIntVal:
 		expr.DataType.TVal = types.TypeVals[types.TVInt]
 		expr.IntVal = tok.TokIntVal
+	case lex.TokDurVal:
+		expr.DataType.TVal = types.TypeVals[types.TVDuration]
+		expr.IntVal = tok.TokIntVal
+	case lex.TokUintVal:
+		expr.DataType.TVal = types.TypeVals[types.TVUint]
+		expr.IntVal = tok.TokIntVal
 	case lex.TokStrVal:
 		expr.DataType.TVal = types.TypeVals[types.TVString]
 		expr.StrVal = tok.TokStrVal
@@ -190,7 +266,7 @@ func (p *Parser) SetExprVal(expr *tree.Sym, tok lex.Token, pos lex.Position) (sv
 		//if not, is a binary/unary operation
 		expr.Expr.Op = int(tok.Type)
 	}
-	expr.Pos = pos
+	expr.Pos, expr.End = tok.Pos, pos
 	return svar
 }
 
@@ -206,8 +282,11 @@ func (p *Parser) Nud(tok lex.Token) (expr *tree.Sym, err error) {
 		p.dPrintf("nud:  parenthesis\n")
 		return expr, err
 	}
+	if tok.Type == lex.TokLBrace {
+		return p.setExpr(tok.Pos)
+	}
 	expr = tree.NewExpr(nil, nil)
-	expr = p.SetExprVal(expr, tok, p.l.Pos())
+	expr = p.SetExprVal(expr, tok, p.l.End())
 	rbp = bindPow(tok, false)
 	rtok := rune(tok.Type)
 	if rbp != defRbp { //regular unary operators
@@ -222,6 +301,7 @@ func (p *Parser) Nud(tok lex.Token) (expr *tree.Sym, err error) {
 			return expr, errors.New("unary operator without operand")
 		}
 		expr.AddRight(right)
+		expr.End = p.l.End()
 	}
 	_, err, islpar := p.match(lex.TokLPar)
 	if tok.Type != lex.TokId && islpar {
@@ -233,7 +313,11 @@ func (p *Parser) Nud(tok lex.Token) (expr *tree.Sym, err error) {
 	}
 	//is fcall
 	expr.SType = tree.SFCall
-	err = p.funcArgs(expr)
+	if expr.Expr != nil && expr.Expr.FCall.HasRuleArg() {
+		err = p.ruleArg(expr)
+	} else {
+		err = p.funcArgs(expr)
+	}
 	if err != nil {
 		return expr, err
 	}
@@ -244,6 +328,7 @@ func (p *Parser) Nud(tok lex.Token) (expr *tree.Sym, err error) {
 	if !isclosed {
 		return expr, errors.New("missing ')' at end of params")
 	}
+	expr.End = p.l.End()
 	return expr, nil
 }
 
@@ -253,7 +338,7 @@ func (p *Parser) Led(left *tree.Sym, tok lex.Token) (expr *tree.Sym, err error)
 	p.pushTrace("Led:")
 	defer p.popTrace(&err)
 	expr = tree.NewExpr(nil, nil)
-	expr = p.SetExprVal(expr, tok, p.l.Pos())
+	expr = p.SetExprVal(expr, tok, p.l.End())
 	expr.AddLeft(left)
 	rbp = bindPow(tok, false)
 	if isright := rightTab[rune(tok.Type)]; isright {
@@ -265,6 +350,9 @@ func (p *Parser) Led(left *tree.Sym, tok lex.Token) (expr *tree.Sym, err error)
 		}
 	}
 	p.dPrintf("led: %d, {{%s}} %s \n", rbp, left, tok)
+	if tok.Type == lex.TokLBrack {
+		return p.indexExpr(expr)
+	}
 	right, err := p.Expr(rbp)
 	if err != nil {
 		return expr, err
@@ -319,6 +407,7 @@ func (p *Parser) Expr(rbp int) (expr *tree.Sym, err error) {
 		return expr, nil
 	}
 	p.l.Lex() //already peeked
+	start := tok.Pos
 	if left, err = p.Nud(tok); err != nil {
 		return left, err
 	}
@@ -349,6 +438,7 @@ func (p *Parser) Expr(rbp int) (expr *tree.Sym, err error) {
 		if left, err = p.Led(left, tok); err != nil {
 			return expr, err
 		}
+		left.Pos, left.End = start, p.l.End() //the operands may be vars, with the position of their decl
 		expr = left
 	}
 }
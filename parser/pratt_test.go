@@ -293,6 +293,17 @@ var genExprsStrings = []exprExampString{
 	{`"\\" == "\x5c"`, false, true, false},
 	{`"\xe2\x86\x92" == "→"`, false, true, false},
 	{`"\u2192" == "→"`, false, true, false},
+	{`"content" in {"a", varstr}`, false, true, false}, //sets
+	{`"b" in ({"a"} | {"b"})`, false, true, false},
+	{`"a" in ({"a", "b"} & {"b"})`, false, false, false},
+	{`{"a", "b"} == {"b", "a", "b"}`, false, true, false},
+	{`{"a"} != {"a", varstr}`, false, true, false},
+	{`"a" in {}`, false, false, false},
+	{`3 in {"a"}`, true, false, false},
+	{`"a" in {"a"`, true, false, false},
+	{`"a" in {"a" "b"}`, true, false, false},
+	{`varstr["a"] == "a"`, true, false, false}, //only maps are indexed
+	{`varstr["a" == "a"`, true, false, false},
 	{`"
 " == "\n"`, false, true, false}, //multiline strings
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"rips/rips/diag"
 	"rips/rips/extern"
+	"rips/rips/format"
+	"rips/rips/lsp"
 	"rips/rips/stats"
 	"rips/rips/xrips"
 	godebug "runtime/debug"
@@ -21,7 +25,9 @@ const DebugSpy = true
 const HasStats = true
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: rips [-s sockpath|-c] [-r rootpath] [-D] [pathscripts] file.rul\n")
+	fmt.Fprintf(os.Stderr, "usage: rips [-s sockpath|-c|-l|-lj] [-r rootpath] [-D] [pathscripts] file.rul\n")
+	fmt.Fprintf(os.Stderr, "       rips fmt [-d|-w] file.rul...\n")
+	fmt.Fprintf(os.Stderr, "       rips lsp\n")
 	os.Exit(1)
 }
 
@@ -39,6 +45,94 @@ func spliceConn(context *extern.Ctx, conn *net.Conn) (err error) {
 	return nil
 }
 
+// Prints the files formatted, or the diff (-d), or
+// rewrites the ones which change (-w)
+func fmtFiles(args []string) {
+	isdiff, iswrite := false, false
+	for len(args) > 0 && len(args[0]) > 0 && args[0][0] == '-' {
+		switch args[0] {
+		case "-d":
+			isdiff = true
+		case "-w":
+			iswrite = true
+		default:
+			usage()
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 || isdiff && iswrite {
+		usage()
+	}
+	nerr := 0
+	for _, fname := range args {
+		src, err := os.ReadFile(fname)
+		if err == nil {
+			err = fmtFile(fname, src, isdiff, iswrite)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			nerr++
+		}
+	}
+	if nerr > 0 {
+		os.Exit(1)
+	}
+}
+
+func fmtFile(fname string, src []byte, isdiff bool, iswrite bool) error {
+	out, err := format.Source(fname, src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case isdiff:
+		os.Stdout.Write(format.Diff(fname, src, out))
+	case iswrite:
+		if bytes.Equal(src, out) {
+			return nil
+		}
+		fi, err := os.Stat(fname)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(fname, out, fi.Mode().Perm())
+	default:
+		os.Stdout.Write(out)
+	}
+	return nil
+}
+
+// warnings do not fail, errors do as when running. The errors are
+// with the warnings, in the same list, in text and in JSON.
+func lint(fname string, deblevel int, isjson bool) {
+	var stats stats.Stats
+	pfile, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pfile.Close()
+	r := xrips.NewRips(fname, pfile, deblevel, io.Discard)
+	_, err = r.BuildAst(&stats)
+	ds := r.Diags.Diags
+	//the lint needs the types checked
+	if err == nil || r.Diags.Stage >= diag.Fold {
+		ds = append(ds, r.Program.Lint()...)
+		diag.Sort(ds)
+	}
+	if isjson {
+		js, jerr := diag.JSON(ds)
+		if jerr != nil {
+			log.Fatal(jerr)
+		}
+		fmt.Printf("%s\n", js)
+	} else {
+		r.PrintDiags(os.Stdout, ds)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
 func main() {
 	var stats stats.Stats
 	var r *xrips.Rips
@@ -66,12 +160,28 @@ func main() {
 	if len(args) < 1 {
 		usage()
 	}
+	if args[0] == "fmt" {
+		fmtFiles(args[1:])
+		return
+	}
+	if args[0] == "lsp" {
+		if len(args) != 1 {
+			usage()
+		}
+		//the editor talks to it through the standard input and output
+		if err := lsp.NewServer(os.Stdout).Serve(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	fname := args[len(args)-1]
 	args = args[:len(args)-1]
 
 	sockpath := DefSockPath
 	iscompile := false
 	issock := false
+	islint := false
+	isjson := false
 	rootpath := "."
 	doneargs := false
 	for len(args) > 0 && len(args[0]) >= 2 && args[0][0] == '-' {
@@ -94,6 +204,13 @@ func main() {
 				sockpath = args[1]
 				args = args[2:]
 			}
+		case "-l":
+			if iscompile || issock {
+				usage()
+			}
+			islint = true
+			isjson = args[0] == "-lj"
+			args = args[1:]
 		case "-r":
 			if len(args) > 2 {
 				rootpath = args[1]
@@ -120,6 +237,13 @@ func main() {
 		pathscripts = args[len(args)-1]
 		args = args[0 : len(args)-1]
 	}
+	if islint {
+		if len(args) != 0 {
+			usage()
+		}
+		lint(fname, deblevel, isjson)
+		return
+	}
 	if !extern.IsExecutable(pathscripts) || !extern.IsReadable(pathscripts) {
 		fmt.Fprintf(os.Stderr, "cannot access path for scripts: %s from %s\n", pathscripts, extern.CurrDir())
 		usage()
@@ -140,7 +264,8 @@ func main() {
 		log.Fatal(err)
 	}
 
-	context := extern.NewContext(nil, pathscripts, len(r.Program.Levels), r.Lexer.Errout(), &stats)
+	context := extern.NewContext(nil, pathscripts, len(r.Program.Levels), r.Errout, &stats)
+	context.Transitions = r.Program.Transitions
 	for _, level := range r.Program.Levels {
 		context.AddLevel(level.Name)
 	}
@@ -177,7 +302,8 @@ func main() {
 	mc := make(chan *extern.Msg, 1)
 	mcr := make(chan *extern.Msg, 1)
 	execEnv := r.Program.NewExecEnv(context)
-	coremain := func(context *extern.Ctx) { r.Program.Interp(context, execEnv) }
+	vm := r.Program.NewVM(execEnv)
+	coremain := func(context *extern.Ctx) { vm.Interp(context) }
 	d := &extern.Dispatch{
 		Coremain: coremain,
 		Sockpath: sockpath,
@@ -185,6 +311,8 @@ func main() {
 		Mc:       mc,
 		Mcr:      mcr,
 		Pathsc:   pathsc,
+		Timers:   r.Program.Timers(),
+		Afters:   r.Program.Afters(),
 	}
 	go extern.Dispatcher(context, d)
 	// Accept an incoming connection.
@@ -14,25 +14,188 @@ const DebSet = false
 // Take care, if IsVariadic and there is a mandatory argument,
 //
 //	add two arguments because Variadic may mean zero args.
+//
+// Most builtins are an extern function, it is their Typed entry
+// point (one of the signatures of callTyped) and there is no Fn.
 type Builtin struct {
 	Name       string
 	Fn         func(context *extern.Ctx, args ...*Sym) *Sym
+	Typed      any //the extern function, see callTyped
 	RetType    types.Type
 	ArgTypes   []types.Type
 	IsVariadic bool //last argtype is repeated, may be zero
 	IsAction   bool
+	IsPure     bool //the same for the same event and args, memoized, see call
+	Cost       Cost //cheap unless set, see reorder
+}
+
+// Cost classes of the builtins, the cheap conditions are evaluated
+// first, see reorder
+type Cost int
+
+const (
+	CostCheap Cost = iota //vars, strings, the topic or type of the msg
+	CostMsg               //looking into the msg fields or the graph
+	CostScan              //scanning the payload, running a plugin
+)
+
+// Builtins which change the state, besides the actions (rate only
+// starts its counter the first time). The conditions calling them
+// are not reordered, see reorder.
+var effectBuiltins = map[string]bool{
+	"signal":  true,
+	"count":   true,
+	"rate":    true,
+	"countif": true,
+	"delete":  true,
+}
+
+// The argument of the sliding windows builtins which is the window,
+// it has to be positive, see Fold
+var windowArgs = map[string]int{
+	"count":   1,
+	"rate":    0,
+	"countif": 0,
 }
 
 func (envs *StkEnv) Builtins(bs []*Builtin) {
 	for _, b := range bs {
-		_, err := envs.NewFunc(b.Name, b.ArgTypes, b.RetType, b.Fn, b.IsVariadic, b.IsAction)
+		fn := b.Fn
+		if fn == nil {
+			fn = typedFn(b.Typed)
+		}
+		f, err := envs.NewFunc(b.Name, b.ArgTypes, b.RetType, fn, b.IsVariadic, b.IsAction)
 		if err != nil {
 			s := fmt.Sprintf("problem pushing builtin \"%s\": %s\n", b.Name, err)
 			panic(s)
 		}
+		f.IsPure = b.IsPure
+		f.Cost = b.Cost
+		f.Typed = b.Typed
+	}
+}
+
+// the entry point of the tree for the builtins with only a typed one
+func typedFn(typed any) BuiltinFunc {
+	return func(context *extern.Ctx, args ...*Sym) *Sym {
+		val := NewAnonSym(SConst)
+		callTyped(context, typed, args, val)
+		return val
+	}
+}
+
+// callTyped calls the typed entry point fn of a builtin with the
+// values of args and leaves the result in val, undef for a missing
+// msg field. The VM keeps val, so calling it does not allocate.
+// A StrSet parameter takes the rest of the args, see SetArgs.
+func callTyped(context *extern.Ctx, fn any, args []*Sym, val *Sym) {
+	switch fn := fn.(type) {
+	case func(*extern.Ctx) bool:
+		val.setBool(fn(context))
+	case func(*extern.Ctx) string:
+		val.setString(fn(context))
+	case func(*extern.Ctx, string) bool:
+		val.setBool(fn(context, args[0].StrVal))
+	case func(*extern.Ctx, string, string) bool:
+		val.setBool(fn(context, args[0].StrVal, args[1].StrVal))
+	case func(*extern.Ctx, string, int64, int64) bool:
+		val.setBool(fn(context, args[0].StrVal, args[1].IntVal, args[2].IntVal))
+	case func(*extern.Ctx, int64, int64) bool:
+		val.setBool(fn(context, args[0].IntVal, args[1].IntVal))
+	case func(*extern.Ctx, *extern.Map[any], string) bool:
+		val.setBool(fn(context, args[0].MapVal, args[1].StrVal))
+	case func(*extern.Ctx, extern.StrSet) bool:
+		val.setBool(fn(context, SetArgs(args...)))
+	case func(*extern.Ctx, string, extern.StrSet) bool:
+		val.setBool(fn(context, args[0].StrVal, SetArgs(args[1:]...)))
+	case func(*extern.Ctx, string) string:
+		val.setString(fn(context, args[0].StrVal))
+	case func(*extern.Ctx, string, string, string) string:
+		val.setString(fn(context, args[0].StrVal, args[1].StrVal, args[2].StrVal))
+	case func(*extern.Ctx, string, int64, int64) string:
+		val.setString(fn(context, args[0].StrVal, args[1].IntVal, args[2].IntVal))
+	case func(*extern.Ctx, int64) int64:
+		val.setInt(fn(context, args[0].IntVal))
+	case func(*extern.Ctx, string, string) int64:
+		val.setInt(fn(context, args[0].StrVal, args[1].StrVal))
+	case func(*extern.Ctx, string, int64) int64:
+		val.setInt(fn(context, args[0].StrVal, args[1].IntVal))
+	case func(*extern.Ctx, int64, string) int64:
+		val.setInt(fn(context, args[0].IntVal, args[1].StrVal))
+	case func(*extern.Ctx, float64) float64:
+		val.setFloat(fn(context, args[0].FloatVal))
+	case func(*extern.Ctx, float64, float64) float64:
+		val.setFloat(fn(context, args[0].FloatVal, args[1].FloatVal))
+	case func(*extern.Ctx, string) (int64, bool):
+		if v, ok := fn(context, args[0].StrVal); ok {
+			val.setInt(v)
+		}
+	case func(*extern.Ctx, string) (float64, bool):
+		if v, ok := fn(context, args[0].StrVal); ok {
+			val.setFloat(v)
+		}
+	case func(*extern.Ctx, string) (string, bool):
+		if v, ok := fn(context, args[0].StrVal); ok {
+			val.setString(v)
+		}
+	default:
+		panic(fmt.Sprintf("unknown signature of builtin %T, cannot happen", fn))
 	}
 }
 
+// call runs the builtin f, the pure ones once for the event and
+// args (the result is shared, it is not modified by the callers)
+func (f *Sym) call(context *extern.Ctx, args []*Sym) *Sym {
+	if !f.IsPure {
+		return f.Fn(context, args...)
+	}
+	//the msg fields, without allocating, see TestVMAllocs
+	if len(args) == 1 && args[0].isMemoStr() {
+		fn := func(string) *Sym {
+			return f.Fn(context, args...)
+		}
+		return extern.MemoStr(context, f.Name, fn, args[0].StrVal)
+	}
+	keys := make([]any, len(args))
+	for i, a := range args {
+		k, ok := a.memoArg()
+		if !ok {
+			return f.Fn(context, args...)
+		}
+		keys[i] = k
+	}
+	fn := func(...any) *Sym {
+		return f.Fn(context, args...)
+	}
+	return extern.Memo(context, f.Name, fn, keys...)
+}
+
+// the arg a is a string for the key of the memo, yara rules and
+// regexps by their string, like the generated code
+func (a *Sym) isMemoStr() bool {
+	return a.SType == SYara || a.SType == SRegexp || a.DataType.TVal == types.TypeVals[types.TVString]
+}
+
+// the value of the arg a for the key of the memo, not ok for sets
+// and maps, which are not comparable, the call is not memoized
+func (a *Sym) memoArg() (key any, ok bool) {
+	if a.isMemoStr() {
+		return a.StrVal, true
+	}
+	if a.DataType.TVal.IsMap() {
+		return nil, false
+	}
+	switch a.DataType.TVal {
+	case types.TypeVals[types.TVBool]:
+		return a.BoolVal, true
+	case types.TypeVals[types.TVFloat]:
+		return a.FloatVal, true
+	case types.TypeVals[types.TVSet]:
+		return nil, false
+	}
+	return a.IntVal, true
+}
+
 // Normal Expressions
 func LevelName(context *extern.Ctx, args ...*Sym) *Sym {
 	level := args[0]
@@ -53,6 +216,12 @@ func String(context *extern.Ctx, args ...*Sym) *Sym {
 		str = extern.String(context, s.BoolVal)
 	case types.TypeVals[types.TVInt]:
 		str = extern.String(context, s.IntVal)
+	case types.TypeVals[types.TVUint]:
+		str = extern.String(context, uint64(s.IntVal))
+	case types.TypeVals[types.TVDuration]:
+		str = extern.String(context, extern.Duration(s.IntVal))
+	case types.TypeVals[types.TVTime]:
+		str = extern.String(context, extern.Timestamp(s.IntVal))
 	case types.TypeVals[types.TVFloat]:
 		str = extern.String(context, s.FloatVal)
 	case types.TypeVals[types.TVString]:
@@ -67,13 +236,27 @@ func String(context *extern.Ctx, args ...*Sym) *Sym {
 // Set is special, the implementation is here
 func Set(context *extern.Ctx, args ...*Sym) *Sym {
 	_ = context //does not use
-	args[0].SetVal(args[1])
+	args[0].copyVal(args[1])
 	if DebSet {
 		fmt.Fprintf(os.Stderr, "set call, %s\n", args)
 	}
 	return NewBool(true)
 }
 
+// setfor is set, the declared value of the var is restored when it expires
+func SetFor(context *extern.Ctx, args ...*Sym) *Sym {
+	args[0].copyVal(args[1])
+	v := extern.SetFor(context, args[0].Name, args[2].IntVal)
+	return NewBool(v)
+}
+
+// the arg of ttl is the var, not its value, see EvalExpr
+func TTL(context *extern.Ctx, args ...*Sym) *Sym {
+	val := NewInt(extern.TTL(context, args[0].Name))
+	val.DataType = types.DurationType
+	return val
+}
+
 // False and True are for debugging, they are also implementations
 // They return (false, true) and they print their syms
 func True(context *extern.Ctx, args ...*Sym) *Sym {
@@ -119,13 +302,24 @@ func Crash(context *extern.Ctx, args ...*Sym) *Sym {
 	return nil
 }
 
-func Alert(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Alert(context, args[0].StrVal)
+func Exec(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Exec(context, args[0].StrVal, VarArgs(args[1:]...)...)
 	return NewBool(v)
 }
 
-func Exec(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Exec(context, args[0].StrVal, VarArgs(args[1:]...)...)
+// In enable and disable the arg is an SRule symbol, the label of the rule
+func Enable(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Enable(context, args[0].Name)
+	return NewBool(v)
+}
+
+func Disable(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Disable(context, args[0].Name)
+	return NewBool(v)
+}
+
+func Enabled(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Enabled(context, args[0].Name)
 	return NewBool(v)
 }
 
@@ -138,154 +332,249 @@ func Trigger(context *extern.Ctx, args ...*Sym) *Sym {
 	}
 	slevelto := args[0]
 	// same is nop
-	v := extern.Trigger(context, slevelto.Name, slevelto.SLevel, currlevel.Val.Name, currlevel.Val.SLevel, currlevel.Val.IsSoft)
+	v := extern.TriggerAction(context, slevelto.Name, slevelto.SLevel, currlevel.Val.Name, currlevel.Val.SLevel, currlevel.Val.IsSoft)
 	if v {
 		currlevel.Val = slevelto
-	} else {
-		context.Printf("trigger: could not change level %s[%d] -> %s[%d]\n", currlevel.Val.Name, currlevel.Val.SLevel, slevelto.Name, slevelto.SLevel)
 	}
 	return NewBool(v)
 }
 
-//Expressions
-
-// Msg expressions
-func VarArgs(args ...*Sym) (strargs []string) {
-	for _, arg := range args {
-		strargs = append(strargs, arg.StrVal)
+// Variadic expressions taking strings, the strings can also
+// be given as one set (constant sets are built in Fold).
+func (f *Sym) isSetFunc() bool {
+	if !f.IsVariadic || f.IsAction || len(f.ArgDataTypes) == 0 {
+		return false
 	}
-	return strargs
-}
-func MsgSubtype(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.MsgSubtype(context, args[0].StrVal, args[1].StrVal)
-	return NewBool(v)
+	at := f.ArgDataTypes[len(f.ArgDataTypes)-1]
+	return at.TVal == types.TypeVals[types.TVString]
 }
 
-func MsgTypeIn(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.MsgTypeIn(context, VarArgs(args...)...)
-	return NewBool(v)
-}
-func Payload(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Payload(context, args[0].StrVal, args[0].Yr)
-	return NewBool(v)
+// enable, disable... take a rule label, see the parser
+func (f *Sym) HasRuleArg() bool {
+	if f == nil || len(f.ArgDataTypes) != 1 {
+		return false
+	}
+	return f.ArgDataTypes[0].TVal == types.TypeVals[types.TVRule]
 }
-func Plugin(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Plugin(context, args[0].StrVal)
-	return NewBool(v)
 
+// The set of the args, the constant ones are folded into a set,
+// see Fold, so it is only built here if they are not
+func SetArgs(args ...*Sym) extern.StrSet {
+	if len(args) == 1 && args[0].DataType.TVal == types.TypeVals[types.TVSet] {
+		return args[0].StrSetVal
+	}
+	set := make(extern.StrSet, len(args))
+	for _, arg := range args {
+		set[arg.StrVal] = true
+	}
+	return set
 }
-func PublisherCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.PublisherCount(context, args[0].IntVal, args[1].IntVal)
-	return NewBool(v)
-}
-func Publishers(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Publishers(context, VarArgs(args...)...)
-	return NewBool(v)
 
-}
-func PublishersInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.PublishersInclude(context, VarArgs(args...)...)
-	return NewBool(v)
+// len is also the length of a string, see Annotate
+func Len(context *extern.Ctx, args ...*Sym) *Sym {
+	if args[0].DataType.TVal == types.TypeVals[types.TVString] {
+		return NewInt(extern.StrLen(context, args[0].StrVal))
+	}
+	if args[0].DataType.TVal.IsMap() {
+		return NewInt(extern.MapLen(context, args[0].MapVal))
+	}
+	v := extern.SetLen(context, args[0].StrSetVal)
+	return NewInt(v)
 }
 
-//Unimplementable
-//func SenderIn(context *extern.Ctx, args ...*Sym) *Sym {}
-
-func SubscriberCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.SubscriberCount(context, args[0].IntVal, args[1].IntVal)
-	return NewBool(v)
-}
-func Subscribers(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Subscribers(context, VarArgs(args...)...)
-	return NewBool(v)
-}
-func SubscribersInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.SubscribersInclude(context, VarArgs(args...)...)
-	return NewBool(v)
-}
-func TopicIn(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicIn(context, VarArgs(args...)...)
-	return NewBool(v)
+// Builtins without side effects, folded when the arguments are constant
+var constBuiltins = map[string]bool{
+	"contains":   true,
+	"hasprefix":  true,
+	"hassuffix":  true,
+	"lower":      true,
+	"upper":      true,
+	"substr":     true,
+	"replace":    true,
+	"splitcount": true,
+	"format":     true,
+	"float":      true,
+	"int":        true,
+	"uint":       true,
+	"abs":        true,
+	"min":        true,
+	"max":        true,
+	"sqrt":       true,
+	"pow":        true,
+	"floor":      true,
+	"ceil":       true,
+	"round":      true,
+	"hypot":      true,
 }
-func TopicMatches(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicMatches(context, args[0].StrVal, args[0].Re)
-	return NewBool(v)
+
+// Builtins taking ints or floats (also durations), the type of
+// the first argument is the type of the rest, see Annotate.
+// float, int and uint convert, the others return the same type.
+var numBuiltins = map[string]bool{
+	"float": true,
+	"int":   true,
+	"uint":  true,
+	"abs":   true,
+	"min":   true,
+	"max":   true,
 }
 
-// Graph expressions
-func NodeCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.NodeCount(context, args[0].IntVal, args[1].IntVal)
-	return NewBool(v)
+// Builtins returning floats which may be NaN or Inf, see extern.CheckFloat
+var mathBuiltins = map[string]bool{
+	"sqrt":  true,
+	"pow":   true,
+	"floor": true,
+	"ceil":  true,
+	"round": true,
+	"hypot": true,
 }
-func Nodes(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Nodes(context, VarArgs(args...)...)
-	return NewBool(v)
+
+func isNumVal(tvp *types.TypeVal) bool {
+	return isIntVal(tvp) || tvp == types.TypeVals[types.TVFloat]
 }
-func NodesInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.NodesInclude(context, VarArgs(args...)...)
-	return NewBool(v)
+
+func Float(context *extern.Ctx, args ...*Sym) *Sym {
+	if args[0].DataType.TVal == types.TypeVals[types.TVFloat] {
+		return NewFloat(args[0].FloatVal)
+	}
+	if args[0].DataType.TVal == types.TypeVals[types.TVUint] {
+		return NewFloat(float64(uint64(args[0].IntVal)))
+	}
+	return NewFloat(float64(args[0].IntVal))
 }
-func Service(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Service(context, args[0].StrVal, args[1].StrVal)
-	return NewBool(v)
+func Int(context *extern.Ctx, args ...*Sym) *Sym {
+	if args[0].DataType.TVal == types.TypeVals[types.TVFloat] {
+		return NewInt(int64(args[0].FloatVal))
+	}
+	return NewInt(args[0].IntVal)
 }
-func ServiceCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.ServiceCount(context, args[0].StrVal, args[1].IntVal, args[2].IntVal)
-	return NewBool(v)
+func Uint(context *extern.Ctx, args ...*Sym) *Sym {
+	if args[0].DataType.TVal == types.TypeVals[types.TVFloat] {
+		return NewUint(uint64(args[0].FloatVal))
+	}
+	return NewUint(uint64(args[0].IntVal))
 }
-func Services(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Services(context, args[0].StrVal, VarArgs(args[1:]...)...)
-	return NewBool(v)
+
+// int and uint of a float which does not fit are an error when
+// evaluated, like the NaN and Inf of the math builtins, see extern.FloatInt
+func checkConv(context *extern.Ctx, s *Sym, args []*Sym) {
+	if context == nil || !s.isFloatConv(args) {
+		return
+	}
+	if s.Name == "int" {
+		extern.FloatInt(context, s.Pos.String(), args[0].FloatVal)
+	} else {
+		extern.FloatUint(context, s.Pos.String(), args[0].FloatVal)
+	}
 }
-func ServicesInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.ServicesInclude(context, args[0].StrVal, VarArgs(args[1:]...)...)
-	return NewBool(v)
+
+// s is int or uint of a float
+func (s *Sym) isFloatConv(args []*Sym) bool {
+	isconv := s.Name == "int" || s.Name == "uint"
+	return isconv && len(args) == 1 && args[0].DataType.TVal == types.TypeVals[types.TVFloat]
 }
-func TopicCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicCount(context, args[0].IntVal, args[1].IntVal)
-	return NewBool(v)
+
+// a value of the type of like, see numBuiltins
+func numVal(like *Sym, i int64, f float64) *Sym {
+	if like.DataType.TVal == types.TypeVals[types.TVFloat] {
+		return NewFloat(f)
+	}
+	v := NewInt(i)
+	v.DataType.TVal = like.DataType.TVal
+	return v
 }
-func TopicPublisherCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicPublisherCount(context, args[0].StrVal, args[1].IntVal, args[2].IntVal)
-	return NewBool(v)
+func isUintVal(s *Sym) bool {
+	return s.DataType.TVal == types.TypeVals[types.TVUint]
 }
-func TopicPublishers(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicPublishers(context, args[0].StrVal, VarArgs(args[1:]...)...)
-	return NewBool(v)
+func Abs(context *extern.Ctx, args ...*Sym) *Sym {
+	x := args[0]
+	if isUintVal(x) {
+		return NewUint(extern.AbsUint(context, uint64(x.IntVal)))
+	}
+	return numVal(x, extern.AbsInt(context, x.IntVal), extern.AbsFloat(context, x.FloatVal))
 }
-func TopicPublishersInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicPublishersInclude(context, args[0].StrVal, VarArgs(args[1:]...)...)
-	return NewBool(v)
+func Min(context *extern.Ctx, args ...*Sym) *Sym {
+	x, y := args[0], args[1]
+	if isUintVal(x) {
+		return NewUint(extern.MinUint(context, uint64(x.IntVal), uint64(y.IntVal)))
+	}
+	return numVal(x, extern.MinInt(context, x.IntVal, y.IntVal), extern.MinFloat(context, x.FloatVal, y.FloatVal))
 }
-func Topics(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Topics(context, VarArgs(args...)...)
-	return NewBool(v)
+func Max(context *extern.Ctx, args ...*Sym) *Sym {
+	x, y := args[0], args[1]
+	if isUintVal(x) {
+		return NewUint(extern.MaxUint(context, uint64(x.IntVal), uint64(y.IntVal)))
+	}
+	return numVal(x, extern.MaxInt(context, x.IntVal, y.IntVal), extern.MaxFloat(context, x.FloatVal, y.FloatVal))
 }
-func TopicsInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicsInclude(context, VarArgs(args...)...)
-	return NewBool(v)
+
+// the values as they are in the generated code, see prprintvars
+func anyArgs(args ...*Sym) (vals []any) {
+	vals = make([]any, 0, len(args))
+	for _, a := range args {
+		if a.SType == SLevel {
+			vals = append(vals, int64(a.SLevel))
+			continue
+		}
+		switch a.DataType.TVal {
+		case types.TypeVals[types.TVBool]:
+			vals = append(vals, a.BoolVal)
+		case types.TypeVals[types.TVInt]:
+			vals = append(vals, a.IntVal)
+		case types.TypeVals[types.TVUint]:
+			vals = append(vals, uint64(a.IntVal))
+		case types.TypeVals[types.TVDuration]:
+			vals = append(vals, extern.Duration(a.IntVal))
+		case types.TypeVals[types.TVTime]:
+			vals = append(vals, extern.Timestamp(a.IntVal))
+		case types.TypeVals[types.TVFloat]:
+			vals = append(vals, a.FloatVal)
+		case types.TypeVals[types.TVSet]:
+			vals = append(vals, a.StrSetVal)
+		default:
+			vals = append(vals, a.StrVal)
+		}
+	}
+	return vals
 }
-func TopicSubscriberCount(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicSubscriberCount(context, args[0].StrVal, args[1].IntVal, args[2].IntVal)
-	return NewBool(v)
+
+func Format(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Format(context, args[0].StrVal, anyArgs(args[1:]...)...)
+	return NewString(v)
 }
-func TopicSubscribers(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicSubscribers(context, args[0].StrVal, VarArgs(args[1:]...)...)
-	return NewBool(v)
+
+//Expressions
+
+// Msg expressions
+func VarArgs(args ...*Sym) (strargs []string) {
+	strargs = make([]string, len(args))
+	for i, arg := range args {
+		strargs[i] = arg.StrVal
+	}
+	return strargs
 }
-func TopicSubscribersInclude(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.TopicSubscribersInclude(context, args[0].StrVal, VarArgs(args[1:]...)...)
+
+func Payload(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.Payload(context, args[0].StrVal, args[0].Yr)
 	return NewBool(v)
 }
 
-// External expression
-func Signal(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.Signal(context, args[0].StrVal)
+//Unimplementable
+//func SenderIn(context *extern.Ctx, args ...*Sym) *Sym {}
+
+func TopicMatches(context *extern.Ctx, args ...*Sym) *Sym {
+	v := extern.TopicMatches(context, args[0].StrVal, args[0].Re)
 	return NewBool(v)
 }
-func IdsAlert(context *extern.Ctx, args ...*Sym) *Sym {
-	v := extern.IdsAlert(context, args[0].StrVal)
-	return NewBool(v)
+
+// Builtins taking a path to a msg field, true if they can be
+// undef (the others are false for a missing field).
+var msgFieldBuiltins = map[string]bool{
+	"msgint":   true,
+	"msgfloat": true,
+	"msgstr":   true,
+	"msgbool":  false,
+	"msghas":   false,
 }
 
 var Builtins = []*Builtin{
@@ -298,6 +587,14 @@ var Builtins = []*Builtin{
 		IsVariadic: false,
 		IsAction:   true,
 	},
+	//setfor is set with the duration after which the var expires
+	{Name: "setfor",
+		RetType:    types.BoolType,
+		Fn:         SetFor,
+		ArgTypes:   []types.Type{types.UnivType, types.UnivType, types.DurationType},
+		IsVariadic: false,
+		IsAction:   true,
+	},
 	//trigger is special, the first argument is an SLevel symbol
 	{Name: "trigger",
 		RetType:    types.BoolType,
@@ -308,11 +605,32 @@ var Builtins = []*Builtin{
 	},
 	{Name: "alert",
 		RetType:    types.BoolType,
-		Fn:         Alert,
+		Typed:      extern.Alert,
 		ArgTypes:   []types.Type{types.StringType},
 		IsVariadic: false,
 		IsAction:   true,
 	},
+	{Name: "enable",
+		RetType:    types.BoolType,
+		Fn:         Enable,
+		ArgTypes:   []types.Type{types.RuleType},
+		IsVariadic: false,
+		IsAction:   true,
+	},
+	{Name: "disable",
+		RetType:    types.BoolType,
+		Fn:         Disable,
+		ArgTypes:   []types.Type{types.RuleType},
+		IsVariadic: false,
+		IsAction:   true,
+	},
+	{Name: "stop",
+		RetType:    types.BoolType,
+		Typed:      extern.Stop,
+		ArgTypes:   nil,
+		IsVariadic: false,
+		IsAction:   true,
+	},
 	{Name: "exec",
 		RetType:    types.BoolType,
 		Fn:         Exec,
@@ -346,14 +664,16 @@ var Builtins = []*Builtin{
 	//Messages expressions
 	{Name: "msgsubtype",
 		RetType:    types.BoolType,
-		Fn:         MsgSubtype,
+		Typed:      extern.MsgSubtype,
 		ArgTypes:   []types.Type{types.MsgStrType, types.MsgStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{Name: "msgtypein",
 		RetType:    types.BoolType,
-		Fn:         MsgTypeIn,
+		Typed:      extern.MsgTypeInSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
@@ -365,71 +685,91 @@ var Builtins = []*Builtin{
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostScan,
 	},
 	{
 		Name:       "plugin",
 		RetType:    types.BoolType,
-		Fn:         Plugin,
+		Typed:      extern.Plugin,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostScan,
 	},
 	{
 		Name:       "publishercount",
 		RetType:    types.BoolType,
-		Fn:         PublisherCount,
+		Typed:      extern.PublisherCount,
 		ArgTypes:   []types.Type{types.MsgIntType, types.MsgIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "publishers",
 		RetType:    types.BoolType,
-		Fn:         Publishers,
+		Typed:      extern.PublishersSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "publishersinclude",
 		RetType:    types.BoolType,
-		Fn:         PublishersInclude,
+		Typed:      extern.PublishersIncludeSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "subscribercount",
 		RetType:    types.BoolType,
-		Fn:         SubscriberCount,
+		Typed:      extern.SubscriberCount,
 		ArgTypes:   []types.Type{types.MsgIntType, types.MsgIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "subscribers",
 		RetType:    types.BoolType,
-		Fn:         Subscribers,
+		Typed:      extern.SubscribersSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "subscribersinclude",
 		RetType:    types.BoolType,
-		Fn:         SubscribersInclude,
+		Typed:      extern.SubscribersIncludeSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicin",
 		RetType:    types.BoolType,
-		Fn:         TopicIn,
+		Typed:      extern.TopicInSet,
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: true,
 		IsAction:   false,
 	},
+	{
+		Name:       "topicname",
+		RetType:    types.MsgStrType,
+		Typed:      extern.TopicName,
+		ArgTypes:   []types.Type{},
+		IsVariadic: false,
+		IsAction:   false,
+	},
 	{
 		Name:       "topicmatches",
 		RetType:    types.BoolType,
@@ -437,141 +777,217 @@ var Builtins = []*Builtin{
 		ArgTypes:   []types.Type{types.MsgStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
+	},
+	//msg fields, a missing field or one of another type is undef
+	{
+		Name:       "msgint",
+		RetType:    types.MsgIntType,
+		Typed:      extern.MsgFieldInt,
+		ArgTypes:   []types.Type{types.MsgStrType},
+		IsVariadic: false,
+		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
+	},
+	{
+		Name:       "msgfloat",
+		RetType:    types.MsgFloatType,
+		Typed:      extern.MsgFieldFloat,
+		ArgTypes:   []types.Type{types.MsgStrType},
+		IsVariadic: false,
+		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
+	},
+	{
+		Name:       "msgstr",
+		RetType:    types.MsgStrType,
+		Typed:      extern.MsgFieldStr,
+		ArgTypes:   []types.Type{types.MsgStrType},
+		IsVariadic: false,
+		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
+	},
+	//a missing field or one of another type is false
+	{
+		Name:       "msgbool",
+		RetType:    types.MsgBoolType,
+		Typed:      extern.MsgBool,
+		ArgTypes:   []types.Type{types.MsgStrType},
+		IsVariadic: false,
+		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
+	},
+	{
+		Name:       "msghas",
+		RetType:    types.MsgBoolType,
+		Typed:      extern.MsgHas,
+		ArgTypes:   []types.Type{types.MsgStrType},
+		IsVariadic: false,
+		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	//Graph expressions
 	{
 		Name:       "nodecount",
 		RetType:    types.BoolType,
-		Fn:         NodeCount,
+		Typed:      extern.NodeCount,
 		ArgTypes:   []types.Type{types.GraphIntType, types.GraphIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "nodes",
 		RetType:    types.BoolType,
-		Fn:         Nodes,
+		Typed:      extern.NodesSet,
 		ArgTypes:   []types.Type{types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "nodesinclude",
 		RetType:    types.BoolType,
-		Fn:         NodesInclude,
+		Typed:      extern.NodesIncludeSet,
 		ArgTypes:   []types.Type{types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "service",
 		RetType:    types.BoolType,
-		Fn:         Service,
+		Typed:      extern.Service,
 		ArgTypes:   []types.Type{types.GraphStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "servicecount",
 		RetType:    types.BoolType,
-		Fn:         ServiceCount,
+		Typed:      extern.ServiceCount,
 		ArgTypes:   []types.Type{types.GraphIntType, types.GraphIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "services",
 		RetType:    types.BoolType,
-		Fn:         Services,
-		ArgTypes:   []types.Type{types.GraphStrType},
+		Typed:      extern.ServicesSet,
+		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "servicesinclude",
 		RetType:    types.BoolType,
-		Fn:         ServicesInclude,
-		ArgTypes:   []types.Type{types.GraphStrType},
+		Typed:      extern.ServicesIncludeSet,
+		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topiccount",
 		RetType:    types.BoolType,
-		Fn:         TopicCount,
+		Typed:      extern.TopicCount,
 		ArgTypes:   []types.Type{types.GraphIntType, types.GraphIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicpublishercount",
 		RetType:    types.BoolType,
-		Fn:         TopicPublisherCount,
+		Typed:      extern.TopicPublisherCount,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphIntType, types.GraphIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicpublishers",
 		RetType:    types.BoolType,
-		Fn:         TopicPublishers,
+		Typed:      extern.TopicPublishersSet,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicpublishersinclude",
 		RetType:    types.BoolType,
-		Fn:         TopicPublishersInclude,
+		Typed:      extern.TopicPublishersIncludeSet,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topics",
 		RetType:    types.BoolType,
-		Fn:         Topics,
+		Typed:      extern.TopicsSet,
 		ArgTypes:   []types.Type{types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicsinclude",
 		RetType:    types.BoolType,
-		Fn:         TopicsInclude,
+		Typed:      extern.TopicsIncludeSet,
 		ArgTypes:   []types.Type{types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicsubscribercount",
 		RetType:    types.BoolType,
-		Fn:         TopicSubscriberCount,
+		Typed:      extern.TopicSubscriberCount,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphIntType, types.GraphIntType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicsubscribers",
 		RetType:    types.BoolType,
-		Fn:         TopicSubscribers,
+		Typed:      extern.TopicSubscribersSet,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	{
 		Name:       "topicsubscribersinclude",
 		RetType:    types.BoolType,
-		Fn:         TopicSubscribersInclude,
+		Typed:      extern.TopicSubscribersIncludeSet,
 		ArgTypes:   []types.Type{types.GraphStrType, types.GraphStrType},
 		IsVariadic: true,
 		IsAction:   false,
+		Cost:       CostMsg,
 	},
 	//External,
 	{
 		Name:       "signal",
 		RetType:    types.BoolType,
-		Fn:         Signal,
+		Typed:      extern.Signal,
 		ArgTypes:   []types.Type{types.ExternalStrType},
 		IsVariadic: false,
 		IsAction:   false,
@@ -579,12 +995,35 @@ var Builtins = []*Builtin{
 	{
 		Name:       "idsalert",
 		RetType:    types.BoolType,
-		Fn:         IdsAlert,
+		Typed:      extern.IdsAlert,
 		ArgTypes:   []types.Type{types.ExternalStrType},
 		IsVariadic: false,
 		IsAction:   false,
+		IsPure:     true,
+		Cost:       CostScan,
 	},
 	//non-message normal expressions
+	{Name: "enabled",
+		RetType:    types.BoolType,
+		Fn:         Enabled,
+		ArgTypes:   []types.Type{types.RuleType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "hour",
+		RetType:    types.IntType,
+		Typed:      extern.Hour,
+		ArgTypes:   []types.Type{types.TimeType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "weekday",
+		RetType:    types.IntType,
+		Typed:      extern.Weekday,
+		ArgTypes:   []types.Type{types.TimeType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
 	{Name: "levelname",
 		RetType:    types.StringType,
 		Fn:         LevelName,
@@ -599,4 +1038,204 @@ var Builtins = []*Builtin{
 		IsVariadic: false,
 		IsAction:   false,
 	},
+	{Name: "len",
+		RetType:    types.IntType,
+		Fn:         Len,
+		ArgTypes:   []types.Type{types.SetType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	//the first argument of haskey and delete is a map, see TypeCheck
+	{Name: "haskey",
+		RetType:    types.BoolType,
+		Typed:      extern.HasKey[any],
+		ArgTypes:   []types.Type{types.UnivType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "delete",
+		RetType:    types.BoolType,
+		Typed:      extern.Delete[any],
+		ArgTypes:   []types.Type{types.UnivType, types.StringType},
+		IsVariadic: false,
+		IsAction:   true,
+	},
+	{Name: "contains",
+		RetType:    types.BoolType,
+		Typed:      extern.Contains,
+		ArgTypes:   []types.Type{types.StringType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "hasprefix",
+		RetType:    types.BoolType,
+		Typed:      extern.HasPrefix,
+		ArgTypes:   []types.Type{types.StringType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "hassuffix",
+		RetType:    types.BoolType,
+		Typed:      extern.HasSuffix,
+		ArgTypes:   []types.Type{types.StringType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "lower",
+		RetType:    types.StringType,
+		Typed:      extern.Lower,
+		ArgTypes:   []types.Type{types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "upper",
+		RetType:    types.StringType,
+		Typed:      extern.Upper,
+		ArgTypes:   []types.Type{types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "substr",
+		RetType:    types.StringType,
+		Typed:      extern.Substr,
+		ArgTypes:   []types.Type{types.StringType, types.IntType, types.IntType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "replace",
+		RetType:    types.StringType,
+		Typed:      extern.Replace,
+		ArgTypes:   []types.Type{types.StringType, types.StringType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "splitcount",
+		RetType:    types.IntType,
+		Typed:      extern.SplitCount,
+		ArgTypes:   []types.Type{types.StringType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "format",
+		RetType:    types.StringType,
+		Fn:         Format,
+		ArgTypes:   []types.Type{types.StringType, types.UnivType},
+		IsVariadic: true,
+		IsAction:   false,
+	},
+	{Name: "float",
+		RetType:    types.FloatType,
+		Fn:         Float,
+		ArgTypes:   []types.Type{types.IntType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "int",
+		RetType:    types.IntType,
+		Fn:         Int,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "uint",
+		RetType:    types.UintType,
+		Fn:         Uint,
+		ArgTypes:   []types.Type{types.IntType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "abs",
+		RetType:    types.FloatType,
+		Fn:         Abs,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "min",
+		RetType:    types.FloatType,
+		Fn:         Min,
+		ArgTypes:   []types.Type{types.FloatType, types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "max",
+		RetType:    types.FloatType,
+		Fn:         Max,
+		ArgTypes:   []types.Type{types.FloatType, types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "sqrt",
+		RetType:    types.FloatType,
+		Typed:      extern.Sqrt,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "pow",
+		RetType:    types.FloatType,
+		Typed:      extern.Pow,
+		ArgTypes:   []types.Type{types.FloatType, types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "floor",
+		RetType:    types.FloatType,
+		Typed:      extern.Floor,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "ceil",
+		RetType:    types.FloatType,
+		Typed:      extern.Ceil,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "round",
+		RetType:    types.FloatType,
+		Typed:      extern.Round,
+		ArgTypes:   []types.Type{types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "hypot",
+		RetType:    types.FloatType,
+		Typed:      extern.Hypot,
+		ArgTypes:   []types.Type{types.FloatType, types.FloatType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	//sliding windows, see extern.Windows
+	{Name: "count",
+		RetType:    types.IntType,
+		Typed:      extern.Count,
+		ArgTypes:   []types.Type{types.StringType, types.DurationType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	{Name: "rate",
+		RetType:    types.MsgIntType,
+		Typed:      extern.Rate,
+		ArgTypes:   []types.Type{types.DurationType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	//the argument of ttl is a var set with setfor
+	{Name: "ttl",
+		RetType:    types.DurationType,
+		Fn:         TTL,
+		ArgTypes:   []types.Type{types.UnivType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
+	//countif is special, the second argument is the rule, see bindCountIfs
+	{Name: "countif",
+		RetType:    types.IntType,
+		Typed:      extern.CountIf,
+		ArgTypes:   []types.Type{types.DurationType, types.StringType},
+		IsVariadic: false,
+		IsAction:   false,
+	},
 }
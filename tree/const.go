@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"rips/rips/extern"
 	"rips/rips/lex"
 	"rips/rips/types"
 	"strings"
@@ -41,15 +42,26 @@ func setused(a *Action, s *Sym, vds map[string]*vardesc) {
 	case SFCall:
 		if s.Name == "set" {
 			v := expr.Args[0].Name
-			if vd, ok := vds[v]; ok {
+			vd, ok := vds[v]
+			switch {
+			case expr.Args[0].isIndex():
+				//another key may be set, it is not a dead store
+			case ok:
 				vd.set = true
-			} else {
+			default:
 				vds[v] = &vardesc{set: true, whereset: a}
 			}
 			for i := 0; i < len(expr.Args); i++ {
 				setused(a, expr.Args[i], vds)
 			}
 		}
+		if s.Name == "setfor" {
+			//the var is also restored when it expires,
+			//taken as used so no set before is deleted
+			for i := 0; i < len(expr.Args); i++ {
+				setused(a, expr.Args[i], vds)
+			}
+		}
 	case SBinary:
 		if s.Expr.ERight == nil {
 			return
@@ -64,6 +76,10 @@ func setused(a *Action, s *Sym, vds map[string]*vardesc) {
 			return
 		}
 		setused(a, s.Expr.ERight, vds)
+	case SSet:
+		for i := 0; i < len(expr.Args); i++ {
+			setused(a, expr.Args[i], vds)
+		}
 	case SVar:
 		v := s.Name
 		if vd, ok := vds[v]; ok {
@@ -78,9 +94,22 @@ func setused(a *Action, s *Sym, vds map[string]*vardesc) {
 
 func (p *Prog) Fold(errout io.Writer) (nerr int) {
 	fakeenv := (*StkEnv)(&[]Env{p.Env})
+	for _, f := range p.Funcs {
+		n := 0
+		n, f.Body = f.Body.Fold(fakeenv, errout)
+		nerr += n
+		if !p.Plain {
+			f.Body = f.Body.reorder()
+		}
+	}
 	for _, decl := range p.Decls {
 		n := 0
 		n, decl.RVal = decl.RVal.Fold(fakeenv, errout)
+		if decl.LVal.DataType.TVal.IsMap() {
+			pos := decl.RVal.Pos
+			decl.RVal = NewMap(decl.LVal.DataType.TVal, decl.LVal.MaxKeys) //see Decl.TypeCheck
+			decl.RVal.Pos = pos
+		}
 		if !decl.RVal.IsConstant() {
 			decl.RVal.Errorf(errout, nerr, "%t is not a constant expression\n", (*USym)(decl.RVal))
 			nerr++
@@ -96,14 +125,32 @@ func (p *Prog) Fold(errout io.Writer) (nerr int) {
 	for _, rs := range p.RuleSects {
 		rules := rs.Rules
 		rs.Rules = nil
+		seen := make(map[string]*Rule)
 		for _, r := range rules {
 			n := 0
+			p.warnDup(seen, r)
+			wasconst := r.Expr.IsConstant()
+			cond := p.span(r.Start, r.End, r.Expr)
 			n, r.Expr = r.Expr.Fold(fakeenv, errout)
 			nerr += n
+			if n == 0 && !wasconst && r.Expr.IsConstant() {
+				if r.Expr.IsTrue() {
+					p.warnf(r.Start, r.End, "condition %s is always true", cond)
+				} else {
+					p.warnf(r.Start, r.End, "condition %s is always false, the rule is dropped", cond)
+				}
+			}
 			//delete dead code
 			if n == 0 && !r.Expr.IsTrue() {
 				continue
 			}
+			if !p.Plain {
+				folded := fmt.Sprintf("%s", (*USym)(r.Expr))
+				r.Expr = r.Expr.reorder()
+				if fmt.Sprintf("%s", (*USym)(r.Expr)) != folded {
+					r.Reordered = folded
+				}
+			}
 			nd := true
 			for nd {
 				acts := r.Actions
@@ -125,7 +172,7 @@ func (p *Prog) Fold(errout io.Writer) (nerr int) {
 					// semantically equivalent and equally readable
 
 					setused(a, a.What, vds)
-					if a.What.Name == "set" {
+					if a.What.Name == "set" && !a.What.Expr.Args[0].isIndex() {
 						v := a.What.Expr.Args[0].Name
 						vd, ok := vds[v]
 						if ok && DFold {
@@ -206,7 +253,7 @@ func (s *Sym) FoldFloat(e1 *Sym, e2 *Sym, val float64) (news *Sym) {
 
 func (s *Sym) FoldBinary(envs *StkEnv, errout io.Writer) (news *Sym) {
 	news = s
-	isint := s.DataType.TVal == types.TypeVals[types.TVInt]
+	isint := isIntVal(s.DataType.TVal)
 	isfloat := s.DataType.TVal == types.TypeVals[types.TVFloat]
 	if s.Expr.ELeft.IsConstant() && s.Expr.ERight.IsConstant() {
 		if DFold {
@@ -239,7 +286,9 @@ func (s *Sym) FoldBinary(envs *StkEnv, errout io.Writer) (news *Sym) {
 		return news
 	}
 	if s.Expr.Op == '|' {
-		news = s.FoldInt(s.Expr.ELeft, s.Expr.ERight, 0)
+		if isint {
+			news = s.FoldInt(s.Expr.ELeft, s.Expr.ERight, 0)
+		}
 		return news
 	}
 	if s.Expr.Op == '-' {
@@ -251,7 +300,9 @@ func (s *Sym) FoldBinary(envs *StkEnv, errout io.Writer) (news *Sym) {
 		return news
 	}
 	if s.Expr.Op == '&' {
-		news = s.FoldInt(s.Expr.ELeft, s.Expr.ERight, -1)
+		if isint {
+			news = s.FoldInt(s.Expr.ELeft, s.Expr.ERight, -1)
+		}
 		return news
 	}
 	if s.Expr.ERight.SType == SVar {
@@ -269,13 +320,13 @@ func (s *Sym) FoldBinary(envs *StkEnv, errout io.Writer) (news *Sym) {
 }
 
 func (s *Sym) iszerodiv() bool {
-	if s.Expr.Op == '/' {
+	if s.Expr.Op == '/' || s.Expr.Op == '%' {
 		er := s.Expr.ERight
 		if !er.IsConstant() {
 			return false
 		}
-		isint := s.DataType.TVal == types.TypeVals[types.TVInt]
-		isfloat := s.DataType.TVal == types.TypeVals[types.TVFloat]
+		isint := isIntVal(er.DataType.TVal)
+		isfloat := er.DataType.TVal == types.TypeVals[types.TVFloat]
 		if isint && er.IntVal == 0 {
 			return true
 		}
@@ -286,11 +337,63 @@ func (s *Sym) iszerodiv() bool {
 	return false
 }
 
+func (s *Sym) isnegshift() bool {
+	op := lex.TokType(s.Expr.Op)
+	if op != lex.TokLShift && op != lex.TokRShift {
+		return false
+	}
+	er := s.Expr.ERight
+	isint := er.DataType.TVal == types.TypeVals[types.TVInt]
+	return er.IsConstant() && isint && er.IntVal < 0
+}
+
+// NaN or Inf, an error when evaluated, see extern.CheckFloat
+func (s *Sym) isBadFloat() bool {
+	isfloat := s.DataType.TVal == types.TypeVals[types.TVFloat]
+	return s.IsConstant() && isfloat && extern.IsBadFloat(s.FloatVal)
+}
+
+// int or uint of a constant float which does not fit, see checkConv
+func (s *Sym) isBadConv() bool {
+	if !s.isFloatConv(s.Expr.Args) {
+		return false
+	}
+	if s.Name == "int" {
+		return !extern.FitsInt(s.Expr.Args[0].FloatVal)
+	}
+	return !extern.FitsUint(s.Expr.Args[0].FloatVal)
+}
+
+func argsConstant(args []*Sym) bool {
+	for _, a := range args {
+		if !a.IsConstant() {
+			return false
+		}
+	}
+	return true
+}
+
+// the constant strings of a variadic set function are built
+// into a set once, nfixed are the arguments before them
+func foldSetArgs(args []*Sym, nfixed int) (nargs []*Sym) {
+	if len(args) < nfixed {
+		return args
+	}
+	for _, a := range args[nfixed:] {
+		if a.SType != SConst || a.DataType.TVal != types.TypeVals[types.TVString] {
+			return args
+		}
+	}
+	set := NewSet(extern.NewStrSet(VarArgs(args[nfixed:]...)...))
+	nargs = append(args[:nfixed:nfixed], set)
+	return nargs
+}
+
 func (s *Sym) Fold(envs *StkEnv, errout io.Writer) (nerr int, news *Sym) {
 	news = s
 	expr := s.Expr
 	switch s.SType {
-	case SRegexp, SYara, SFunc, SConst, SLevel, SVar, SSect:
+	case SRegexp, SYara, SFunc, SConst, SLevel, SVar, SSect, SRule:
 	case SFCall:
 		if expr == nil {
 			return 1, news
@@ -302,6 +405,7 @@ func (s *Sym) Fold(envs *StkEnv, errout io.Writer) (nerr int, news *Sym) {
 		}
 		//dead code for variadic set functions.
 		isinclude := strings.HasSuffix(s.Name, "in") || strings.HasSuffix(s.Name, "include")
+		isinclude = isinclude && !expr.FCall.isUserFunc()
 		if s.Name != "plugin" && isinclude && len(expr.Args) == 0 {
 			return 0, NewBool(true)
 		}
@@ -346,9 +450,48 @@ func (s *Sym) Fold(envs *StkEnv, errout io.Writer) (nerr int, news *Sym) {
 			n, expr.Args[i] = expr.Args[i].Fold(envs, errout)
 			nerr += n
 		}
+		if _, ismsgf := msgFieldBuiltins[s.Name]; ismsgf && len(expr.Args) != 0 {
+			a0 := expr.Args[0]
+			if a0.SType == SConst {
+				if err := extern.IsFieldPath(a0.StrVal); err != nil {
+					s.Errorf(errout, nerr, "%t is not a valid msg field path: %s\n",
+						(*USym)(a0), err)
+					nerr++
+				}
+			}
+		}
+		if i, iswin := windowArgs[s.Name]; iswin && i < len(expr.Args) {
+			if a := expr.Args[i]; a.IsConstant() && a.IntVal <= 0 {
+				s.Errorf(errout, nerr, "%t: window %s is not positive\n", (*USym)(s), (*USym)(a))
+				nerr++
+			}
+		}
+		if expr.FCall != nil && expr.FCall.isSetFunc() {
+			expr.Args = foldSetArgs(expr.Args, len(expr.FCall.ArgDataTypes)-1)
+		}
+		if f := expr.FCall; f.isUserFunc() && f.Body.IsConstant() && argsConstant(expr.Args) {
+			news = f.Body
+		}
 		if len(expr.Args) == 0 {
 			return nerr, news
 		}
+		if constBuiltins[s.Name] && argsConstant(expr.Args) {
+			if DFold {
+				fmt.Fprintf(os.Stderr, "Fold: %s\n", s)
+			}
+			if s.isBadConv() {
+				s.Errorf(errout, nerr, "%t: %v does not fit in %s\n", (*USym)(s), expr.Args[0].FloatVal, s.Name)
+				nerr++
+			}
+			news = expr.FCall.Fn(nil, expr.Args...)
+			if news.isBadFloat() {
+				s.Errorf(errout, nerr, "%t result is %v\n", (*USym)(s), news.FloatVal)
+				nerr++
+			}
+		}
+		if s.Name == "len" && expr.Args[0].IsConstant() {
+			news = Len(nil, expr.Args[0])
+		}
 		if s.Name == "string" && expr.Args[0].IsConstant() {
 			if DFold {
 				fmt.Fprintf(os.Stderr, "Fold: %s\n", s)
@@ -379,6 +522,12 @@ func (s *Sym) Fold(envs *StkEnv, errout io.Writer) (nerr int, news *Sym) {
 			if s.iszerodiv() {
 				s.Errorf(errout, nerr, "division by zero %s\n", (*USym)(s))
 				nerr++
+			} else if s.isnegshift() {
+				s.Errorf(errout, nerr, "negative shift count %s\n", (*USym)(s))
+				nerr++
+			} else if news.isBadFloat() {
+				s.Errorf(errout, nerr, "%t result is %v\n", (*USym)(s), news.FloatVal)
+				nerr++
 			}
 		}
 	case SUnary:
@@ -397,6 +546,17 @@ func (s *Sym) Fold(envs *StkEnv, errout io.Writer) (nerr int, news *Sym) {
 			}
 			news = s.EvalExpr(envs, nil)
 		}
+	case SSet:
+		n := 0
+		isconst := true
+		for i := 0; i < len(expr.Args); i++ {
+			n, expr.Args[i] = expr.Args[i].Fold(envs, errout)
+			nerr += n
+			isconst = isconst && expr.Args[i].IsConstant()
+		}
+		if isconst {
+			news = NewSet(extern.NewStrSet(VarArgs(expr.Args...)...))
+		}
 	case SNone:
 		nerr++
 	default:
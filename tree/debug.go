@@ -24,6 +24,10 @@ func (prog *Prog) String() (s string) {
 	for _, dec := range prog.Decls {
 		s += fmt.Sprintf("\t%s", dec)
 	}
+	s += "Functions:\n"
+	for _, f := range prog.Funcs {
+		s += fmt.Sprintf("\t%s(%s) = %s\n", f.Name, f.Params, (*USym)(f.Body))
+	}
 	s += fmt.Sprintf("Rules:\n")
 	for _, rs := range prog.RuleSects {
 		s += fmt.Sprintf("%s", rs)
@@ -39,14 +43,27 @@ func (decl *Decl) String() (s string) {
 	return s
 }
 func (ruledecl *RuleSect) String() (s string) {
-	s += fmt.Sprintf("\tSection %s:\n", ruledecl.SectId)
+	s += fmt.Sprintf("\tSection %s", ruledecl.SectId)
+	if ruledecl.IsFirst {
+		s += " first"
+	}
+	s += ":\n"
 	for _, r := range ruledecl.Rules {
 		s += fmt.Sprintf("\t%s", r)
 	}
+	if ruledecl.Index != nil {
+		s += ruledecl.indexString()
+	}
 	return s
 }
 func (rule *Rule) String() (s string) {
+	if rule.Label != nil {
+		s += fmt.Sprintf("\t\trule %s:\n", rule.Label.Name)
+	}
 	s += fmt.Sprintf("\t\t%s?\n", (*USym)(rule.Expr))
+	if rule.Reordered != "" {
+		s += fmt.Sprintf("\t\t\t#reordered, was %s\n", rule.Reordered)
+	}
 	s += "\t\t\t\t\t"
 	for _, a := range rule.Actions {
 		s += fmt.Sprintf("%s", a)
@@ -0,0 +1,143 @@
+package tree
+
+import (
+	"fmt"
+	"rips/rips/extern"
+	"rips/rips/types"
+	"sort"
+)
+
+// Most rules of a Msg section start with topicin("/x") and are
+// false for the messages of other topics. The index of the section
+// has, for each topic in a topicin guard, the rules which may be
+// true for it, and the rest (rules without a guard or only with
+// topicmatches) for the other topics, both in the order of the
+// section. Only those rules are evaluated for a message.
+type TopicIndex struct {
+	Topics   map[string][]*Rule //the rules guarded by the topic and the residual ones
+	Residual []*Rule            //the rules without a topicin guard
+}
+
+// the topics of a topicin with constant args, nil if s is not one
+func (s *Sym) topicGuard() []string {
+	if s.SType != SFCall || s.Name != "topicin" || s.Expr.FCall.isUserFunc() {
+		return nil
+	}
+	for _, a := range s.Expr.Args {
+		if !a.IsConstant() {
+			return nil
+		}
+	}
+	topics := []string{}
+	for t := range SetArgs(s.Expr.Args...) {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// topicGuard is the topics for which the condition of r may be
+// true, the topicin of its conjunction, ok is false if there is
+// none. The ones after an operand which is not safe (see isSafe)
+// are not guards, skipping the rule would skip its side effects.
+func (r *Rule) topicGuard() (topics []string, ok bool) {
+	for _, c := range r.Expr.conjuncts() {
+		if ts := c.topicGuard(); ts != nil {
+			topics = intersect(topics, ts, ok)
+			ok = true
+			continue
+		}
+		if !c.isSafe() {
+			break
+		}
+	}
+	return topics, ok
+}
+
+// the topics in ts and in topics, if there were some
+func intersect(topics []string, ts []string, isset bool) []string {
+	if !isset {
+		return ts
+	}
+	in := map[string]bool{}
+	for _, t := range ts {
+		in[t] = true
+	}
+	both := []string{}
+	for _, t := range topics {
+		if in[t] {
+			both = append(both, t)
+		}
+	}
+	return both
+}
+
+// IndexTopics builds the TopicIndex of the Msg sections with a
+// guarded rule, the rest (all of them if Plain) are left with a nil Index
+func (p *Prog) IndexTopics() {
+	for _, rs := range p.RuleSects {
+		rs.Index = nil
+		if p.Plain || rs.SectId.DataType.TExpr != types.TypeExprs[types.TEMsg] {
+			continue
+		}
+		idx := &TopicIndex{Topics: map[string][]*Rule{}}
+		guards := make([][]string, len(rs.Rules))
+		isguarded := make([]bool, len(rs.Rules))
+		for i, r := range rs.Rules {
+			guards[i], isguarded[i] = r.topicGuard()
+			for _, t := range guards[i] {
+				idx.Topics[t] = nil
+			}
+		}
+		if len(idx.Topics) == 0 {
+			continue
+		}
+		for i, r := range rs.Rules {
+			if !isguarded[i] {
+				idx.Residual = append(idx.Residual, r)
+				for t := range idx.Topics {
+					idx.Topics[t] = append(idx.Topics[t], r)
+				}
+				continue
+			}
+			for _, t := range guards[i] {
+				idx.Topics[t] = append(idx.Topics[t], r)
+			}
+		}
+		rs.Index = idx
+	}
+}
+
+// the rules of rs to evaluate for the current message
+func (rs *RuleSect) candidates(context *extern.Ctx) []*Rule {
+	if rs.Index == nil || context.CurrentMsg == nil {
+		return rs.Rules
+	}
+	if rules, ok := rs.Index.Topics[context.CurrentMsg.Topic()]; ok {
+		return rules
+	}
+	return rs.Index.Residual
+}
+
+// the index, with the positions of the rules in rs
+func (rs *RuleSect) indexString() (s string) {
+	pos := map[*Rule]int{}
+	for i, r := range rs.Rules {
+		pos[r] = i
+	}
+	list := func(rules []*Rule) (l string) {
+		for _, r := range rules {
+			l += fmt.Sprintf(" %d", pos[r])
+		}
+		return l
+	}
+	topics := []string{}
+	for t := range rs.Index.Topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	for _, t := range topics {
+		s += fmt.Sprintf("\t\ttopic %s: rules%s\n", t, list(rs.Index.Topics[t]))
+	}
+	return s + fmt.Sprintf("\t\tother topics: rules%s\n", list(rs.Index.Residual))
+}
@@ -15,12 +15,12 @@ const (
 )
 
 func (envs *StkEnv) dprintf(s string, v ...interface{}) {
-	lvl := len(*envs)
-	indent := strings.Repeat("\t", lvl)
-	if DEval {
-		prefix := fmt.Sprintf("%sEVAL: ", indent)
-		fmt.Fprintf(os.Stderr, prefix+s, v...)
+	if !DEval {
+		return
 	}
+	indent := strings.Repeat("\t", len(*envs))
+	prefix := fmt.Sprintf("%sEVAL: ", indent)
+	fmt.Fprintf(os.Stderr, prefix+s, v...)
 }
 
 func (s *Sym) SetVal(s2 *Sym) {
@@ -28,6 +28,31 @@ func (s *Sym) SetVal(s2 *Sym) {
 	s.DataType = s2.DataType
 }
 
+// the value of the var s is a copy of s2, set does not keep
+// s2, the VM reuses it. The copy is in the own value of the var,
+// Val may be shared (a level, the declared value...)
+func (s *Sym) copyVal(s2 *Sym) {
+	if s.ownVal == nil {
+		s.ownVal = new(Sym)
+	}
+	*s.ownVal = *s2
+	s.Val = s.ownVal
+	s.DataType = s2.DataType
+}
+
+// the ops do not allocate their errors, they are evaluated
+// for every rule (and ignored, see OpVal)
+var (
+	errBadOp      = errors.New("bad op")
+	errIntOp      = errors.New("undef int op")
+	errUintOp     = errors.New("undef uint op")
+	errFloatOp    = errors.New("undef float op")
+	errBoolOp     = errors.New("undef bool op")
+	errUnaryStrOp = errors.New("undef unary str op")
+	errStrOp      = errors.New("undef str op")
+	errSetOp      = errors.New("undef set op")
+)
+
 func isRuntime(s string) bool {
 	return strings.Contains(s, "runtime error:")
 }
@@ -59,24 +84,39 @@ func (s *Sym) OpVal(s2 *Sym, op int) (err error) {
 		s.DataType.TVal = types.TypeVals[types.TVUndef]
 	}
 	switch s.DataType.TVal {
-	case types.TypeVals[types.TVInt]:
+	case types.TypeVals[types.TVInt], types.TypeVals[types.TVDuration], types.TypeVals[types.TVTime]:
 		s.IntOp(s2, op)
+	case types.TypeVals[types.TVUint]:
+		s.UintOp(s2, op)
 	case types.TypeVals[types.TVFloat]:
 		s.FloatOp(s2, op)
 	case types.TypeVals[types.TVString]:
 		s.StrOp(s2, op)
 	case types.TypeVals[types.TVBool]:
 		s.BoolOp(s2, op)
+	case types.TypeVals[types.TVSet]:
+		s.SetOp(s2, op)
 	case types.TypeVals[types.TVUndef]:
 		return nil
 	default:
-		return errors.New("bad op")
+		return errBadOp
 	}
 	return nil
 }
 
+// durations and times are kept in IntVal, in nanoseconds,
+// uints are kept in IntVal too, as the bits of the uint64
+func isIntVal(tvp *types.TypeVal) bool {
+	switch tvp {
+	case types.TypeVals[types.TVInt], types.TypeVals[types.TVDuration], types.TypeVals[types.TVTime],
+		types.TypeVals[types.TVUint]:
+		return true
+	}
+	return false
+}
+
 type CompType interface {
-	int64 | float64 | ~string
+	int64 | uint64 | float64 | ~string
 }
 
 func CompOp[T CompType](v1 T, v2 T, op int) (res bool, err error) {
@@ -99,11 +139,11 @@ func CompOp[T CompType](v1 T, v2 T, op int) (res bool, err error) {
 	return false, err
 }
 
-/*
-	***WARNING. Do not add >> or << operator
-		without adding an unsigned
-		integer type.
-*/
+// The count of a shift may be an int or a uint (see types.mixedOps),
+// a negative int count panics and OpVal makes it an error.
+func isUintCount(s2 *Sym) bool {
+	return s2.DataType.TVal == types.TypeVals[types.TVUint]
+}
 
 func (s *Sym) IntOp(s2 *Sym, op int) (err error) {
 	v := int64(0)
@@ -135,9 +175,71 @@ func (s *Sym) IntOp(s2 *Sym, op int) (err error) {
 		s.IntVal &= v
 	case '|':
 		s.IntVal |= v
+	case int(lex.TokLShift):
+		if isUintCount(s2) {
+			s.IntVal <<= uint64(v)
+		} else {
+			s.IntVal <<= v
+		}
+	case int(lex.TokRShift):
+		if isUintCount(s2) {
+			s.IntVal >>= uint64(v)
+		} else {
+			s.IntVal >>= v
+		}
+	default:
+		return errIntOp
+	}
+	return nil
+}
+
+func (s *Sym) UintOp(s2 *Sym, op int) (err error) {
+	u := uint64(s.IntVal)
+	v := uint64(0)
+	if s2 == nil {
+		v = u
+		u = 0 //hack for unary op
+	} else {
+		v = uint64(s2.IntVal)
+	}
+	if isbool, _ := isCompOp[lex.TokType(op)]; isbool {
+		s.BoolVal, err = CompOp(u, v, op)
+	}
+	switch op {
+	case '^':
+		u ^= v
+	case '~':
+		u = ^v
+	case '+':
+		u += v
+	case '-':
+		u -= v
+	case '*':
+		u *= v
+	case '/':
+		u /= v
+	case '%':
+		u %= v
+	case '&':
+		u &= v
+	case '|':
+		u |= v
+	case int(lex.TokLShift):
+		if isUintCount(s2) {
+			u <<= v
+		} else {
+			u <<= s2.IntVal
+		}
+	case int(lex.TokRShift):
+		if isUintCount(s2) {
+			u >>= v
+		} else {
+			u >>= s2.IntVal
+		}
 	default:
-		return errors.New("undef int op")
+		return errUintOp
 	}
+	s.IntVal = int64(u)
 	return nil
 }
 
@@ -162,8 +264,10 @@ func (s *Sym) FloatOp(s2 *Sym, op int) (err error) {
 		s.FloatVal *= v
 	case '/':
 		s.FloatVal /= v
+	case '%':
+		s.FloatVal = extern.FMod(s.FloatVal, v)
 	default:
-		return errors.New("undef float op")
+		return errFloatOp
 	}
 	return nil
 }
@@ -192,7 +296,7 @@ func (s *Sym) BoolOp(s2 *Sym, op int) (err error) {
 	case lex.TokLogOr:
 		s.BoolVal = s.BoolVal || s2.BoolVal
 	default:
-		return errors.New("undef bool op")
+		return errBoolOp
 	}
 	return nil
 }
@@ -203,10 +307,14 @@ const (
 
 // take great care, s2 can be nil for unary
 func (s *Sym) StrOp(s2 *Sym, op int) (err error) {
+	if lex.TokType(op) == lex.TokIn {
+		s.BoolVal = s2.StrSetVal[s.StrVal]
+		return nil
+	}
 	switch op {
 	case '+':
 		if s2 == nil {
-			return errors.New("undef unary str op")
+			return errUnaryStrOp
 		}
 		s.StrVal += s2.StrVal
 		r := []rune(s.StrVal)
@@ -219,7 +327,24 @@ func (s *Sym) StrOp(s2 *Sym, op int) (err error) {
 		s.BoolVal, err = CompOp(s.StrVal, s2.StrVal, op)
 		return err
 	}
-	return errors.New("undef str op")
+	return errStrOp
+}
+
+// sets are never modified, union and intersection make a new one
+func (s *Sym) SetOp(s2 *Sym, op int) (err error) {
+	switch lex.TokType(op) {
+	case lex.TokBitOr:
+		s.StrSetVal = extern.SetUnion(s.StrSetVal, s2.StrSetVal)
+	case lex.TokBitAnd:
+		s.StrSetVal = extern.SetInter(s.StrSetVal, s2.StrSetVal)
+	case lex.TokEq:
+		s.BoolVal = extern.SetEq(s.StrSetVal, s2.StrSetVal)
+	case lex.TokNEq:
+		s.BoolVal = !extern.SetEq(s.StrSetVal, s2.StrSetVal)
+	default:
+		return errSetOp
+	}
+	return nil
 }
 
 func (s *Sym) BinExpr(s2 *Sym, op int) (err error) {
@@ -228,7 +353,11 @@ func (s *Sym) BinExpr(s2 *Sym, op int) (err error) {
 		err = errors.New(errs)
 		s.DataType.TVal = types.TypeVals[types.TVUndef]
 	} else {
+		tvp, ismixed := s.DataType.MixedOp(s2.DataType, op)
 		err = s.OpVal(s2, op)
+		if ismixed {
+			s.DataType.TVal = tvp
+		}
 	}
 	return err
 }
@@ -251,20 +380,99 @@ func (s *Sym) CopyValFrom(s2 *Sym) {
 	} else {
 		s.DataType = s2.DataType
 	}
+	if s.DataType.TVal.IsMap() {
+		s.MapVal = s2.MapVal //a reference, like in go
+		return
+	}
 	switch s.DataType.TVal {
 	case types.TypeVals[types.TVBool]:
 		s.BoolVal = s2.BoolVal
-	case types.TypeVals[types.TVInt]:
+	case types.TypeVals[types.TVInt], types.TypeVals[types.TVDuration], types.TypeVals[types.TVTime],
+		types.TypeVals[types.TVUint]:
 		s.IntVal = s2.IntVal
 	case types.TypeVals[types.TVFloat]:
 		s.FloatVal = s2.FloatVal
 	case types.TypeVals[types.TVString]:
 		s.StrVal = s2.StrVal
+	case types.TypeVals[types.TVSet]:
+		s.StrSetVal = s2.StrSetVal
+	case types.TypeVals[types.TVUndef]:
+		//nothing to copy, undef propagates
 	default:
 		fmt.Fprintf(os.Stderr, "Copying unknown type %s to %s\n", s2, s)
 	}
 }
 
+// m[key]
+func (s *Sym) isIndex() bool {
+	return s.SType == SBinary && lex.TokType(s.Expr.Op) == lex.TokLBrack
+}
+
+// The elements of the maps are kept as go values,
+// like in the generated code, IntVal for int, duration and time.
+func mapVal(s *Sym) any {
+	switch s.DataType.TVal {
+	case types.TypeVals[types.TVFloat]:
+		return s.FloatVal
+	case types.TypeVals[types.TVBool]:
+		return s.BoolVal
+	case types.TypeVals[types.TVString]:
+		return s.StrVal
+	}
+	return s.IntVal
+}
+
+// a missing element (nil) is the zero value
+func symFromMapVal(v any, tvp *types.TypeVal) (s *Sym) {
+	s = NewAnonSym(SConst)
+	s.fromMapVal(v, tvp)
+	return s
+}
+
+func (s *Sym) fromMapVal(v any, tvp *types.TypeVal) {
+	s.DataType = types.Type{TVal: tvp, TExpr: types.TypeExprs[types.TEExpr]}
+	switch x := v.(type) {
+	case int64:
+		s.IntVal = x
+	case float64:
+		s.FloatVal = x
+	case bool:
+		s.BoolVal = x
+	case string:
+		s.StrVal = x
+	}
+}
+
+func (s *Sym) evalIndex(envs *StkEnv, context *extern.Ctx) (val *Sym) {
+	m := s.Expr.ELeft.EvalExpr(envs, context)
+	if m.MapVal == nil {
+		//not a map (type error)
+		m.DataType.TVal = types.TypeVals[types.TVUndef]
+		return m
+	}
+	key := s.Expr.ERight.EvalExpr(envs, context)
+	if key.DataType.IsTypeUndef() {
+		return key
+	}
+	return symFromMapVal(m.MapVal.Get(key.StrVal), s.DataType.TVal)
+}
+
+// set(m[key], v), undef if key or v are
+func (s *Sym) setIndex(envs *StkEnv, context *extern.Ctx) (val *Sym) {
+	index := s.Expr.Args[0]
+	m := index.Expr.ELeft.EvalExpr(envs, context)
+	key := index.Expr.ERight.EvalExpr(envs, context)
+	if key.DataType.IsTypeUndef() {
+		return key
+	}
+	v := s.Expr.Args[1].EvalExpr(envs, context)
+	if v.DataType.IsTypeUndef() {
+		return v
+	}
+	m.MapVal.Set(key.StrVal, mapVal(v))
+	return NewBool(true)
+}
+
 func (s *Sym) BoolExpr(op int) (err error) {
 	s.DataType.TVal = types.TypeVals[types.TVBool]
 	return nil
@@ -292,12 +500,26 @@ func (s *Sym) EvalExpr(envs *StkEnv, context *extern.Ctx) (val *Sym) {
 			break
 		}
 		args := make([]*Sym, len(s.Expr.Args))
+		isundef := false
+		if s.Name == "set" && s.Expr.Args[0].isIndex() {
+			val = s.setIndex(envs, context)
+			break
+		}
 		for i, p := range s.Expr.Args {
-			if s.Name == "set" && i == 0 {
+			if (s.Name == "set" || s.Name == "setfor" || s.Name == "ttl") && i == 0 {
 				args[i] = envs.GetSym(p.Name)
 				continue //first arg to set is an LValue, do not evaluate
 			}
 			args[i] = p.EvalExpr(envs, context)
+			if args[i].DataType.IsTypeUndef() {
+				//the rest are not evaluated, like the generated code
+				isundef = true
+				break
+			}
+		}
+		if isundef {
+			//undef (i.e. missing msg field) is not passed, the call is undef
+			break
 		}
 		if s.Name == "trigger" {
 			//prepend the symbol with the current level
@@ -307,32 +529,69 @@ func (s *Sym) EvalExpr(envs *StkEnv, context *extern.Ctx) (val *Sym) {
 			}
 			args = append([]*Sym{level}, args...)
 		}
-		val = fn.Fn(context, args...)
+		if fn.isUserFunc() {
+			val = fn.CallFunc(envs, context, args...)
+			break
+		}
+		checkConv(context, s, args)
+		val = fn.call(context, args)
+		if mathBuiltins[s.Name] && context != nil {
+			extern.CheckFloat(context, s.Pos.String(), val.FloatVal)
+		}
 	case SBinary:
 		envs.dprintf("SBinary\n")
+		if s.isIndex() {
+			val = s.evalIndex(envs, context)
+			break
+		}
 		left := s.Expr.ELeft.EvalExpr(envs, context)
 		val.CopyValFrom(left)
-		isshort := s.IsOrShort(val) || s.IsAndShort(val)
+		//undef does not evaluate the rest, like the generated code
+		isundef := val.DataType.IsTypeUndef()
+		isshort := isundef || s.IsOrShort(val) || s.IsAndShort(val)
 		if !isshort {
 			right := s.Expr.ERight.EvalExpr(envs, context)
+			if right.DataType.IsTypeUndef() {
+				val.DataType = right.DataType
+				break
+			}
 			err := val.BinExpr(right, s.Expr.Op)
 			if err != nil && context != nil {
 				context.Printf("%s:%d error evaluating, undefined behaviour: %s\n", s.Pos.File, s.Pos.Line, err)
 				context.Fatal()
 			}
+			if s.isFloatArith() && context != nil {
+				extern.CheckFloat(context, s.Pos.String(), val.FloatVal)
+			}
 		}
-		if isbool, _ := isCompOp[lex.TokType(s.Expr.Op)]; isbool {
+		isbool, _ := isCompOp[lex.TokType(s.Expr.Op)]
+		if isbool && !val.DataType.IsTypeUndef() {
 			val.BoolExpr(s.Expr.Op)
 		}
 	case SUnary:
 		envs.dprintf("SUnary\n")
 		right := s.Expr.ERight.EvalExpr(envs, context)
 		val.CopyValFrom(right)
+		if val.DataType.IsTypeUndef() {
+			break
+		}
 		val.UnaryExpr(s.Expr.Op)
 		if isbool, _ := isCompOp[lex.TokType(s.Expr.Op)]; isbool {
 			val.BoolExpr(s.Expr.Op)
 		}
-	case SLevel:
+	case SSet:
+		envs.dprintf("SSet\n")
+		val.DataType = types.SetType
+		val.StrSetVal = make(extern.StrSet, len(s.Expr.Args))
+		for _, a := range s.Expr.Args {
+			elem := a.EvalExpr(envs, context)
+			if elem.DataType.IsTypeUndef() {
+				val.DataType = elem.DataType
+				break
+			}
+			val.StrSetVal[elem.StrVal] = true
+		}
+	case SLevel, SRule:
 		return s
 	case SYara, SRegexp, SNone:
 		return s
@@ -344,6 +603,26 @@ func (s *Sym) EvalExpr(envs *StkEnv, context *extern.Ctx) (val *Sym) {
 	return val
 }
 
+// The params are vars in a new env, the body is evaluated there
+func (f *Sym) CallFunc(envs *StkEnv, context *extern.Ctx, args ...*Sym) (val *Sym) {
+	envs.PushEnv()
+	defer envs.PopEnv()
+	for i, p := range f.Params {
+		param, err := envs.NewSym(p.Name, SVar)
+		if err != nil {
+			panic(err)
+		}
+		param.SetVal(args[i])
+	}
+	return f.Body.EvalExpr(envs, context)
+}
+
+// NaN and Inf can only come from float arithmetic (and math builtins)
+func (s *Sym) isFloatArith() bool {
+	isfloat := s.DataType.TVal == types.TypeVals[types.TVFloat]
+	return isfloat && strings.ContainsRune("+-*/%", rune(s.Expr.Op))
+}
+
 var isCompOp = map[lex.TokType]bool{
 	lex.TokG:   true,
 	lex.TokL:   true,
@@ -351,4 +630,5 @@ var isCompOp = map[lex.TokType]bool{
 	lex.TokNEq: true,
 	lex.TokGEq: true,
 	lex.TokLEq: true,
+	lex.TokIn:  true,
 }
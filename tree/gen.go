@@ -2,12 +2,35 @@ package tree
 
 import (
 	"fmt"
+	"rips/rips/extern"
 	"rips/rips/lex"
 	"rips/rips/types"
+	"sort"
+	"strings"
 )
 
 const varPrefix = "rul_rips_user_var_"
 const levelPrefix = "rul_rips_user_level_"
+const setPrefix = "rul_rips_user_set_"
+const funcPrefix = "rul_rips_user_func_"
+
+var goTypes = map[*types.TypeVal]string{
+	types.TypeVals[types.TVInt]:      "int64",
+	types.TypeVals[types.TVFloat]:    "float64",
+	types.TypeVals[types.TVBool]:     "bool",
+	types.TypeVals[types.TVString]:   "string",
+	types.TypeVals[types.TVSet]:      "extern.StrSet",
+	types.TypeVals[types.TVDuration]: "int64",
+	types.TypeVals[types.TVTime]:     "int64",
+	types.TypeVals[types.TVUint]:     "uint64",
+	//the elements as in the interpreter, see mapVal
+	types.TypeVals[types.TVMapInt]:      "*extern.Map[int64]",
+	types.TypeVals[types.TVMapFloat]:    "*extern.Map[float64]",
+	types.TypeVals[types.TVMapBool]:     "*extern.Map[bool]",
+	types.TypeVals[types.TVMapString]:   "*extern.Map[string]",
+	types.TypeVals[types.TVMapDuration]: "*extern.Map[int64]",
+	types.TypeVals[types.TVMapTime]:     "*extern.Map[int64]",
+}
 
 func (prog *Prog) Format(f fmt.State, verb rune) {
 	str := ""
@@ -24,6 +47,10 @@ func (prog *Prog) Format(f fmt.State, verb rune) {
 
 func (prog *Prog) Gen() (s string) {
 	s += GoPrelude
+	s += "//Sets:\n"
+	for _, set := range prog.constSets() {
+		s += fmt.Sprintf("var %s%s = %s\n", setPrefix, set.Name, goSetLit(set.StrSetVal))
+	}
 	for _, v := range prog.Env {
 		switch v.SType {
 		case SVar:
@@ -52,39 +79,177 @@ func (prog *Prog) Gen() (s string) {
 	s += "var Uptime = int64(0)\n"
 	s += "var Time = int64(0)\n"
 	s += fmt.Sprintf("var CurrLevel = int64(%d)\n", prog.Levels[0].SLevel)
+	s += "//Timers:\n"
+	s += "var timers = []time.Duration{"
+	for i, period := range prog.Timers() {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("time.Duration(%d)", int64(period))
+	}
+	s += "}\n"
+	s += genTransitions(prog.Transitions)
+	s += "//Soft levels:\n"
+	s += "var afters = []time.Duration{"
+	for i, after := range prog.Afters() {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("time.Duration(%d)", int64(after))
+	}
+	s += "}\n"
+	s += "//Funcs:\n"
+	for _, f := range prog.Funcs {
+		s += f.genFunc()
+	}
+	s += prog.genRestoreExpired()
+	s += "//Topics:\n"
+	for n, rs := range prog.RuleSects {
+		s += rs.genIndex(n)
+	}
 
 	s += GoMiddle
 	s += fmt.Sprintf("levelNames = levelNames\n")
 	s += fmt.Sprintf("//Rules:\n")
 	i := 0
+	s += fmt.Sprintf("\tcontext.Stopped = false\n")
 	s += fmt.Sprintf("\ttm := \"External\"\n")
 	s += fmt.Sprintf("\tif context.CurrentMsg != nil {\n")
 	s += fmt.Sprintf("\t\ttm = context.CurrentMsg.Type()\n\t}\n")
-	for _, rs := range prog.RuleSects {
-		s2, j := rs.Gen(i)
+	s += fmt.Sprintf("\tif context.Timer != \"\" {\n")
+	s += fmt.Sprintf("\t\ttm = context.Timer\n\t}\n")
+	for n, rs := range prog.RuleSects {
+		s2, j := rs.Gen(n, i)
 		s += s2
 		i += j + 1
 	}
 	s += GoEpilogue
 	return s
 }
-func (ruledecl *RuleSect) Gen(i int) (s string, j int) {
+
+// nil if there is no transitions section, see extern.Trigger
+func genTransitions(trs extern.Transitions) (s string) {
+	s += "//Transitions:\n"
+	if trs == nil {
+		return s + "var transitions extern.Transitions\n"
+	}
+	var edges []string
+	for tr := range trs {
+		edges = append(edges, fmt.Sprintf("{From: %d, To: %d}: true", tr.From, tr.To))
+	}
+	sort.Strings(edges)
+	return s + fmt.Sprintf("var transitions = extern.Transitions{%s}\n", strings.Join(edges, ", "))
+}
+
+// the vars set with setfor get back their declared value, see restoreExpired
+func (prog *Prog) genRestoreExpired() (s string) {
+	var names []string
+	for _, v := range prog.Env {
+		if v.SType == SVar && !v.DataType.TVal.IsMap() {
+			names = append(names, v.Name)
+		}
+	}
+	sort.Strings(names)
+	s += "//Expiries:\n"
+	s += "func restoreExpired(context *extern.Ctx) {\n"
+	s += "\tfor _, name := range context.Expired() {\n"
+	s += "\t\tswitch name {\n"
+	for _, name := range names {
+		v := prog.Env[name]
+		s += fmt.Sprintf("\t\tcase %q:\n", name)
+		s += fmt.Sprintf("\t\t\t%g = %g\n", (*USym)(v), (*USym)(v.Val))
+	}
+	s += "\t\t}\n\t}\n}\n"
+	return s
+}
+
+// the undef values of the body unwind to the caller, see guardedGoString
+func (f *Sym) genFunc() (s string) {
+	s += fmt.Sprintf("func %s%s(context *extern.Ctx", funcPrefix, f.Name)
+	for _, p := range f.Params {
+		s += fmt.Sprintf(", %g %s", (*USym)(p), goTypes[p.DataType.TVal])
+	}
+	s += fmt.Sprintf(") %s {\n", goTypes[f.DataType.TVal])
+	s += fmt.Sprintf("\treturn %g\n}\n", (*USym)(f.Body))
+	return s
+}
+
+// the rules of the section n for each topic, see TopicIndex
+func (ruledecl *RuleSect) genIndex(n int) (s string) {
+	if ruledecl.Index == nil {
+		return ""
+	}
+	cands := func(rules []*Rule) string {
+		in := map[*Rule]bool{}
+		for _, r := range rules {
+			in[r] = true
+		}
+		l := "{"
+		for k, r := range ruledecl.Rules {
+			if k > 0 {
+				l += ", "
+			}
+			l += fmt.Sprintf("%v", in[r])
+		}
+		return l + "}"
+	}
+	s += fmt.Sprintf("var topicRules%d = map[string][]bool{\n", n)
+	topics := []string{}
+	for t := range ruledecl.Index.Topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	for _, t := range topics {
+		s += fmt.Sprintf("\t%q: %s,\n", t, cands(ruledecl.Index.Topics[t]))
+	}
+	s += "}\n"
+	s += fmt.Sprintf("var otherRules%d = []bool%s\n", n, cands(ruledecl.Index.Residual))
+	s += fmt.Sprintf("var candRules%d []bool\n", n)
+	return s
+}
+
+// n is the number of the section, i of the first label
+func (ruledecl *RuleSect) Gen(n int, i int) (s string, j int) {
 	s += fmt.Sprintf("//\tSection %s:\n", ruledecl.SectId)
 	stag := fmt.Sprintf("DoneSect%d", i)
 	s += fmt.Sprintf("\tif \"%s\" != tm {goto %s}\n", ruledecl.SectId.Name, stag)
+	if ruledecl.Index != nil {
+		s += fmt.Sprintf("\tcandRules%d = otherRules%d\n", n, n)
+		s += fmt.Sprintf("\tif c, ok := topicRules%d[context.CurrentMsg.Topic()]; ok {\n", n)
+		s += fmt.Sprintf("\t\tcandRules%d = c\n\t}\n", n)
+	}
 
 	i++
-	for _, r := range ruledecl.Rules {
-		s2 := r.Gen(i)
+	onmatch := ""
+	if ruledecl.IsFirst {
+		onmatch = fmt.Sprintf("\t\tgoto %s\n", stag)
+	}
+	for k, r := range ruledecl.Rules {
+		guard := ""
+		if ruledecl.Index != nil {
+			guard = fmt.Sprintf("candRules%d[%d]", n, k)
+		}
+		s2 := r.Gen(i, guard, onmatch)
 		s += s2
+		s += fmt.Sprintf("\tif context.Stopped {goto %s}\n", stag)
 		i++
 	}
 	s += fmt.Sprintf("\n%s:\n\n", stag)
 	i++
 	return s, i
 }
-func (rule *Rule) Gen(i int) (s string) {
-	s += fmt.Sprintf("\tif %g {\n", (*USym)(rule.Expr))
+
+// onmatch is run after the actions if the rule is activated, the
+// rule is only evaluated if guard (if any) is true
+func (rule *Rule) Gen(i int, guard string, onmatch string) (s string) {
+	cond := (*USym)(rule.Expr).guardedGoString()
+	if rule.Label != nil {
+		cond = fmt.Sprintf("extern.Enabled(context, %g) && %s", (*USym)(rule.Label), cond)
+	}
+	if guard != "" {
+		cond = guard + " && " + cond
+	}
+	s += fmt.Sprintf("\tif %s {\n", cond)
 	tt := "\t\t"
 	tt += "\t"
 	s += tt + "iscomma := true; iscomma = iscomma\n"
@@ -97,6 +262,7 @@ func (rule *Rule) Gen(i int) (s string) {
 		isfst = false
 	}
 	s += fmt.Sprintf("\nDone%d:\n\n", i)
+	s += onmatch
 	return s + "\t}\n"
 }
 func (action *Action) Gen(isfst bool, i int) (s string) {
@@ -110,7 +276,7 @@ func (action *Action) Gen(isfst bool, i int) (s string) {
 			s += tt + "iscomma = true\n"
 		}
 	}
-	s += tt + fmt.Sprintf("issuccess = %g\n", (*USym)(action.What))
+	s += tt + fmt.Sprintf("issuccess = %s\n", (*USym)(action.What).guardedGoString())
 	condstr := "issuccess && tokthen || iscomma || !issuccess && !tokthen"
 	s += tt + fmt.Sprintf("if !(%s) { goto Done%d }\n", condstr, i)
 	return s
@@ -119,6 +285,8 @@ func (action *Action) Gen(isfst bool, i int) (s string) {
 var builtinNames = map[string]string{
 	"TopicMatches":            "TopicMatches",
 	"set":                     "Set",
+	"setfor":                  "SetFor",
+	"ttl":                     "TTL",
 	"trigger":                 "Trigger",
 	"alert":                   "Alert",
 	"exec":                    "Exec",
@@ -135,7 +303,8 @@ var builtinNames = map[string]string{
 	"subscribercount":         "SubscriberCount",
 	"subscribers":             "Subscribers",
 	"subscribersinclude":      "SubscribersInclude",
-	"topicin":                 "Topicin",
+	"topicin":                 "TopicIn",
+	"topicname":               "TopicName",
 	"topicmatches":            "TopicMatches",
 	"nodecount":               "NodeCount",
 	"nodes":                   "Nodes",
@@ -156,6 +325,150 @@ var builtinNames = map[string]string{
 	"signal":                  "Signal",
 	"idsalert":                "IdsAlert",
 	"string":                  "String",
+	"msgint":                  "MsgInt",
+	"msgfloat":                "MsgFloat",
+	"msgstr":                  "MsgStr",
+	"msgbool":                 "MsgBool",
+	"msghas":                  "MsgHas",
+	"len":                     "SetLen",
+	"haskey":                  "HasKey",
+	"delete":                  "Delete",
+	"enable":                  "Enable",
+	"disable":                 "Disable",
+	"stop":                    "Stop",
+	"enabled":                 "Enabled",
+	"hour":                    "Hour",
+	"weekday":                 "Weekday",
+	"count":                   "Count",
+	"rate":                    "Rate",
+	"countif":                 "CountIf",
+	"contains":                "Contains",
+	"hasprefix":               "HasPrefix",
+	"hassuffix":               "HasSuffix",
+	"lower":                   "Lower",
+	"upper":                   "Upper",
+	"substr":                  "Substr",
+	"replace":                 "Replace",
+	"splitcount":              "SplitCount",
+	"format":                  "Format",
+	"abs":                     "Abs",
+	"min":                     "Min",
+	"max":                     "Max",
+	"sqrt":                    "Sqrt",
+	"pow":                     "Pow",
+	"floor":                   "Floor",
+	"ceil":                    "Ceil",
+	"round":                   "Round",
+	"hypot":                   "Hypot",
+}
+
+// true if the expression may be undef when evaluated (see EvalExpr)
+func (s *Sym) canUndef() bool {
+	if s == nil || s.Expr == nil {
+		return false
+	}
+	switch s.SType {
+	case SFCall, SSet:
+		if msgFieldBuiltins[s.Name] {
+			return true
+		}
+		if f := s.Expr.FCall; f.isUserFunc() && f.Body.canUndef() {
+			return true
+		}
+		for _, a := range s.Expr.Args {
+			if a.canUndef() {
+				return true
+			}
+		}
+	case SBinary:
+		return s.Expr.ELeft.canUndef() || s.Expr.ERight.canUndef()
+	case SUnary:
+		return s.Expr.ERight.canUndef()
+	}
+	return false
+}
+
+// In the generated code, undef values panic (see extern.MsgInt), a
+// rule expression or action which may be undef is evaluated as false,
+// like in the interpreter.
+func (s *USym) guardedGoString() (str string) {
+	if !(*Sym)(s).canUndef() {
+		return s.GoString()
+	}
+	return fmt.Sprintf("func() (v bool) {defer extern.UndefRecover(&v); return %g}()", s)
+}
+
+// Constant sets are generated once as global variables, the
+// anonymous ones are named by number (user names cannot start with a digit).
+func (prog *Prog) constSets() (sets []*Sym) {
+	seen := make(map[*Sym]bool)
+	nanon := 0
+	var walk func(s *Sym)
+	walk = func(s *Sym) {
+		if s == nil || seen[s] {
+			return
+		}
+		seen[s] = true
+		if s.SType == SConst && s.DataType.TVal == types.TypeVals[types.TVSet] {
+			if s.Name == "lit" {
+				s.Name = fmt.Sprintf("%d", nanon)
+				nanon++
+			}
+			sets = append(sets, s)
+			return
+		}
+		if s.SType == SVar {
+			walk(s.Val)
+		}
+		if s.Expr == nil {
+			return
+		}
+		for _, a := range s.Expr.Args {
+			walk(a)
+		}
+		walk(s.Expr.ELeft)
+		walk(s.Expr.ERight)
+	}
+	for _, decl := range prog.Decls {
+		walk(decl.LVal)
+	}
+	for _, f := range prog.Funcs {
+		walk(f.Body)
+	}
+	for _, rs := range prog.RuleSects {
+		for _, r := range rs.Rules {
+			walk(r.Expr)
+			for _, a := range r.Actions {
+				walk(a.What)
+			}
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+	return sets
+}
+
+func goSetLit(set extern.StrSet) (str string) {
+	elems := make([]string, 0, len(set))
+	for e := range set {
+		elems = append(elems, fmt.Sprintf("%q: true", e))
+	}
+	sort.Strings(elems)
+	return "extern.StrSet{" + strings.Join(elems, ", ") + "}"
+}
+
+// variadic strings or a set, see SetArgs
+func goSetArgs(args []*Sym) (str string) {
+	if len(args) == 1 && args[0].DataType.TVal == types.TypeVals[types.TVSet] {
+		return fmt.Sprintf("%g", (*USym)(args[0]))
+	}
+	return fmt.Sprintf("extern.NewStrSet(%s)", prvars(args))
+}
+
+var goSetOps = map[lex.TokType]string{
+	lex.TokBitOr:  "extern.SetUnion(%g, %g)",
+	lex.TokBitAnd: "extern.SetInter(%g, %g)",
+	lex.TokEq:     "extern.SetEq(%g, %g)",
+	lex.TokNEq:    "!extern.SetEq(%g, %g)",
 }
 
 func prvars(args []*Sym) (str string) {
@@ -169,18 +482,68 @@ func prvars(args []*Sym) (str string) {
 	return str
 }
 
+// the call to the pure builtin bn, memoized for the event like in
+// the interpreter (see extern.Memo), the args are evaluated once
+// and passed to the call as any, with their type (the constants
+// already have it)
+func (s *USym) memoGoString(bn string) string {
+	params := ""
+	vals := ""
+	for i, a := range s.Expr.Args {
+		switch a.SType {
+		case SYara:
+			params += fmt.Sprintf(", a[%d].(string), lookupYara(a[%d].(string))", i, i)
+			vals += fmt.Sprintf(", %q", a.StrVal)
+		case SRegexp:
+			params += fmt.Sprintf(", a[%d].(string), lookupRegexp(a[%d].(string))", i, i)
+			vals += fmt.Sprintf(", %q", a.StrVal)
+		case SConst:
+			params += fmt.Sprintf(", a[%d].(%s)", i, goTypes[a.DataType.TVal])
+			vals += fmt.Sprintf(", %g", (*USym)(a))
+		default:
+			gt := goTypes[a.DataType.TVal]
+			params += fmt.Sprintf(", a[%d].(%s)", i, gt)
+			vals += fmt.Sprintf(", %s(%g)", gt, (*USym)(a))
+		}
+	}
+	return fmt.Sprintf("extern.Memo(context, %q, func(a ...any) %s { return extern.%s(context%s) }%s)",
+		s.Name, goTypes[s.DataType.TVal], bn, params, vals)
+}
+
+// durations and times are printed like in the interpreter, see fmtvars
+func prprintvars(args []*Sym) (str string) {
+	for i, a := range args {
+		switch a.DataType.TVal {
+		case types.TypeVals[types.TVDuration]:
+			str += fmt.Sprintf("extern.Duration(%g)", (*USym)(a))
+		case types.TypeVals[types.TVTime]:
+			str += fmt.Sprintf("extern.Timestamp(%g)", (*USym)(a))
+		default:
+			str += fmt.Sprintf("%g", (*USym)(a))
+		}
+		if i < len(args)-1 {
+			str += ", "
+		}
+	}
+	return str
+}
+
 func fmtvars(args []*Sym) (str string) {
 	str = "["
 	for i, a := range args {
 		switch a.DataType.TVal {
 		case types.TypeVals[types.TVInt]:
 			str += "%%d"
+		case types.TypeVals[types.TVUint]:
+			str += "%%#x"
 		case types.TypeVals[types.TVFloat]:
 			str += "%%f"
 		case types.TypeVals[types.TVBool]:
 			str += "%%v"
 		case types.TypeVals[types.TVString]:
 			str += `\"%%s\"`
+		case types.TypeVals[types.TVDuration], types.TypeVals[types.TVTime]:
+			str += "%%s"
 		default:
 			str += "%%v"
 		}
@@ -199,16 +562,23 @@ func (s *USym) GoString() (str string) {
 	switch s.SType {
 	case SConst:
 		switch s.DataType.TVal {
-		case types.TypeVals[types.TVInt]:
+		case types.TypeVals[types.TVInt], types.TypeVals[types.TVDuration], types.TypeVals[types.TVTime]:
 			str = fmt.Sprintf("int64(%d)", s.IntVal)
+		case types.TypeVals[types.TVUint]:
+			str = fmt.Sprintf("uint64(%#x)", uint64(s.IntVal))
 		case types.TypeVals[types.TVFloat]:
 			str = fmt.Sprintf("float64(%f)", s.FloatVal)
 		case types.TypeVals[types.TVBool]:
 			str = fmt.Sprintf("%v", s.BoolVal)
 		case types.TypeVals[types.TVString]:
 			str = fmt.Sprintf("%q", s.StrVal)
+		case types.TypeVals[types.TVSet]:
+			str = setPrefix + s.Name
 		default:
 			str = "?"
+			if s.DataType.TVal.IsMap() {
+				str = fmt.Sprintf("extern.NewMap[%s](%d)", goTypes[s.DataType.TVal.MapElem()], s.MaxKeys)
+			}
 		}
 	case SVar:
 		if s.Name != "CurrLevel" && s.Name != "Time" && s.Name != "Uptime" {
@@ -230,7 +600,14 @@ func (s *USym) GoString() (str string) {
 			const funcfmthead = `func()bool{fmt.Fprintf(os.Stderr, "%s call, `
 			const funcfmttail = `\n", %s);return %s}()`
 			fmtstr := funcfmthead + fmtvars(s.Expr.Args) + funcfmttail
-			str += fmt.Sprintf(fmtstr, fname, prvars(s.Expr.Args), fname)
+			str += fmt.Sprintf(fmtstr, fname, prprintvars(s.Expr.Args), fname)
+			return
+		}
+		if s.Name == "set" && s.Expr.Args[0].isIndex() {
+			index := s.Expr.Args[0].Expr
+			rval := s.Expr.Args[1]
+			str += fmt.Sprintf("func()bool{%g.Set(%g, %g); return true}()",
+				(*USym)(index.ELeft), (*USym)(index.ERight), (*USym)(rval))
 			return
 		}
 		if s.Name == "set" {
@@ -239,18 +616,91 @@ func (s *USym) GoString() (str string) {
 			str += fmt.Sprintf("func()bool{%g = %g; return true}()", (*USym)(lval), (*USym)(rval))
 			return
 		}
+		if s.Name == "setfor" {
+			lval := s.Expr.Args[0]
+			rval := s.Expr.Args[1]
+			str += fmt.Sprintf("func()bool{%g = %g; return extern.SetFor(context, %q, %g)}()",
+				(*USym)(lval), (*USym)(rval), lval.Name, (*USym)(s.Expr.Args[2]))
+			return
+		}
+		if s.Name == "ttl" {
+			str = fmt.Sprintf("extern.TTL(context, %q)", s.Expr.Args[0].Name)
+			return
+		}
 		if s.Name == "trigger" {
 			levelname := s.Expr.Args[0].Name
-			str += fmt.Sprintf(`extern.Trigger(context, "%s", int(%g),`, levelname, (*USym)(s.Expr.Args[0]))
+			str += fmt.Sprintf(`extern.TriggerAction(context, "%s", int(%g),`, levelname, (*USym)(s.Expr.Args[0]))
 			str += fmt.Sprintf("levelNames[int64(CurrLevel)], int(CurrLevel), isSoftLevel[int64(CurrLevel)]);")
 
 			str += fmt.Sprintf("CurrLevel = context.CurrLevel\n")
 			return
 		}
+		if s.Expr.FCall.isUserFunc() {
+			str = fmt.Sprintf("%s%s(context", funcPrefix, s.Name)
+			for _, a := range s.Expr.Args {
+				str += fmt.Sprintf(", %g", (*USym)(a))
+			}
+			str += ")"
+			return
+		}
+		if s.Name == "string" {
+			str = fmt.Sprintf("extern.String(context, %s)", prprintvars(s.Expr.Args))
+			return
+		}
+		if s.Name == "format" {
+			str = fmt.Sprintf("extern.Format(context, %s)", prprintvars(s.Expr.Args))
+			return
+		}
+		if (*Sym)(s).isFloatConv(s.Expr.Args) {
+			conv := map[string]string{"int": "FloatInt", "uint": "FloatUint"}[s.Name]
+			str = fmt.Sprintf("extern.%s(context, %q, %g)", conv, s.Pos.String(), (*USym)(s.Expr.Args[0]))
+			return
+		}
+		if s.Name == "float" || s.Name == "int" || s.Name == "uint" {
+			str = fmt.Sprintf("%s(%g)", goTypes[s.DataType.TVal], (*USym)(s.Expr.Args[0]))
+			return
+		}
+		if numBuiltins[s.Name] {
+			suffix := "Int"
+			switch s.Expr.Args[0].DataType.TVal {
+			case types.TypeVals[types.TVFloat]:
+				suffix = "Float"
+			case types.TypeVals[types.TVUint]:
+				suffix = "Uint"
+			}
+			str = fmt.Sprintf("extern.%s%s(context, %s)", builtinNames[s.Name], suffix, prvars(s.Expr.Args))
+			return
+		}
+		if mathBuiltins[s.Name] {
+			str = fmt.Sprintf("extern.CheckFloat(context, %q, extern.%s(context, %s))",
+				s.Pos.String(), builtinNames[s.Name], prvars(s.Expr.Args))
+			return
+		}
+		if s.Name == "len" && len(s.Expr.Args) == 1 && s.Expr.Args[0].DataType.TVal == types.TypeVals[types.TVString] {
+			str = fmt.Sprintf("extern.StrLen(context, %g)", (*USym)(s.Expr.Args[0]))
+			return
+		}
+		if s.Name == "len" && len(s.Expr.Args) == 1 && s.Expr.Args[0].DataType.TVal.IsMap() {
+			str = fmt.Sprintf("extern.MapLen(context, %g)", (*USym)(s.Expr.Args[0]))
+			return
+		}
 		bn, ok := builtinNames[s.Name]
 		if !ok {
 			panic("bad name " + s.Name)
 		}
+		if f := s.Expr.FCall; f != nil && f.isSetFunc() {
+			nfixed := len(f.ArgDataTypes) - 1
+			str = fmt.Sprintf("extern.%sSet(context, ", bn)
+			for _, a := range s.Expr.Args[:nfixed] {
+				str += fmt.Sprintf("%g, ", (*USym)(a))
+			}
+			str += goSetArgs(s.Expr.Args[nfixed:]) + ")"
+			return
+		}
+		if f := s.Expr.FCall; f != nil && f.IsPure {
+			str = (*USym)(s).memoGoString(bn)
+			return
+		}
 		str = fmt.Sprintf("extern.%s(context, ", bn)
 		str += prvars(s.Expr.Args)
 		str += ")"
@@ -258,14 +708,49 @@ func (s *USym) GoString() (str string) {
 		str += fmt.Sprintf("%s(%d args)", s.Name, len(s.ArgDataTypes))
 	case SLevel:
 		str = levelPrefix + s.Name
+	case SRule:
+		str = fmt.Sprintf("%q", s.Name)
 	case SAsign:
 		str = (*USym)(s.Asign.LVal).GoString() + " = " + (*USym)(s.Asign.RVal).GoString()
+	case SSet:
+		str = fmt.Sprintf("extern.NewStrSet(%s)", prvars(s.Expr.Args))
 	case SBinary:
+		op := lex.TokType(s.Expr.Op)
+		if op == lex.TokIn {
+			str = fmt.Sprintf("%g[%g]", (*USym)(s.Expr.ERight), (*USym)(s.Expr.ELeft))
+			return
+		}
+		if op == lex.TokLBrack {
+			str = fmt.Sprintf("%g.Get(%g)", (*USym)(s.Expr.ELeft), (*USym)(s.Expr.ERight))
+			return
+		}
+		if setop, ok := goSetOps[op]; ok && s.Expr.ELeft.DataType.TVal == types.TypeVals[types.TVSet] {
+			str = "(" + fmt.Sprintf(setop, (*USym)(s.Expr.ELeft), (*USym)(s.Expr.ERight)) + ")"
+			return
+		}
+		if (*Sym)(s).isFloatArith() {
+			fop := "(%g " + lex.UTokType(s.Expr.Op).String() + " %g)"
+			if s.Expr.Op == '%' {
+				fop = "extern.FMod(%g, %g)"
+			}
+			fop = fmt.Sprintf(fop, (*USym)(s.Expr.ELeft), (*USym)(s.Expr.ERight))
+			str = fmt.Sprintf("extern.CheckFloat(context, %q, %s)", s.Pos.String(), fop)
+			return
+		}
+		er := (*USym)(s.Expr.ERight).GoString()
+		isshift := op == lex.TokLShift || op == lex.TokRShift
+		if isshift && s.Expr.ERight.DataType.TVal != types.TypeVals[types.TVUint] {
+			er = fmt.Sprintf("extern.ShiftCount(context, %q, %s)", s.Pos.String(), er)
+		}
 		str = "(" + (*USym)(s.Expr.ELeft).GoString() + " "
 		str += lex.UTokType(s.Expr.Op).String() + " "
-		str += (*USym)(s.Expr.ERight).GoString() + ")"
+		str += er + ")"
 	case SUnary:
-		str = "(" + lex.UTokType(s.Expr.Op).String() + (*USym)(s.Expr.ERight).GoString() + ")"
+		uop := lex.UTokType(s.Expr.Op).String()
+		if s.Expr.Op == '~' {
+			uop = "^" //complement in go
+		}
+		str = "(" + uop + (*USym)(s.Expr.ERight).GoString() + ")"
 	case SRegexp:
 		str += fmt.Sprintf("\"%s\", lookupRegexp(\"%s\")", s.StrVal, s.StrVal)
 	case SYara:
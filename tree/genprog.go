@@ -148,6 +148,10 @@ func main() {
 	}
 
 	context := extern.NewContext(nil, pathscripts, len(levelNames), errout, &stats)
+	for i := int64(0); i < int64(len(levelNames)); i++ {
+		context.AddLevel(levelNames[i])
+	}
+	context.Transitions = transitions
 	if err := extern.SockRemove(sockpath); err != nil {
 		log.Fatal(err)
 	}
@@ -179,6 +183,8 @@ func main() {
 		Mc:       mc,
 		Mcr:      mcr,
 		Pathsc:   pathsc,
+		Timers:   timers,
+		Afters:   afters,
 	}
 	go extern.Dispatcher(context, d)
 	// Accept an incoming connection.
@@ -214,6 +220,9 @@ func main() {
 			}
 		}()
 		updatePredefVars(context)
+		restoreExpired(context)
+		context.ResetMemo()
+		extern.WindowEvent(context)
 		Uptime = Uptime //make them used
 		Time = Time
 		CurrLevel = context.CurrLevel
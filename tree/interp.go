@@ -36,15 +36,37 @@ func (p *Prog) PushSyms(envs *StkEnv) {
 		}
 		s.DataType = v.DataType
 		if v.SType == SVar {
-			val := NewAnonSym(SConst)
-			val.CopyValFrom(v)
-			s.SetVal(val)
+			s.SetVal(initVal(v))
 		} else {
 			s.CopyValFrom(v)
 		}
 	}
 }
 
+// the declared value of the var v, for each run
+func initVal(v *Sym) (val *Sym) {
+	val = NewAnonSym(SConst)
+	val.CopyValFrom(v)
+	if val.DataType.TVal.IsMap() {
+		val.MapVal = extern.NewMap[any](v.MaxKeys)
+	}
+	return val
+}
+
+// the vars set with setfor which expired get back
+// their declared value, see extern.Expiries
+func (p *Prog) restoreExpired(context *extern.Ctx, execEnv *StkEnv) {
+	for _, name := range context.Expired() {
+		v, ok := p.Env[name]
+		s := execEnv.GetSym(name)
+		if !ok || s == nil || v.SType != SVar {
+			continue
+		}
+		execEnv.dprintf("Expired %s\n", name)
+		s.SetVal(initVal(v))
+	}
+}
+
 func (p *Prog) PopSyms(envs *StkEnv) {
 	for _, v := range envs.CurrEnv() {
 		if v.SType == SVar || v.SType == SLevel || v.SType == SConst {
@@ -54,11 +76,31 @@ func (p *Prog) PopSyms(envs *StkEnv) {
 	envs.PopEnv()
 }
 
-func (r *Rule) Interp(context *extern.Ctx, execEnv *StkEnv) {
+// evaluates the expressions of the rules, walking the tree
+// (StkEnv) or running their code (VM), pc is where it starts
+type evaluator interface {
+	eval(context *extern.Ctx, s *Sym, pc int) *Sym
+}
+
+func (envs *StkEnv) eval(context *extern.Ctx, s *Sym, pc int) *Sym {
+	return s.EvalExpr(envs, context)
+}
+
+// returns if the rule was activated
+func (r *Rule) Interp(context *extern.Ctx, execEnv *StkEnv) (isactive bool) {
+	return r.interp(context, execEnv, execEnv)
+}
+
+func (r *Rule) interp(context *extern.Ctx, execEnv *StkEnv, ev evaluator) (isactive bool) {
+	if r.Label != nil && !extern.Enabled(context, r.Label.Name) {
+		return false
+	}
 	execEnv.dprintf("Rule Expr: %s\n", r.Expr)
-	val := r.Expr.EvalExpr(execEnv, context)
+	val := ev.eval(context, r.Expr, r.pc)
 	execEnv.dprintf("Rule ExprVal: %s\n", val)
-	if val.BoolVal {
+	//undef is false, like the generated code (true && undef keeps BoolVal)
+	isactive = val.BoolVal && !val.DataType.IsTypeUndef()
+	if isactive {
 		execEnv.dprintf("Rule Interp: activated %s\n", r)
 		donext := true
 		issuccess := true
@@ -77,11 +119,12 @@ func (r *Rule) Interp(context *extern.Ctx, execEnv *StkEnv) {
 			if !donext {
 				break
 			}
-			actVal := a.What.EvalExpr(execEnv, context)
+			actVal := ev.eval(context, a.What, a.pc)
 			issuccess = actVal.BoolVal
 			execEnv.dprintf("is successful %v %s\n", issuccess, lex.TokType(a.Con))
 		}
 	}
+	return isactive
 }
 func (p *Prog) NewExecEnv(context *extern.Ctx) (execEnv *StkEnv) {
 	execEnv = new(StkEnv) //execution stack
@@ -95,21 +138,37 @@ func (p *Prog) NewExecEnv(context *extern.Ctx) (execEnv *StkEnv) {
 	return execEnv
 }
 
+// Interp runs the rules walking the tree, see VM.Interp
 func (p *Prog) Interp(context *extern.Ctx, execEnv *StkEnv) {
+	p.interp(context, execEnv, execEnv)
+}
 
+func (p *Prog) interp(context *extern.Ctx, execEnv *StkEnv, ev evaluator) {
+
+	context.ResetMemo() //the pure builtins are memoized for one run
+	extern.WindowEvent(context)
 	err := execEnv.SetPredefVars(p, context)
 	if err != nil {
 		panic(err)
 	}
+	p.restoreExpired(context, execEnv)
 	tm := "External"
 	if context.CurrentMsg != nil {
 		tm = context.CurrentMsg.Type()
 	}
+	if context.Timer != "" {
+		tm = context.Timer
+	}
+	context.Stopped = false
 	for _, rs := range p.RuleSects {
 		if rs.SectId.Name == tm {
 			execEnv.dprintf("Section Interp: for msg type %s: %s\n", tm, rs)
-			for _, r := range rs.Rules {
-				r.Interp(context, execEnv)
+			for _, r := range rs.candidates(context) {
+				isactive := r.interp(context, execEnv, ev)
+				if context.Stopped || rs.IsFirst && isactive {
+					execEnv.dprintf("Section Interp: stopped at %s\n", r)
+					break
+				}
 			}
 			break
 		}
@@ -0,0 +1,152 @@
+package tree
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"rips/rips/diag"
+	"rips/rips/lex"
+)
+
+func (p *Prog) warnf(pos lex.Position, end lex.Position, str string, v ...interface{}) {
+	p.Warnings = append(p.Warnings, diag.New(diag.Warning, pos, end, str, v...))
+}
+
+// Lint returns the warnings of the compiled program, the ones
+// found while compiling and the ones of the rules, in order.
+func (p *Prog) Lint() (ws []*diag.Diagnostic) {
+	for _, decl := range p.Decls {
+		if decl.LVal.SType == SConst && !decl.LVal.IsUsed {
+			p.warnf(decl.LVal.Pos, decl.LVal.End, "const %s is never used", decl.LVal.Name)
+		}
+	}
+	for _, rs := range p.RuleSects {
+		for _, r := range rs.Rules {
+			p.lintRule(r)
+		}
+	}
+	ws = p.Warnings
+	p.Warnings = nil
+	diag.Sort(ws)
+	return ws
+}
+
+// the source of s from pos to end, as written, or s printed
+// if the source is unknown
+func (p *Prog) span(pos lex.Position, end lex.Position, s *Sym) string {
+	if p.Sources != nil {
+		if text, ok := p.Sources.Span(pos, end); ok {
+			return text
+		}
+	}
+	return fmt.Sprintf("%s", (*USym)(s))
+}
+
+// called by Fold before folding, rules of a section
+// written the same are duplicates
+func (p *Prog) warnDup(seen map[string]*Rule, r *Rule) {
+	key := fmt.Sprintf("%s ?", (*USym)(r.Expr))
+	for _, a := range r.Actions {
+		key += fmt.Sprintf(" %s", (*USym)(a.What))
+	}
+	if first, ok := seen[key]; ok {
+		p.warnf(r.Start, r.End, "duplicate rule, the same as the one at %s", first.Pos)
+		return
+	}
+	seen[key] = r
+}
+
+func (p *Prog) lintRule(r *Rule) {
+	currlevel := ""
+	for _, c := range r.Expr.conjuncts() {
+		if name := c.currLevelIs(); name != "" {
+			currlevel = name
+		}
+	}
+	exprs := []*Sym{r.Expr}
+	for i, a := range r.Actions {
+		exprs = append(exprs, a.What)
+		if a.What.SType == SFCall && a.What.Name == "crash" && i != len(r.Actions)-1 {
+			p.warnf(a.What.Pos, a.What.End, "the actions after %s are never run", (*USym)(a.What))
+		}
+		if a.What.SType == SFCall && a.What.Name == "trigger" && currlevel != "" &&
+			len(a.What.Expr.Args) == 1 && a.What.Expr.Args[0].Name == currlevel {
+			p.warnf(a.What.Pos, a.What.End, "%s when CurrLevel is %s does nothing", (*USym)(a.What), currlevel)
+		}
+	}
+	for _, e := range exprs {
+		for _, c := range e.calls() {
+			if c.Expr.FCall == clockConv {
+				name := c.Expr.Args[0].Name
+				p.warnf(c.Pos, c.End, "%s as an int (nanoseconds) is deprecated, use a %s or int(%s)", name, c.Expr.Args[0].DataType.TVal, name)
+				continue
+			}
+			if c.Name != "topicmatches" || len(c.Expr.Args) == 0 || c.Expr.Args[0].Re == nil {
+				continue
+			}
+			if restr := c.Expr.Args[0].StrVal; neverTopic(restr) {
+				p.warnf(c.Pos, c.End, "regexp %q can never match a topic name", restr)
+			}
+		}
+	}
+}
+
+// a && b && c is [a b c]
+func (s *Sym) conjuncts() (cs []*Sym) {
+	if s.SType == SBinary && s.Expr != nil && lex.TokType(s.Expr.Op) == lex.TokLogAnd {
+		return append(s.Expr.ELeft.conjuncts(), s.Expr.ERight.conjuncts()...)
+	}
+	return []*Sym{s}
+}
+
+// the level of CurrLevel == level, "" if it is not that
+func (s *Sym) currLevelIs() string {
+	if s.SType != SBinary || s.Expr == nil || lex.TokType(s.Expr.Op) != lex.TokEq {
+		return ""
+	}
+	l, r := s.Expr.ELeft, s.Expr.ERight
+	if r.SType == SVar {
+		l, r = r, l
+	}
+	if l.SType != SVar || l.Name != "CurrLevel" || r.SType != SLevel {
+		return ""
+	}
+	return r.Name
+}
+
+// the runes of a ROS topic name (before expansion)
+func isTopicRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return r == '/' || r == '_' || r == '~' || r == '{' || r == '}'
+}
+
+// A topic name cannot match a regexp needing a literal with
+// a rune not in names or starting with something else than /.
+func neverTopic(restr string) bool {
+	re, err := syntax.Parse(restr, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	for i, sub := range subs {
+		if sub.Op != syntax.OpLiteral || len(sub.Rune) == 0 {
+			continue
+		}
+		for _, r := range sub.Rune {
+			if !isTopicRune(r) {
+				return true
+			}
+		}
+		isstart := i > 0 && (subs[i-1].Op == syntax.OpBeginText || subs[i-1].Op == syntax.OpBeginLine)
+		if isstart && sub.Rune[0] != '/' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package tree
+
+import (
+	"rips/rips/lex"
+	"sort"
+)
+
+// The operands of the chains of && and || (a && b && c) are
+// evaluated cheapest first, so a rule testing payload(...) before
+// topicin(...) does not scan the payload of the messages of other
+// topics. Only the safe operands move, among their safe neighbours,
+// the rest stay in place and split the chain.
+
+// the class of the most expensive builtin called by s
+func (s *Sym) exprCost() (c Cost) {
+	if s == nil || s.Expr == nil {
+		return CostCheap
+	}
+	maxCost := func(e *Sym) {
+		if ec := e.exprCost(); ec > c {
+			c = ec
+		}
+	}
+	switch s.SType {
+	case SFCall, SSet:
+		if f := s.Expr.FCall; f.isUserFunc() {
+			maxCost(f.Body)
+		} else if f != nil {
+			c = f.Cost
+		}
+		for _, a := range s.Expr.Args {
+			maxCost(a)
+		}
+	case SBinary:
+		maxCost(s.Expr.ELeft)
+		maxCost(s.Expr.ERight)
+	case SUnary:
+		maxCost(s.Expr.ERight)
+	}
+	return c
+}
+
+// safe tells if s gives the same evaluated before or after other
+// safe expressions or not evaluated at all: it changes nothing, is
+// never undef and cannot stop the program (division by a var,
+// shifts, NaN or Inf floats)
+func (s *Sym) isSafe() bool {
+	if s == nil || s.Expr == nil {
+		return true
+	}
+	if s.canUndef() {
+		return false
+	}
+	switch s.SType {
+	case SFCall, SSet:
+		f := s.Expr.FCall
+		if s.SType == SFCall && (f == nil || f.IsAction || effectBuiltins[s.Name] || mathBuiltins[s.Name]) {
+			return false
+		}
+		if f.isUserFunc() && !f.Body.isSafe() {
+			return false
+		}
+		for _, a := range s.Expr.Args {
+			if !a.isSafe() {
+				return false
+			}
+		}
+		return true
+	case SBinary:
+		switch lex.TokType(s.Expr.Op) {
+		case lex.TokDiv, lex.TokMod, lex.TokLShift, lex.TokRShift:
+			if !s.Expr.ERight.IsConstant() {
+				return false
+			}
+		}
+		if s.isFloatArith() {
+			return false
+		}
+		return s.Expr.ELeft.isSafe() && s.Expr.ERight.isSafe()
+	case SUnary:
+		return s.Expr.ERight.isSafe()
+	}
+	return true
+}
+
+func isLogOp(op int) bool {
+	tok := lex.TokType(op)
+	return tok == lex.TokLogAnd || tok == lex.TokLogOr
+}
+
+// reorder sorts the safe operands of the chains of && and || in s
+// by cost, keeping the order of the ones with the same cost. The
+// binary nodes are reused, the chain is rebuilt from the left.
+func (s *Sym) reorder() *Sym {
+	if s == nil || s.Expr == nil {
+		return s
+	}
+	switch s.SType {
+	case SFCall, SSet:
+		for i, a := range s.Expr.Args {
+			s.Expr.Args[i] = a.reorder()
+		}
+		return s
+	case SUnary:
+		s.Expr.ERight = s.Expr.ERight.reorder()
+		return s
+	case SBinary:
+	default:
+		return s
+	}
+	op := s.Expr.Op
+	if !isLogOp(op) {
+		s.Expr.ELeft = s.Expr.ELeft.reorder()
+		s.Expr.ERight = s.Expr.ERight.reorder()
+		return s
+	}
+	//a && b && c is (a && b) && c, the nodes from the outside
+	var nodes, ops []*Sym
+	e := s
+	for e.SType == SBinary && e.Expr.Op == op {
+		nodes = append(nodes, e)
+		ops = append(ops, e.Expr.ERight)
+		e = e.Expr.ELeft
+	}
+	ops = append(ops, e)
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	for i := range ops {
+		ops[i] = ops[i].reorder()
+	}
+	start := 0
+	for i := 0; i <= len(ops); i++ {
+		if i < len(ops) && ops[i].isSafe() {
+			continue
+		}
+		run := ops[start:i]
+		sort.SliceStable(run, func(a, b int) bool {
+			return run[a].exprCost() < run[b].exprCost()
+		})
+		start = i + 1
+	}
+	left := ops[0]
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i].Expr.ELeft = left
+		nodes[i].Expr.ERight = ops[len(nodes)-i]
+		left = nodes[i]
+	}
+	return s
+}
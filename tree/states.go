@@ -2,16 +2,128 @@ package tree
 
 import (
 	"io"
+	"rips/rips/extern"
 )
 
+// The changes of level the program can make, the declared transitions
+// or, without a transitions section, the ones extern.Trigger allows.
+func (p *Prog) transitions() (trs extern.Transitions) {
+	if p.Transitions != nil {
+		return p.Transitions
+	}
+	trs = make(extern.Transitions)
+	for i, from := range p.Levels {
+		for j := range p.Levels {
+			if j > i || j == i-1 && from.IsSoft {
+				trs[extern.Transition{From: i, To: j}] = true
+			}
+		}
+	}
+	return trs
+}
+
+// With a transitions section, a trigger can change the level if
+// there is a transition to its level from one of the reachable
+// levels the rule runs in, the one of its CurrLevel == L or any.
+func (p *Prog) checkTriggers(errout io.Writer, isreach map[int]bool) (nerr int) {
+	if p.Transitions == nil {
+		return 0
+	}
+	for _, rs := range p.RuleSects {
+		for _, r := range rs.Rules {
+			from := []*Sym{}
+			for _, c := range r.Expr.conjuncts() {
+				if name := c.currLevelIs(); name != "" {
+					from = []*Sym{p.level(name)}
+				}
+			}
+			if len(from) == 0 {
+				from = p.Levels
+			}
+			exprs := []*Sym{r.Expr}
+			for _, a := range r.Actions {
+				exprs = append(exprs, a.What)
+			}
+			for _, e := range exprs {
+				for _, c := range e.calls() {
+					if c.Name != "trigger" || len(c.Expr.Args) != 1 || c.Expr.Args[0].SType != SLevel {
+						continue
+					}
+					to := c.Expr.Args[0]
+					hasto := false
+					names := ""
+					for _, ls := range from {
+						if ls == nil || !isreach[ls.SLevel] {
+							continue
+						}
+						hasto = hasto || p.Transitions[extern.Transition{From: ls.SLevel, To: to.SLevel}]
+						names += " " + ls.Name
+					}
+					if !hasto && names != "" {
+						c.Errorf(errout, 0, "%s can never change the level, there is no transition to %s from%s",
+							(*USym)(c), to.Name, names)
+						nerr++
+					}
+				}
+			}
+		}
+	}
+	return nerr
+}
+
+// the level called name, nil if there is none
+func (p *Prog) level(name string) *Sym {
+	for _, ls := range p.Levels {
+		if ls.Name == name {
+			return ls
+		}
+	}
+	return nil
+}
+
+// The levels are reached from the first one through the transitions
+// to the triggered levels (before Fold, see Annotate) and the
+// de-escalations of soft levels with after. All of them but the
+// last need a way out.
 func (p *Prog) StatesCheck(errout io.Writer) (nerr int) {
 	if len(p.Levels) == 0 {
 		return //should we err?
 	}
-	for _, ls := range p.Levels {
-		if !ls.IsReach {
+	trs := p.transitions()
+	for i, ls := range p.Levels {
+		if ls.After != 0 && !trs[extern.Transition{From: i, To: i - 1}] {
+			ls.Errorf(errout, 0, "level %s de-escalates after %s but there is no transition %s -> %s",
+				ls.Name, ls.After, ls.Name, p.Levels[i-1].Name)
+			nerr++
+		}
+	}
+	isreach := map[int]bool{0: true}
+	hasout := make(map[int]bool)
+	for queue := []int{0}; len(queue) > 0; queue = queue[1:] {
+		from := queue[0]
+		for to := range p.Levels {
+			if !trs[extern.Transition{From: from, To: to}] {
+				continue
+			}
+			if !p.Levels[to].IsTriggered && (to != from-1 || p.Levels[from].After == 0) {
+				continue
+			}
+			hasout[from] = true
+			if !isreach[to] {
+				isreach[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	nerr += p.checkTriggers(errout, isreach)
+	for i, ls := range p.Levels {
+		switch {
+		case !isreach[i]:
 			ls.Errorf(errout, 0, "level %s not reachable", ls.Name)
 			nerr++
+		case !hasout[i] && i != len(p.Levels)-1:
+			ls.Errorf(errout, 0, "level %s has no way out", ls.Name)
+			nerr++
 		}
 	}
 	return nerr
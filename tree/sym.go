@@ -5,9 +5,11 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"rips/rips/diag"
 	"rips/rips/extern"
 	"rips/rips/lex"
 	"rips/rips/types"
+	"time"
 
 	"github.com/kgwinnup/go-yara/yara"
 )
@@ -27,6 +29,8 @@ const (
 	SAsign  //assignment
 	SRegexp //for compiled regexps
 	SYara   //for yara rules
+	SSet    // set literal, elements in Expr.Args
+	SRule   // rule label
 )
 
 type BuiltinFunc func(context *extern.Ctx, args ...*Sym) *Sym
@@ -34,40 +38,53 @@ type BuiltinFunc func(context *extern.Ctx, args ...*Sym) *Sym
 type Func struct {
 	ArgDataTypes []types.Type
 	Fn           BuiltinFunc //the function itself
+	Typed        any         //its typed entry point, nil if there is none, see callTyped
 	IsVariadic   bool        //last argtype is repeated
+	IsPure       bool        //memoized for the event, see Builtin
+	Cost         Cost        //of the builtin, see reorder
+
+	/* user functions, see the funcs section */
+	Params []*Sym //vars, declared in their own env
+	Body   *Sym   //expression returned
 }
 
 type Sym struct {
-	Name  string //name of the var, literal, etc.
-	SType int    //SVar, SConst...
-	Pos   lex.Position
+	Name  string       //name of the var, literal, etc.
+	SType int          //SVar, SConst...
+	Pos   lex.Position //where it starts
+	End   lex.Position //its last rune, zero if not known
 
 	DataType types.Type //in func, return type
 	//other stuff for the tree
 
 	/* one of */
 
-	FloatVal float64
-	IntVal   int64
-	StrVal   string
-	BoolVal  bool
-	Expr     *Expr
-	Asign    *Asign
+	FloatVal  float64
+	IntVal    int64
+	StrVal    string
+	BoolVal   bool
+	StrSetVal extern.StrSet
+	MapVal    *extern.Map[any] //values of the elements, see mapVal
+	MaxKeys   int              //bound of the keys of a map, see extern.Map
+	Expr      *Expr
+	Asign     *Asign
 
 	/* Slevel two */
 	SLevel int
 	IsSoft bool
+	After  time.Duration //soft levels de-escalate after it without triggers
 
 	Func
 
 	IsAction bool //HACK for function and fcall, turned off after checked by the parser
 
 	Val       *Sym /* for var when init (an later for eval)*/
+	ownVal    *Sym //the value set, allocated once, see copyVal
 	IsSet     bool
 	IsUsed    bool
 	IsBuiltin bool
 
-	IsReach bool /*for levels */
+	IsTriggered bool /*for levels, by some trigger, see StatesCheck */
 
 	/* for builtin parameters, they are string vals with extras */
 	Yr *yara.Yara
@@ -78,12 +95,7 @@ func (s *Sym) Errorf(errout io.Writer, nerr int, str string, v ...interface{}) {
 	if nerr >= 1 {
 		return
 	}
-	place := fmt.Sprintf("%s:%d: ", s.Pos.File, s.Pos.Line)
-	out := errout
-	if lex.DOut {
-		out = os.Stderr
-	}
-	fmt.Fprintf(out, place+str+"\n", v...)
+	diag.Errorf(errout, s.Pos, s.End, str, v...)
 }
 
 type Env map[string]*Sym
@@ -145,13 +157,24 @@ func (envs *StkEnv) GetSym(name string) (sym *Sym) {
 	return sym
 }
 
+// The builtin functions are in their own env, under the one of
+// the user, a var, const or param can shadow them (a param max
+// hides the builtin max), the rest cannot be shadowed.
+func (envs *StkEnv) isShadowed(name string) bool {
+	s := envs.GetSym(name)
+	return s != nil && !s.isBuiltinFunc()
+}
+
+func (s *Sym) isBuiltinFunc() bool {
+	return s.SType == SFunc && s.Fn != nil
+}
+
 func (envs *StkEnv) NewVar(name string, typeval *types.TypeVal) (sym *Sym, err error) {
 	//to forbid shadowing, look up
-	s := envs.GetSym(name)
-	if s != nil {
+	if envs.isShadowed(name) {
 		return nil, fmt.Errorf("already declared sym: no shadowing '%s'", name)
 	}
-	s, err = envs.NewSym(name, SVar)
+	s, err := envs.NewSym(name, SVar)
 	if err != nil {
 		return nil, err
 	}
@@ -163,11 +186,10 @@ func (envs *StkEnv) NewVar(name string, typeval *types.TypeVal) (sym *Sym, err e
 
 func (envs *StkEnv) NewConst(name string, typeval *types.TypeVal) (sym *Sym, err error) {
 	//to forbid shadowing, look up
-	s := envs.GetSym(name)
-	if s != nil {
+	if envs.isShadowed(name) {
 		return nil, fmt.Errorf("already declared sym: no shadowing '%s'", name)
 	}
-	s, err = envs.NewSym(name, SConst)
+	s, err := envs.NewSym(name, SConst)
 	if err != nil {
 		return nil, err
 	}
@@ -180,15 +202,69 @@ func (envs *StkEnv) NewConst(name string, typeval *types.TypeVal) (sym *Sym, err
 
 func NewBool(v bool) (s *Sym) {
 	val := NewAnonSym(SConst)
-	val.DataType = types.BoolType
-	val.BoolVal = v
+	val.setBool(v)
 	return val
 }
 
+func (s *Sym) setBool(v bool) {
+	s.DataType = types.BoolType
+	s.BoolVal = v
+}
+
 func NewString(v string) (s *Sym) {
 	val := NewAnonSym(SConst)
-	val.DataType = types.StringType
-	val.StrVal = v
+	val.setString(v)
+	return val
+}
+
+func (s *Sym) setString(v string) {
+	s.DataType = types.StringType
+	s.StrVal = v
+}
+
+func NewInt(v int64) (s *Sym) {
+	val := NewAnonSym(SConst)
+	val.setInt(v)
+	return val
+}
+
+func (s *Sym) setInt(v int64) {
+	s.DataType = types.IntType
+	s.IntVal = v
+}
+
+// empty map of type tvp, maps are created
+// when the program is run, see PushSyms
+func NewMap(tvp *types.TypeVal, maxkeys int) (s *Sym) {
+	val := NewAnonSym(SConst)
+	val.DataType = types.Type{TVal: tvp, TExpr: types.TypeExprs[types.TEExpr]}
+	val.MaxKeys = maxkeys
+	return val
+}
+
+// the bits are kept in IntVal
+func NewUint(v uint64) (s *Sym) {
+	val := NewAnonSym(SConst)
+	val.DataType = types.UintType
+	val.IntVal = int64(v)
+	return val
+}
+
+func NewFloat(v float64) (s *Sym) {
+	val := NewAnonSym(SConst)
+	val.setFloat(v)
+	return val
+}
+
+func (s *Sym) setFloat(v float64) {
+	s.DataType = types.FloatType
+	s.FloatVal = v
+}
+
+func NewSet(v extern.StrSet) (s *Sym) {
+	val := NewAnonSym(SConst)
+	val.DataType = types.SetType
+	val.StrSetVal = v
 	return val
 }
 
@@ -223,6 +299,28 @@ func (envs *StkEnv) NewFunc(name string, typeargs []types.Type, typeret types.Ty
 	return s, nil
 }
 
+// User functions, the types and the body are filled in by the parser
+func (envs *StkEnv) NewUserFunc(name string) (sym *Sym, err error) {
+	//to forbid shadowing, look up
+	s := envs.GetSym(name)
+	if s != nil && s.isBuiltinFunc() {
+		return nil, fmt.Errorf("'%s' is a builtin function, a function cannot shadow it", name)
+	}
+	if s != nil {
+		return nil, fmt.Errorf("already declared sym: no shadowing '%s'", name)
+	}
+	s, err = envs.NewSym(name, SFunc)
+	if err != nil {
+		return nil, err
+	}
+	s.DataType = types.UndefExprType
+	return s, nil
+}
+
+func (f *Sym) isUserFunc() bool {
+	return f != nil && f.SType == SFunc && f.Body != nil
+}
+
 func (s *Sym) String() string {
 	if s == nil {
 		return "nil"
@@ -238,12 +336,20 @@ func (s *Sym) String() string {
 		switch s.DataType.TVal {
 		case types.TypeVals[types.TVInt]:
 			str += fmt.Sprintf("%d", s.IntVal)
+		case types.TypeVals[types.TVUint]:
+			str += fmt.Sprintf("%#x", uint64(s.IntVal))
+		case types.TypeVals[types.TVDuration]:
+			str += extern.Duration(s.IntVal).String()
+		case types.TypeVals[types.TVTime]:
+			str += extern.Timestamp(s.IntVal).String()
 		case types.TypeVals[types.TVFloat]:
 			str += fmt.Sprintf("%f", s.FloatVal)
 		case types.TypeVals[types.TVBool]:
 			str += fmt.Sprintf("%v", s.BoolVal)
 		case types.TypeVals[types.TVString]:
 			str += fmt.Sprintf("\"%s\"", s.StrVal)
+		case types.TypeVals[types.TVSet]:
+			str += s.StrSetVal.String()
 		default:
 			str += "?"
 		}
@@ -255,8 +361,13 @@ func (s *Sym) String() string {
 		}
 	case SFCall:
 		str += fmt.Sprintf("FCall(%s)", s.Expr.Args)
+	case SSet:
+		str += fmt.Sprintf("Set(%s)", s.Expr.Args)
 	case SFunc:
 		str += fmt.Sprintf("Func(%d args)", len(s.ArgDataTypes))
+		if s.Body != nil {
+			str += fmt.Sprintf("%s -> %s", s.Params, s.Body)
+		}
 	case SLevel:
 		str += "Level"
 		//if s.Val != nil {
@@ -279,6 +390,8 @@ func (s *Sym) String() string {
 		str += fmt.Sprintf("Yara: \"%s\", %v", s.StrVal, s.Yr)
 	case SSect:
 		str += fmt.Sprintf("Section")
+	case SRule:
+		str += "Rule"
 	}
 	str += "!]"
 	return str
@@ -297,14 +410,28 @@ func (s *USym) SimpleString() (str string) {
 		switch s.DataType.TVal {
 		case types.TypeVals[types.TVInt]:
 			str = fmt.Sprintf("%d", s.IntVal)
+		case types.TypeVals[types.TVUint]:
+			str = fmt.Sprintf("%#x", uint64(s.IntVal))
+		case types.TypeVals[types.TVDuration]:
+			str = extern.Duration(s.IntVal).String()
+		case types.TypeVals[types.TVTime]:
+			str = extern.Timestamp(s.IntVal).String()
 		case types.TypeVals[types.TVFloat]:
 			str = fmt.Sprintf("%f", s.FloatVal)
 		case types.TypeVals[types.TVBool]:
 			str = fmt.Sprintf("%v", s.BoolVal)
 		case types.TypeVals[types.TVString]:
 			str = fmt.Sprintf("\"%s\"", s.StrVal)
+		case types.TypeVals[types.TVSet]:
+			str = s.StrSetVal.String()
 		default:
 			str = "?"
+			if s.DataType.TVal.IsMap() {
+				str = "{}"
+			}
+			if s.MapVal != nil {
+				str = s.MapVal.String()
+			}
 		}
 	case SVar:
 		str = s.Name
@@ -317,6 +444,15 @@ func (s *USym) SimpleString() (str string) {
 			}
 		}
 		str += ")"
+	case SSet:
+		str = "{"
+		for i, a := range s.Expr.Args {
+			str += fmt.Sprintf("%s", (*USym)(a))
+			if i < len(s.Expr.Args)-1 {
+				str += ", "
+			}
+		}
+		str += "}"
 	case SFunc:
 		str += fmt.Sprintf("%s(%d args)", s.Name, len(s.ArgDataTypes))
 	case SLevel:
@@ -330,6 +466,9 @@ func (s *USym) SimpleString() (str string) {
 		rightstr := (*USym)(s.Expr.ERight).SimpleString()
 		opstr := lex.UTokType(s.Expr.Op).String()
 		str = "(" + leftstr + " " + opstr + " " + rightstr + ")"
+		if (*Sym)(s).isIndex() {
+			str = leftstr + "[" + rightstr + "]"
+		}
 	case SUnary:
 		rightstr := (*USym)(s.Expr.ERight).SimpleString()
 		opstr := lex.UTokType(s.Expr.Op).String()
@@ -340,6 +479,8 @@ func (s *USym) SimpleString() (str string) {
 		str += fmt.Sprintf("Yara: \"%s\", %v", s.StrVal, s.Yr)
 	case SSect:
 		str += fmt.Sprintf("sectionid:%s", s.Name)
+	case SRule:
+		str = s.Name
 	}
 	return str
 }
@@ -367,6 +508,10 @@ func (s *USym) TypeString() (str string) {
 		str = "regular expression"
 	case SYara:
 		str = "yara rule"
+	case SSet:
+		str = "set"
+	case SRule:
+		str = "rule"
 	default:
 		str = "unknown symbol"
 	}
@@ -26,3 +26,23 @@ func TestGen(t *testing.T) {
 	fmt.Println(sym)
 	fmt.Println(s)
 }
+
+// set copies the value into the var, not into the level it was
+func TestSetShared(t *testing.T) {
+	var s tree.StkEnv
+	s.PushEnv()
+	level, _ := s.NewSym("ALEV", tree.SLevel)
+	level.DataType = types.IntType
+	level.IntVal = 1
+	v, _ := s.NewSym("lv", tree.SVar)
+	v.DataType = types.IntType
+	v.Val = level
+	tree.Set(nil, v, tree.NewInt(3))
+	if level.IntVal != 1 || v.Val.IntVal != 3 {
+		t.Fatalf("set should only change the var, level %d, var %d", level.IntVal, v.Val.IntVal)
+	}
+	tree.Set(nil, v, tree.NewInt(4))
+	if level.IntVal != 1 || v.Val.IntVal != 4 {
+		t.Fatalf("set should only change the var, level %d, var %d", level.IntVal, v.Val.IntVal)
+	}
+}
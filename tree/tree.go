@@ -3,20 +3,32 @@ package tree
 import (
 	"fmt"
 	"io"
-	"os"
+	"rips/rips/diag"
+	"rips/rips/extern"
 	"rips/rips/lex"
+	"rips/rips/types"
+	"time"
 )
 
 type Prog struct {
-	Env       Env //global variables, kept for execution, see PushVars
-	Levels    []*Sym
-	Decls     []*Decl
-	RuleSects []*RuleSect
+	Env         Env //global variables, kept for execution, see PushVars
+	Levels      []*Sym
+	Transitions extern.Transitions //nil if there is no transitions section
+	Warnings    []*diag.Diagnostic //found while compiling, see Lint
+	Sources     diag.Sources       //the text of the program for the warnings, nil if unknown
+	Decls       []*Decl
+	Funcs       []*Sym //user functions, in order of declaration
+	RuleSects   []*RuleSect
+	Code        *Code //of the rules, for the VM, see Compile
+	Plain       bool  //not reordered nor indexed by topic, see Fold and IndexTopics
 }
 
 type RuleSect struct {
-	SectId *Sym
-	Rules  []*Rule
+	SectId  *Sym
+	IsFirst bool          //only the first rule activated is run (rules Msg first:)
+	Period  time.Duration //of the Timer sections, 0 for the rest
+	Rules   []*Rule
+	Index   *TopicIndex //nil if the rules are not dispatched by topic
 }
 
 func (envs *StkEnv) NewRuleSect(name string, pos lex.Position) (rs *RuleSect, err error) {
@@ -28,6 +40,37 @@ func (envs *StkEnv) NewRuleSect(name string, pos lex.Position) (rs *RuleSect, er
 	return &RuleSect{SectId: id, Rules: nil}, nil
 }
 
+// Timer sections are named by their period, see extern.Dispatcher
+func (envs *StkEnv) NewTimerSect(period time.Duration, pos lex.Position) (rs *RuleSect, err error) {
+	if period <= 0 || period%extern.TimerResolution != 0 {
+		return nil, fmt.Errorf("timer period %s should be a positive multiple of %s", period, extern.TimerResolution)
+	}
+	rs, err = envs.NewRuleSect(extern.TimerName(period), pos)
+	if err != nil {
+		return nil, err
+	}
+	rs.Period = period
+	return rs, nil
+}
+
+// periods of the Timer sections, for the dispatcher
+func (p *Prog) Timers() (periods []time.Duration) {
+	for _, rs := range p.RuleSects {
+		if rs.Period != 0 {
+			periods = append(periods, rs.Period)
+		}
+	}
+	return periods
+}
+
+// The quiet periods of the soft levels, by level, see extern.Deescalate
+func (p *Prog) Afters() (afters []time.Duration) {
+	for _, ls := range p.Levels {
+		afters = append(afters, ls.After)
+	}
+	return afters
+}
+
 func NewProg() (prog *Prog) {
 	return &Prog{}
 }
@@ -49,31 +92,56 @@ func (p *Prog) AddDecl(decl *Decl) {
 	p.Decls = append(p.Decls, decl)
 }
 
+func (p *Prog) AddFunc(f *Sym) {
+	f.assertype(SFunc)
+	p.Funcs = append(p.Funcs, f)
+}
+
+func (f *Sym) AddParam(param *Sym) {
+	f.Params = append(f.Params, param)
+	f.ArgDataTypes = append(f.ArgDataTypes, param.DataType)
+}
+
 type Rule struct {
-	Pos     lex.Position
-	Expr    *Sym
-	Actions []*Action
+	Pos        lex.Position //of the '?'
+	Start, End lex.Position //of the condition
+	Label      *Sym         //nil if the rule has no name
+	Expr       *Sym
+	Actions    []*Action
+	Reordered  string //the condition before reorder, "" if the same
+
+	pc int //of Expr in Prog.Code
 }
 
 func (r *Rule) Errorf(errout io.Writer, nerr int, str string, v ...interface{}) {
 	if nerr >= 1 {
 		return
 	}
-	place := fmt.Sprintf("%s:%d: ", r.Pos.File, r.Pos.Line)
-	out := errout
-	if lex.DOut {
-		out = os.Stderr
+	if r.Start.Line == 0 {
+		diag.Errorf(errout, r.Pos, lex.Position{}, str, v...)
+		return
 	}
-	fmt.Fprintf(out, place+str+"\n", v...)
+	diag.Errorf(errout, r.Start, r.End, str, v...)
 }
 
 type Action struct {
 	Con  lex.TokType //connector => and so on
 	What *Sym        //can only be an fcall for now (maybe assign later)
+
+	pc int //of What in Prog.Code
 }
 
 func NewRule(p lex.Position, expr *Sym) (rule *Rule) {
-	return &Rule{p, expr, nil}
+	return &Rule{Pos: p, Expr: expr}
+}
+
+// Rule labels, also for the references to them, see Prog.checkLabels
+func NewLabel(name string, pos lex.Position) (label *Sym) {
+	label = NewAnonSym(SRule)
+	label.Name = name
+	label.Pos = pos
+	label.DataType = types.RuleType
+	return label
 }
 
 func (r *RuleSect) AddRule(rule *Rule) {
@@ -84,7 +152,7 @@ func (p *Prog) AddRuleSect(sect *RuleSect) {
 }
 
 func NewAction(expr *Sym, con lex.TokType) (action *Action) {
-	return &Action{con, expr}
+	return &Action{Con: con, What: expr}
 }
 
 func (r *Rule) AddAction(a *Action) {
@@ -161,6 +229,13 @@ func NewExpr(left *Sym, right *Sym) (expr *Sym) {
 	return expr
 }
 
+// set literal, the elements are added with AddArg
+func NewSetExpr() (expr *Sym) {
+	expr = NewAnonSym(SSet)
+	expr.Expr = &Expr{}
+	return expr
+}
+
 func (expr *Sym) AddFCall(f *Sym) {
 	e := expr.Expr
 	if e == nil {
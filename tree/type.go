@@ -20,12 +20,21 @@ func (p *Prog) TypeCheck(errout io.Writer) (nerr int) {
 	for _, ls := range p.Levels {
 		ls.Annotate()
 	}
+	nerr += p.checkLabels(errout)
+	nerr += p.bindCountIfs(errout)
+	for _, f := range p.Funcs {
+		nerr += f.FuncTypeCheck(errout)
+	}
 	for _, decl := range p.Decls {
+		wasused := decl.LVal.IsUsed //consts used in funcs
 		decl.LVal.Annotate()
 		if decl.LVal != nil {
-			decl.LVal.IsUsed = false
+			decl.LVal.IsUsed = decl.LVal.SType == SConst && wasused
 		}
 		decl.RVal.Annotate()
+		if decl.LVal != nil {
+			decl.RVal.adaptIntLit(decl.LVal.DataType.TVal)
+		}
 		nerr += decl.TypeCheck(errout)
 	}
 	for _, rs := range p.RuleSects {
@@ -38,6 +47,10 @@ func (p *Prog) TypeCheck(errout io.Writer) (nerr int) {
 		if s.Name == "Message" {
 			te = types.MsgGraphType.TExpr
 		}
+		if rs.Period != 0 {
+			//no message, like External
+			te, ok = types.TypeExprs[types.TEExternal], true
+		}
 		if !ok {
 			s.Errorf(errout, nerr, "unknown secid type %s", s.Name)
 			nerr++
@@ -103,6 +116,15 @@ func (decl *Decl) TypeCheck(errout io.Writer) (nerr int) {
 	rval := decl.RVal
 	rdt := rval.DataType
 	ldt := lval.DataType
+	if ldt.TVal.IsMap() {
+		//maps start empty
+		isempty := rval.SType == SSet && len(rval.Expr.Args) == 0
+		if lval.SType != SVar || !isempty {
+			lval.Errorf(errout, nerr, "%t should be a variable initialized to {}\n", (*USym)(lval))
+			nerr++
+		}
+		return nerr
+	}
 	if rdt.IsTypeUndef() {
 		lval.Errorf(errout, nerr, "type error in initializer expression %s of type %s\n",
 			(*USym)(rval), rval.DataType)
@@ -116,6 +138,174 @@ func (decl *Decl) TypeCheck(errout io.Writer) (nerr int) {
 	return nerr
 }
 
+// Rule labels are unique in the program, the references
+// to them (arguments of enable, disable...) are resolved here.
+func (p *Prog) checkLabels(errout io.Writer) (nerr int) {
+	labels := make(map[string]*Sym)
+	var exprs []*Sym
+	for _, f := range p.Funcs {
+		exprs = append(exprs, f.Body)
+	}
+	for _, rs := range p.RuleSects {
+		for _, r := range rs.Rules {
+			exprs = append(exprs, r.Expr)
+			for _, a := range r.Actions {
+				exprs = append(exprs, a.What)
+			}
+			l := r.Label
+			if l == nil {
+				continue
+			}
+			if l2, ok := labels[l.Name]; ok {
+				l.Errorf(errout, 0, "rule %s already declared at %s", l.Name, l2.Pos)
+				nerr++
+				continue
+			}
+			labels[l.Name] = l
+		}
+	}
+	for _, e := range exprs {
+		for _, c := range e.calls() {
+			for i, a := range c.Expr.Args {
+				if a.SType != SRule {
+					continue
+				}
+				l, ok := labels[a.Name]
+				if !ok {
+					a.Errorf(errout, 0, "undeclared rule %s", a.Name)
+					nerr++
+					continue
+				}
+				c.Expr.Args[i] = l
+			}
+		}
+	}
+	return nerr
+}
+
+// countif counts for its rule, the rule is added as
+// a hidden string argument, the label or the position.
+func (p *Prog) bindCountIfs(errout io.Writer) (nerr int) {
+	for _, f := range p.Funcs {
+		for _, c := range f.Body.calls() {
+			if c.Name == "countif" {
+				c.Errorf(errout, 0, "countif can only be called in a rule")
+				nerr++
+			}
+		}
+	}
+	for _, rs := range p.RuleSects {
+		for _, r := range rs.Rules {
+			key := fmt.Sprintf("%s:%d", r.Pos.File, r.Pos.Line)
+			if r.Label != nil {
+				key = r.Label.Name
+			}
+			exprs := []*Sym{r.Expr}
+			for _, a := range r.Actions {
+				exprs = append(exprs, a.What)
+			}
+			for _, e := range exprs {
+				for _, c := range e.calls() {
+					if c.Name != "countif" {
+						continue
+					}
+					if len(c.Expr.Args) != 1 {
+						c.Errorf(errout, 0, "countif takes one argument, the window")
+						nerr++
+						continue
+					}
+					c.Expr.Args = append(c.Expr.Args, NewString(key))
+				}
+			}
+		}
+	}
+	return nerr
+}
+
+// function calls in the expression
+func (s *Sym) calls() (fcalls []*Sym) {
+	if s == nil || s.Expr == nil {
+		return nil
+	}
+	if s.SType == SFCall && s.Expr.FCall != nil {
+		fcalls = append(fcalls, s)
+	}
+	for _, a := range s.Expr.Args {
+		fcalls = append(fcalls, a.calls()...)
+	}
+	fcalls = append(fcalls, s.Expr.ELeft.calls()...)
+	fcalls = append(fcalls, s.Expr.ERight.calls()...)
+	return fcalls
+}
+
+// f calls g, directly or through other user functions
+func (f *Sym) callsFunc(g *Sym, seen map[*Sym]bool) bool {
+	if !f.isUserFunc() || seen[f] {
+		return false
+	}
+	seen[f] = true
+	for _, c := range f.Body.calls() {
+		if c.Expr.FCall == g || c.Expr.FCall.callsFunc(g, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// the type of expression (section) of all the subexpressions,
+// undef if they cannot be in the same section
+func (s *Sym) exprSect() (te *types.TypeExpr) {
+	te = s.DataType.TExpr
+	if s.SType == SVar || s.Expr == nil {
+		return te
+	}
+	for _, a := range s.Expr.Args {
+		te = te.Join(a.exprSect())
+	}
+	if s.Expr.ELeft != nil {
+		te = te.Join(s.Expr.ELeft.exprSect())
+	}
+	if s.Expr.ERight != nil {
+		te = te.Join(s.Expr.ERight.exprSect())
+	}
+	return te
+}
+
+// User functions are pure, they cannot call actions (or themselves).
+// The type of expression (section) of a function is the one of its body,
+// the calls are checked in the section of the rule.
+func (f *Sym) FuncTypeCheck(errout io.Writer) (nerr int) {
+	body := f.Body
+	for _, c := range body.calls() {
+		fc := c.Expr.FCall
+		if fc.IsAction {
+			c.Errorf(errout, nerr, "function %s cannot call action %s\n", f.Name, (*USym)(c))
+			nerr++
+		}
+		if fc == f || fc.callsFunc(f, map[*Sym]bool{}) {
+			c.Errorf(errout, nerr, "function %s cannot be recursive, calls %s\n", f.Name, (*USym)(c))
+			nerr++
+		}
+	}
+	if nerr > 0 {
+		return nerr
+	}
+	body.Annotate()
+	nerr += body.TypeCheck(errout, types.UnivType)
+	if body.DataType.IsTypeUndef() || !body.DataType.TVal.IsTypeValCompat(f.DataType.TVal) {
+		f.Errorf(errout, nerr, "%t incompatible with return type %s of function %s\n",
+			(*USym)(body), f.DataType.TVal, f.Name)
+		nerr++
+		return nerr
+	}
+	f.DataType.TExpr = body.exprSect()
+	if f.DataType.TExpr == types.TypeExprs[types.TEUndef] {
+		f.Errorf(errout, nerr, "function %s mixes expressions of different sections\n", f.Name)
+		nerr++
+	}
+	return nerr
+}
+
 // only for a builtin variable
 func (s *Sym) varDeref() (sv *Sym) {
 	sv = s
@@ -125,6 +315,14 @@ func (s *Sym) varDeref() (sv *Sym) {
 	return sv
 }
 
+// the variable set by set(s, ...), the map for set(m[key], ...)
+func (s *Sym) lval() *Sym {
+	if s.isIndex() {
+		return s.Expr.ELeft
+	}
+	return s
+}
+
 func (s *Sym) islevel() bool {
 	s = s.varDeref()
 	return s.SType != SLevel
@@ -141,7 +339,7 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 	case SConst:
 		if !t.IsTypeCompat(s.DataType) {
 			s.Errorf(errout, nerr, "%t (incorrect) in section type  %s\n",
-				(*USym)(s), s.DataType, t)
+				(*USym)(s), t)
 			nerr++
 		}
 		return
@@ -176,6 +374,9 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 			if i < len(argst) {
 				argt = argst[i]
 			}
+			if numBuiltins[s.Name] && isNumVal(expr.Args[0].DataType.TVal) {
+				argt.TVal = expr.Args[0].DataType.TVal //see Annotate
+			}
 			nerr += expr.Args[i].TypeCheck(errout, t)
 			if expr.Args[i].DataType.IsTypeUndef() || !t.IsTypeCompat(expr.Args[i].DataType) {
 				s.Errorf(errout, nerr, "arg %t of %s of incorrect type %s in section type  %s\n",
@@ -190,11 +391,17 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 			}
 		}
 		//special check for set
-		if s.Name == "set" {
+		if s.Name == "set" || s.Name == "setfor" {
 			if s.DataType.IsTypeUndef() || !t.IsTypeCompat(s.DataType) {
-				if expr.Args[0].SType != SVar {
+				if expr.Args[0].lval().SType != SVar {
 					s.Errorf(errout, nerr, "%s: lval %t is not a variable\n",
 						(*USym)(s), (*USym)(expr.Args[0]))
+				} else if s.Name == "setfor" && expr.Args[0].isIndex() {
+					s.Errorf(errout, nerr, "%s: %t is an element of a map, only variables expire\n",
+						(*USym)(s), (*USym)(expr.Args[0]))
+				} else if expr.Args[0].DataType.TVal.IsMap() {
+					s.Errorf(errout, nerr, "%s: cannot set map %t, set its elements\n",
+						(*USym)(s), (*USym)(expr.Args[0]))
 				} else {
 					s.Errorf(errout, nerr, "%s: lval %t rval %t in section type %s\n",
 						(*USym)(s), (*USym)(expr.Args[0]), (*USym)(expr.Args[1]), t)
@@ -206,6 +413,22 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 				break //skip next test
 			}
 		}
+		//special check for ttl
+		if s.Name == "ttl" {
+			if expr.Args[0].SType != SVar || expr.Args[0].IsBuiltin {
+				s.Errorf(errout, nerr, "%s: %t is not a variable that can expire\n", (*USym)(s), (*USym)(expr.Args[0]))
+				nerr++
+				break //skip next test
+			}
+		}
+		//special check for haskey and delete
+		if s.Name == "haskey" || s.Name == "delete" {
+			if !expr.Args[0].DataType.TVal.IsMap() {
+				s.Errorf(errout, nerr, "%t is not a map\n", (*USym)(expr.Args[0]))
+				nerr++
+				break //skip next test
+			}
+		}
 		//special check for trigger and levelname
 		if s.Name == "trigger" || s.Name == "levelname" {
 			if s.DataType.IsTypeUndef() || expr.Args[0].islevel() {
@@ -252,7 +475,7 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 	case SUnary:
 		re := expr.ERight
 		if s.DataType.TVal == types.TypeVals[types.TVString] {
-			s.Errorf(errout, nerr, "%t no unary operators for strings %s\n", (*USym)(s))
+			s.Errorf(errout, nerr, "%t no unary operators for strings\n", (*USym)(s))
 			nerr++
 		}
 		nerr += re.TypeCheck(errout, t)
@@ -260,7 +483,15 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 			s.Errorf(errout, nerr, "%t in section type %s\n", (*USym)(s), t)
 			nerr++
 		}
-	case SLevel, SSect:
+	case SSet:
+		for _, a := range expr.Args {
+			nerr += a.TypeCheck(errout, t)
+		}
+		if s.DataType.IsTypeUndef() {
+			s.Errorf(errout, nerr, "%t elements should be strings\n", (*USym)(s))
+			nerr++
+		}
+	case SLevel, SSect, SRule:
 		//nothing
 	case SNone:
 		s.Errorf(errout, 0, "symbol should not be here %s\n", (*USym)(s))
@@ -272,6 +503,63 @@ func (s *Sym) TypeCheck(errout io.Writer, t types.Type) (nerr int) {
 	return nerr
 }
 
+func isIntOrUint(tvp *types.TypeVal) bool {
+	return tvp == types.TypeVals[types.TVInt] || tvp == types.TypeVals[types.TVUint]
+}
+
+// An int or uint expression made only of literals
+func (s *Sym) isIntLit() bool {
+	if s == nil || !isIntOrUint(s.DataType.TVal) {
+		return false
+	}
+	switch s.SType {
+	case SConst:
+		return s.Name == "lit" //not a named constant, see NewAnonSym
+	case SBinary:
+		return s.Expr.ELeft.isIntLit() && s.Expr.ERight.isIntLit()
+	case SUnary:
+		return s.Expr.ERight.isIntLit()
+	}
+	return false
+}
+
+// the literals fit in tvp (they are all non negative)
+func (s *Sym) fitsIntLit(tvp *types.TypeVal) bool {
+	switch s.SType {
+	case SConst:
+		return s.IntVal >= 0
+	case SBinary:
+		return s.Expr.ELeft.fitsIntLit(tvp) && s.Expr.ERight.fitsIntLit(tvp)
+	case SUnary:
+		negu := s.Expr.Op == '-' && tvp == types.TypeVals[types.TVUint]
+		return !negu && s.Expr.ERight.fitsIntLit(tvp)
+	}
+	return false
+}
+
+func (s *Sym) setIntLit(tvp *types.TypeVal) {
+	s.DataType.TVal = tvp
+	switch s.SType {
+	case SBinary:
+		s.Expr.ELeft.setIntLit(tvp)
+		op := lex.TokType(s.Expr.Op)
+		if op != lex.TokLShift && op != lex.TokRShift {
+			s.Expr.ERight.setIntLit(tvp)
+		}
+	case SUnary:
+		s.Expr.ERight.setIntLit(tvp)
+	}
+}
+
+// Integer literals (and expressions of them) take the int or uint
+// type of the other operand if the values fit, like untyped constants in go.
+func (s *Sym) adaptIntLit(tvp *types.TypeVal) {
+	if !isIntOrUint(tvp) || !s.isIntLit() || s.DataType.TVal == tvp || !s.fitsIntLit(tvp) {
+		return
+	}
+	s.setIntLit(tvp)
+}
+
 func dprintf(str string, v ...interface{}) {
 	if !DTypeErr {
 		return
@@ -286,14 +574,11 @@ func (s *Sym) Annotate() {
 	expr := s.Expr
 	switch s.SType {
 	case SLevel:
-		if s.SLevel == 0 {
-			s.IsReach = true //the init level is always reachable
-		}
 		s.DataType = types.IntType
 	case SFunc:
 		panic("no annotation for function")
 	case SConst:
-		//nothing to do here
+		s.IsUsed = true //for the named ones, see Lint
 		return
 	case SFCall:
 		if expr == nil || expr.FCall == nil {
@@ -323,13 +608,42 @@ func (s *Sym) Annotate() {
 			}
 			if s.Name == "set" {
 				if i == 0 {
-					wasused = expr.Args[i].IsUsed //save for later
+					wasused = expr.Args[i].lval().IsUsed //save for later
 				}
 				if i != 0 && expr.Args[0] == expr.Args[i] {
 					wasused = true //save for later
 				}
 			}
 			expr.Args[i].Annotate()
+			if (s.Name == "set" || s.Name == "setfor") && i == 1 {
+				expr.Args[i].adaptIntLit(expr.Args[0].DataType.TVal)
+				if isInt(expr.Args[0]) && expr.Args[i].isClock() {
+					expr.Args[i] = expr.Args[i].clockInt(s)
+				}
+			}
+			if expr.FCall.isSetFunc() && i == len(argst)-1 && len(expr.Args) == len(argst) {
+				//the variadic strings may be given as a set
+				if expr.Args[i].DataType.TVal == types.TypeVals[types.TVSet] {
+					at.TVal = types.TypeVals[types.TVSet]
+				}
+			}
+			if s.Name == "len" && expr.Args[i].DataType.TVal == types.TypeVals[types.TVString] {
+				//len is also the length of a string
+				at.TVal = types.TypeVals[types.TVString]
+			}
+			if s.Name == "len" && expr.Args[i].DataType.TVal.IsMap() {
+				//and the number of keys of a map
+				at.TVal = expr.Args[i].DataType.TVal
+			}
+			if numBuiltins[s.Name] && isNumVal(expr.Args[0].DataType.TVal) {
+				at.TVal = expr.Args[0].DataType.TVal
+				if s.Name != "float" && s.Name != "int" && s.Name != "uint" {
+					s.DataType.TVal = at.TVal
+				}
+			}
+			if s.Name != "set" && (s.Name != "setfor" || i > 1) {
+				expr.Args[i].adaptIntLit(at.TVal)
+			}
 			if !expr.Args[i].DataType.IsTypeCompat(at) {
 				dprintf("sfcall is not type compat %s %s\n", expr.Args[i].DataType, at)
 				expr.Args[i].DataType.TExpr = types.TypeExprs[types.TVUndef]
@@ -337,18 +651,24 @@ func (s *Sym) Annotate() {
 			}
 		}
 		//special check for set
-		if s.Name == "set" {
+		if s.Name == "set" || s.Name == "setfor" {
 			arg0 := expr.Args[0]
-			if arg0.SType != SVar || !arg0.DataType.IsTypeCompat(expr.Args[1].DataType) {
+			lval := arg0.lval()
+			ismap := arg0.DataType.TVal.IsMap() //the elements are set, not the map
+			isindex := s.Name == "setfor" && arg0.isIndex()
+			if lval.SType != SVar || ismap || isindex || !arg0.DataType.IsTypeCompat(expr.Args[1].DataType) {
 				dprintf("sfcall set, lval != rval type\n")
 				s.DataType.TExpr = types.TypeExprs[types.TVUndef]
 			} else {
-				arg0.IsSet = true
-				arg0.IsUsed = wasused
+				lval.IsSet = true
+				lval.IsUsed = wasused
+				if s.Name == "setfor" {
+					lval.IsUsed = true //the declared value is restored
+				}
 			}
 		}
 		if s.Name == "trigger" && expr.Args[0].SType == SLevel {
-			expr.Args[0].IsReach = true
+			expr.Args[0].IsTriggered = true
 		}
 	case SVar:
 		s.IsUsed = true
@@ -362,6 +682,16 @@ func (s *Sym) Annotate() {
 		re := expr.ERight
 		le.Annotate()
 		re.Annotate()
+		op := lex.TokType(expr.Op)
+		if op != lex.TokLShift && op != lex.TokRShift {
+			if le.isIntLit() {
+				le.adaptIntLit(re.DataType.TVal)
+			} else {
+				re.adaptIntLit(le.DataType.TVal)
+			}
+		}
+		expr.ELeft, expr.ERight = clockInts(s, le, re)
+		le, re = expr.ELeft, expr.ERight
 		islecomp := le.DataType.IsCompat(&re.DataType, expr.Op)
 		if !islecomp || le.DataType.IsTypeUndef() || re.DataType.IsTypeUndef() {
 			dprintf("sbinary not compat\n")
@@ -369,6 +699,9 @@ func (s *Sym) Annotate() {
 			break
 		}
 		s.DataType = le.DataType
+		if tvp, ismixed := le.DataType.MixedOp(re.DataType, expr.Op); ismixed {
+			s.DataType.TVal = tvp
+		}
 		if isbool, _ := isCompOp[lex.TokType(expr.Op)]; isbool {
 			s.DataType.TVal = types.TypeVals[types.TVBool]
 		}
@@ -389,9 +722,20 @@ func (s *Sym) Annotate() {
 			break
 		}
 		s.DataType = re.DataType
+	case SSet:
+		s.DataType = types.SetType
+		for _, a := range expr.Args {
+			a.Annotate()
+			if !a.DataType.IsTypeCompat(types.StringType) || a.DataType.IsTypeUndef() {
+				dprintf("sset element is not a string %s\n", a.DataType)
+				s.DataType.TExpr = types.TypeExprs[types.TVUndef]
+			}
+		}
 	case SSect:
 		//mark so it is not used as ID
 		s.DataType = types.UndefType
+	case SRule:
+		//typed when created, see NewLabel
 	case SNone:
 	default:
 		errs := fmt.Sprintf("not a value %s", s)
@@ -8,13 +8,14 @@ import (
 	"time"
 )
 
-func (envs *StkEnv) NewIntVar(name string, intval int64) (s *Sym, err error) {
-	s, err = envs.NewVar(name, types.TypeVals[types.TVInt])
+// for the types kept in IntVal: int, duration and time
+func (envs *StkEnv) NewIntVar(name string, tvp *types.TypeVal, intval int64) (s *Sym, err error) {
+	s, err = envs.NewVar(name, tvp)
 	if err != nil {
 		return nil, fmt.Errorf("cannot declare predefined %s", name)
 	}
 	val := NewAnonSym(SConst)
-	val.DataType = types.IntType
+	val.DataType = types.Type{TVal: tvp, TExpr: types.TypeExprs[types.TEExpr]}
 	val.IntVal = intval
 	s.Val = val
 	return s, nil
@@ -22,7 +23,7 @@ func (envs *StkEnv) NewIntVar(name string, intval int64) (s *Sym, err error) {
 
 // Create predefined variables for parser
 func (envs *StkEnv) PredefVars() {
-	s, err := envs.NewIntVar("CurrLevel", -1)
+	s, err := envs.NewIntVar("CurrLevel", types.TypeVals[types.TVInt], -1)
 	if err != nil {
 		panic(err)
 	}
@@ -30,14 +31,14 @@ func (envs *StkEnv) PredefVars() {
 	s.IsBuiltin = true
 	s.IsSet = true
 	s.IsUsed = true
-	s, err = envs.NewIntVar("Time", 0)
+	s, err = envs.NewIntVar("Time", types.TypeVals[types.TVTime], 0)
 	if err != nil {
 		panic(err)
 	}
 	s.IsBuiltin = true
 	s.IsSet = true
 	s.IsUsed = true
-	s, err = envs.NewIntVar("Uptime", 0)
+	s, err = envs.NewIntVar("Uptime", types.TypeVals[types.TVDuration], 0)
 	if err != nil {
 		panic(err)
 	}
@@ -46,6 +47,46 @@ func (envs *StkEnv) PredefVars() {
 	s.IsUsed = true
 }
 
+// Time and Uptime were int nanoseconds before there were times and
+// durations. Mixed with an int (Uptime > 30000000000, set(n, Time))
+// they are still converted to one, see clockInts.
+var clockConv = &Sym{SType: SFunc, Name: "int", DataType: types.IntType,
+	Func: Func{ArgDataTypes: []types.Type{types.UnivType}, Fn: Int}}
+
+func (s *Sym) isClock() bool {
+	return s.SType == SVar && s.IsBuiltin && (s.Name == "Time" || s.Name == "Uptime")
+}
+
+// int(s), for the predefined var s in the expression at
+// (the var itself has no position), see Lint
+func (s *Sym) clockInt(at *Sym) *Sym {
+	c := NewAnonSym(SFCall)
+	c.Name = clockConv.Name
+	c.Expr = &Expr{FCall: clockConv, Args: []*Sym{s}}
+	c.DataType = types.IntType
+	c.Pos, c.End = at.Pos, at.End
+	return c
+}
+
+func isInt(s *Sym) bool {
+	return s.DataType.TVal == types.TypeVals[types.TVInt]
+}
+
+// the operands of the binary expression s, the predefined var
+// as an int if it is incompatible with the other one, an int
+func clockInts(s *Sym, le *Sym, re *Sym) (*Sym, *Sym) {
+	if le.DataType.IsCompat(&re.DataType, s.Expr.Op) {
+		return le, re
+	}
+	if le.isClock() && isInt(re) {
+		return le.clockInt(s), re
+	}
+	if re.isClock() && isInt(le) {
+		return le, re.clockInt(s)
+	}
+	return le, re
+}
+
 // Create vars. CurrLevel < 0 means first time initialization
 func (execEnvs *StkEnv) SetPredefVars(p *Prog, context *extern.Ctx) (err error) {
 	isinit := false
@@ -56,7 +97,9 @@ func (execEnvs *StkEnv) SetPredefVars(p *Prog, context *extern.Ctx) (err error)
 	if s == nil {
 		return errors.New("cannot find CurrLevel")
 	}
-	s.Val.IntVal = context.CurrLevel
+	if !isinit && int64(s.Val.SLevel) != context.CurrLevel && context.CurrLevel < int64(len(p.Levels)) {
+		s.Val = p.Levels[context.CurrLevel] //changed by the dispatcher, see extern.Deescalate
+	}
 	if isinit {
 		s.Val = p.Levels[0]
 		if s.Val == nil {
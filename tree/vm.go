@@ -0,0 +1,541 @@
+package tree
+
+import (
+	"fmt"
+	"rips/rips/extern"
+	"rips/rips/lex"
+	"rips/rips/types"
+)
+
+// The rules are compiled (after Fold) to Code, the instructions
+// of a stack machine, the VM. The vars are resolved to slots when
+// compiling and the slots to the syms of the execution env once, in
+// NewVM, instead of looking them up by name for every expression.
+// The VM evaluates like EvalExpr, the results are the same.
+
+type Opcode uint8
+
+const (
+	OpEnd      Opcode = iota // end of the expression, pops the result
+	OpConst                  // push a copy of the constant S
+	OpSym                    // push S itself (levels, rules, regexps...)
+	OpVar                    // push a copy of the value of the var in slot A
+	OpLVal                   // push the var in slot A (first arg of set, setfor...)
+	OpParam                  // push a copy of the arg A of the user function
+	OpUndef                  // push undef
+	OpArg                    // if the top is undef pop it and A more, push undef, jump to B
+	OpIfUndef                // if the top is undef drop A below it, jump to B
+	OpCall                   // call the builtin S with A args, B is 1 for math ones, 2 for int or uint of a float
+	OpCallUser               // call the user function S with A args, its code is at B
+	OpRet                    // return from the user function
+	OpLeft                   // left operand of S, jump to B if undef or short circuit
+	OpBin                    // right operand of S, evaluate it
+	OpUnary                  // operand of S, evaluate it
+	OpMap                    // map of the index S, if it is not a map jump to B
+	OpIndex                  // m[key] of the type of S
+	OpSetIndex               // set(m[key], v)
+	OpSet                    // set literal with A elements
+	OpSetElem                // add an element to the set, if it is undef jump to B
+)
+
+var opNames = [...]string{
+	OpEnd:      "end",
+	OpConst:    "const",
+	OpSym:      "sym",
+	OpVar:      "var",
+	OpLVal:     "lval",
+	OpParam:    "param",
+	OpUndef:    "undef",
+	OpArg:      "arg",
+	OpIfUndef:  "ifundef",
+	OpCall:     "call",
+	OpCallUser: "calluser",
+	OpRet:      "ret",
+	OpLeft:     "left",
+	OpBin:      "bin",
+	OpUnary:    "unary",
+	OpMap:      "map",
+	OpIndex:    "index",
+	OpSetIndex: "setindex",
+	OpSet:      "set",
+	OpSetElem:  "setelem",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op%d", op)
+}
+
+type Instr struct {
+	Op Opcode
+	A  int
+	B  int
+	S  *Sym
+}
+
+func (in Instr) String() string {
+	s := fmt.Sprintf("%s %d %d", in.Op, in.A, in.B)
+	if in.S != nil {
+		s += fmt.Sprintf(" %s", (*USym)(in.S))
+	}
+	return s
+}
+
+type Code struct {
+	Instrs []Instr
+	Vars   []string //names of the vars of the slots
+
+	slots map[string]int
+	funcs map[*Sym]int //where the code of the user functions starts
+}
+
+func (c *Code) String() (s string) {
+	s += "Slots:\n"
+	for i, name := range c.Vars {
+		s += fmt.Sprintf("\t%d %s\n", i, name)
+	}
+	s += "Code:\n"
+	for pc, in := range c.Instrs {
+		s += fmt.Sprintf("\t%04d %s\n", pc, in)
+	}
+	return s
+}
+
+func (c *Code) emit(op Opcode, a int, b int, s *Sym) (pc int) {
+	c.Instrs = append(c.Instrs, Instr{Op: op, A: a, B: b, S: s})
+	return len(c.Instrs) - 1
+}
+
+// the jump of the instruction at pc goes to the next one emitted
+func (c *Code) patch(pc int) {
+	c.Instrs[pc].B = len(c.Instrs)
+}
+
+func (c *Code) slot(name string) int {
+	if n, ok := c.slots[name]; ok {
+		return n
+	}
+	c.slots[name] = len(c.Vars)
+	c.Vars = append(c.Vars, name)
+	return len(c.Vars) - 1
+}
+
+// Compile generates the code of the conditions and actions of
+// the rules and of the user functions they call
+func (p *Prog) Compile() *Code {
+	c := &Code{slots: map[string]int{}, funcs: map[*Sym]int{}}
+	for _, rs := range p.RuleSects {
+		for _, r := range rs.Rules {
+			r.pc = c.compileExpr(r.Expr)
+			for _, a := range r.Actions {
+				a.pc = c.compileExpr(a.What)
+			}
+		}
+	}
+	var fns []*Sym
+	for _, in := range c.Instrs {
+		if in.Op == OpCallUser {
+			fns = append(fns, in.S)
+		}
+	}
+	for i := 0; i < len(fns); i++ {
+		f := fns[i]
+		if _, ok := c.funcs[f]; ok {
+			continue
+		}
+		params := map[string]int{}
+		for j, param := range f.Params {
+			params[param.Name] = j
+		}
+		start := len(c.Instrs)
+		c.funcs[f] = start
+		c.compile(f.Body, params)
+		c.emit(OpRet, 0, 0, nil)
+		for _, in := range c.Instrs[start:] {
+			if in.Op == OpCallUser {
+				fns = append(fns, in.S)
+			}
+		}
+	}
+	for pc, in := range c.Instrs {
+		if in.Op == OpCallUser {
+			c.Instrs[pc].B = c.funcs[in.S]
+		}
+	}
+	p.Code = c
+	return c
+}
+
+func (c *Code) compileExpr(s *Sym) (pc int) {
+	pc = len(c.Instrs)
+	c.compile(s, nil)
+	c.emit(OpEnd, 0, 0, nil)
+	return pc
+}
+
+// the code of s leaves its value on the stack, params are
+// the ones of the user function compiled, nil for the rules
+func (c *Code) compile(s *Sym, params map[string]int) {
+	switch s.SType {
+	case SConst:
+		c.emit(OpConst, 0, 0, s)
+	case SVar:
+		if n, ok := params[s.Name]; ok {
+			c.emit(OpParam, n, 0, nil)
+			break
+		}
+		c.emit(OpVar, c.slot(s.Name), 0, nil)
+	case SFCall:
+		c.compileCall(s, params)
+	case SBinary:
+		if s.isIndex() {
+			c.compile(s.Expr.ELeft, params)
+			ismap := c.emit(OpMap, 0, 0, s)
+			c.compile(s.Expr.ERight, params)
+			iskey := c.emit(OpIfUndef, 1, 0, nil)
+			c.emit(OpIndex, 0, 0, s)
+			c.patch(ismap)
+			c.patch(iskey)
+			break
+		}
+		c.compile(s.Expr.ELeft, params)
+		left := c.emit(OpLeft, 0, 0, s)
+		c.compile(s.Expr.ERight, params)
+		c.emit(OpBin, 0, 0, s)
+		c.patch(left)
+	case SUnary:
+		c.compile(s.Expr.ERight, params)
+		c.emit(OpUnary, 0, 0, s)
+	case SSet:
+		c.emit(OpSet, len(s.Expr.Args), 0, nil)
+		var elems []int
+		for _, a := range s.Expr.Args {
+			c.compile(a, params)
+			elems = append(elems, c.emit(OpSetElem, 0, 0, nil))
+		}
+		for _, pc := range elems {
+			c.patch(pc)
+		}
+	case SLevel, SRule, SYara, SRegexp, SNone:
+		c.emit(OpSym, 0, 0, s)
+	default:
+		panic("not a value: " + s.Name)
+	}
+}
+
+func (c *Code) compileCall(s *Sym, params map[string]int) {
+	fn := s.Expr.FCall
+	if fn == nil {
+		c.emit(OpUndef, 0, 0, nil)
+		return
+	}
+	if s.Name == "set" && s.Expr.Args[0].isIndex() {
+		index := s.Expr.Args[0]
+		c.compile(index.Expr.ELeft, params)
+		c.compile(index.Expr.ERight, params)
+		iskey := c.emit(OpIfUndef, 1, 0, nil)
+		c.compile(s.Expr.Args[1], params)
+		isval := c.emit(OpIfUndef, 2, 0, nil)
+		c.emit(OpSetIndex, 0, 0, nil)
+		c.patch(iskey)
+		c.patch(isval)
+		return
+	}
+	n := 0
+	if s.Name == "trigger" {
+		//the var with the current level goes first
+		c.emit(OpLVal, c.slot("CurrLevel"), 0, nil)
+		n++
+	}
+	var undefs []int
+	for i, a := range s.Expr.Args {
+		if (s.Name == "set" || s.Name == "setfor" || s.Name == "ttl") && i == 0 {
+			c.emit(OpLVal, c.slot(a.Name), 0, nil)
+			n++
+			continue
+		}
+		c.compile(a, params)
+		undefs = append(undefs, c.emit(OpArg, n, 0, nil))
+		n++
+	}
+	switch {
+	case fn.isUserFunc():
+		c.emit(OpCallUser, n, 0, fn)
+	case mathBuiltins[s.Name]:
+		c.emit(OpCall, n, 1, s)
+	case s.isFloatConv(s.Expr.Args):
+		c.emit(OpCall, n, 2, s)
+	default:
+		c.emit(OpCall, n, 0, s)
+	}
+	for _, pc := range undefs {
+		c.patch(pc)
+	}
+}
+
+type frame struct {
+	call int //pc of the call, its value is the result
+	base int //of the args in the stack
+}
+
+// VM runs the code of a program with the vars of an execution env.
+// Each instruction leaves its value in its own sym of vals, which
+// is reused every time it runs, so evaluating does not allocate
+// (but for the builtins, which return a new sym). The values are
+// copied when they are kept: set copies the value to the var, the
+// result of a user function is copied to the value of its call.
+type VM struct {
+	prog   *Prog
+	env    *StkEnv
+	code   *Code
+	slots  []*Sym
+	vals   []Sym //by pc
+	stack  []*Sym
+	frames []frame
+	memo   []*Sym //results of the pure builtins in the memo, see memoCall
+	nmemo  int    //used in the current run
+}
+
+// NewVM binds the slots of the code of p (compiled if it was
+// not) to the vars of execEnv, see NewExecEnv
+func (p *Prog) NewVM(execEnv *StkEnv) (vm *VM) {
+	if p.Code == nil {
+		p.Compile()
+	}
+	vm = &VM{prog: p, env: execEnv, code: p.Code}
+	vm.vals = make([]Sym, len(p.Code.Instrs))
+	vm.slots = make([]*Sym, len(p.Code.Vars))
+	for i, name := range p.Code.Vars {
+		vm.slots[i] = execEnv.GetSym(name)
+	}
+	return vm
+}
+
+// Interp runs the rules for the message or timer in context,
+// like Prog.Interp
+func (vm *VM) Interp(context *extern.Ctx) {
+	vm.nmemo = 0 //the memo is emptied by interp
+	vm.prog.interp(context, vm.env, vm)
+}
+
+// memoCall runs the pure builtin f of one string arg, once for
+// the event like call. The results are kept in values of the VM,
+// reused in the next run, so it does not allocate.
+func (vm *VM) memoCall(context *extern.Ctx, f *Sym, args []*Sym) *Sym {
+	fn := func(string) *Sym {
+		if vm.nmemo == len(vm.memo) {
+			vm.memo = append(vm.memo, &Sym{})
+		}
+		val := vm.memo[vm.nmemo]
+		vm.nmemo++
+		*val = undefVal
+		callTyped(context, f.Typed, args, val)
+		return val
+	}
+	return extern.MemoStr(context, f.Name, fn, args[0].StrVal)
+}
+
+func (vm *VM) eval(context *extern.Ctx, s *Sym, pc int) *Sym {
+	return vm.run(context, pc)
+}
+
+func (vm *VM) push(s *Sym) {
+	vm.stack = append(vm.stack, s)
+}
+
+func (vm *VM) pop() (s *Sym) {
+	n := len(vm.stack) - 1
+	s = vm.stack[n]
+	vm.stack[n] = nil
+	vm.stack = vm.stack[:n]
+	return s
+}
+
+func (vm *VM) top() *Sym {
+	return vm.stack[len(vm.stack)-1]
+}
+
+// drop n elements under the top
+func (vm *VM) drop(n int) {
+	top := vm.pop()
+	for i := 0; i < n; i++ {
+		vm.pop()
+	}
+	vm.push(top)
+}
+
+func (vm *VM) variable(slot int) *Sym {
+	v := vm.slots[slot]
+	if v == nil {
+		panic("bad var, cannot happen")
+	}
+	return v
+}
+
+// a value computed, undef, like the ones of EvalExpr
+var undefVal = Sym{Name: "lit", SType: SConst, Pos: lex.Position{File: "Builtin"}, DataType: types.UndefExprType}
+
+// the value of the instruction at pc, undef
+func (vm *VM) newVal(pc int) (val *Sym) {
+	val = &vm.vals[pc]
+	*val = undefVal
+	return val
+}
+
+func (vm *VM) run(context *extern.Ctx, pc int) *Sym {
+	for {
+		in := &vm.code.Instrs[pc]
+		at := pc
+		pc++
+		switch in.Op {
+		case OpEnd:
+			return vm.pop()
+		case OpConst:
+			val := vm.newVal(at)
+			val.CopyValFrom(in.S)
+			vm.push(val)
+		case OpSym:
+			vm.push(in.S)
+		case OpVar:
+			val := vm.newVal(at)
+			*val = *(vm.variable(in.A).Val)
+			vm.push(val)
+		case OpLVal:
+			vm.push(vm.variable(in.A))
+		case OpParam:
+			val := vm.newVal(at)
+			*val = *(vm.stack[vm.frames[len(vm.frames)-1].base+in.A])
+			vm.push(val)
+		case OpUndef:
+			vm.push(vm.newVal(at))
+		case OpArg:
+			if vm.top().DataType.IsTypeUndef() {
+				//the rest are not evaluated, the call is undef
+				vm.stack = vm.stack[:len(vm.stack)-in.A-1]
+				vm.push(vm.newVal(at))
+				pc = in.B
+			}
+		case OpIfUndef:
+			if vm.top().DataType.IsTypeUndef() {
+				vm.drop(in.A)
+				pc = in.B
+			}
+		case OpCall:
+			base := len(vm.stack) - in.A
+			if in.B == 2 {
+				checkConv(context, in.S, vm.stack[base:])
+			}
+			f := in.S.Expr.FCall
+			var val *Sym
+			//the pure ones are memoized, see call
+			args := vm.stack[base:]
+			switch {
+			case f.Typed != nil && !f.IsPure:
+				val = vm.newVal(at)
+				callTyped(context, f.Typed, args, val)
+			case f.Typed != nil && len(args) == 1 && args[0].isMemoStr():
+				val = vm.memoCall(context, f, args)
+			default:
+				val = f.call(context, args)
+			}
+			vm.stack = vm.stack[:base]
+			if in.B == 1 && context != nil && extern.IsBadFloat(val.FloatVal) {
+				extern.CheckFloat(context, in.S.Pos.String(), val.FloatVal)
+			}
+			vm.push(val)
+		case OpCallUser:
+			vm.frames = append(vm.frames, frame{call: at, base: len(vm.stack) - in.A})
+			pc = in.B
+		case OpRet:
+			f := vm.frames[len(vm.frames)-1]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			val := vm.newVal(f.call)
+			*val = *vm.pop()
+			vm.stack = vm.stack[:f.base]
+			vm.push(val)
+			pc = f.call + 1
+		case OpLeft:
+			val := vm.newVal(at)
+			val.CopyValFrom(vm.pop())
+			vm.push(val)
+			//undef does not evaluate the rest, like the generated code
+			isundef := val.DataType.IsTypeUndef()
+			if isundef || in.S.IsOrShort(val) || in.S.IsAndShort(val) {
+				pc = in.B
+			}
+		case OpBin:
+			right := vm.pop()
+			val := vm.top()
+			vm.binary(context, in.S, val, right)
+		case OpUnary:
+			val := vm.newVal(at)
+			val.CopyValFrom(vm.pop())
+			vm.push(val)
+			if val.DataType.IsTypeUndef() {
+				break
+			}
+			val.UnaryExpr(in.S.Expr.Op)
+			if isbool, _ := isCompOp[lex.TokType(in.S.Expr.Op)]; isbool {
+				val.BoolExpr(in.S.Expr.Op)
+			}
+		case OpMap:
+			if m := vm.top(); m.MapVal == nil {
+				//not a map (type error)
+				m.DataType.TVal = types.TypeVals[types.TVUndef]
+				pc = in.B
+			}
+		case OpIndex:
+			key := vm.pop()
+			m := vm.pop()
+			val := vm.newVal(at)
+			val.fromMapVal(m.MapVal.Get(key.StrVal), in.S.DataType.TVal)
+			vm.push(val)
+		case OpSetIndex:
+			v := vm.pop()
+			key := vm.pop()
+			m := vm.pop()
+			m.MapVal.Set(key.StrVal, mapVal(v))
+			val := vm.newVal(at)
+			val.DataType = types.BoolType
+			val.BoolVal = true
+			vm.push(val)
+		case OpSet:
+			val := vm.newVal(at)
+			val.DataType = types.SetType
+			val.StrSetVal = make(extern.StrSet, in.A)
+			vm.push(val)
+		case OpSetElem:
+			elem := vm.pop()
+			val := vm.top()
+			if elem.DataType.IsTypeUndef() {
+				val.DataType = elem.DataType
+				pc = in.B
+				break
+			}
+			val.StrSetVal[elem.StrVal] = true
+		default:
+			panic(fmt.Sprintf("bad instruction %s, cannot happen", in))
+		}
+	}
+}
+
+// val op right for the binary expression s, as in EvalExpr
+func (vm *VM) binary(context *extern.Ctx, s *Sym, val *Sym, right *Sym) {
+	if right.DataType.IsTypeUndef() {
+		val.DataType = right.DataType
+		return
+	}
+	err := val.BinExpr(right, s.Expr.Op)
+	if err != nil && context != nil {
+		context.Printf("%s:%d error evaluating, undefined behaviour: %s\n", s.Pos.File, s.Pos.Line, err)
+		context.Fatal()
+	}
+	if s.isFloatArith() && context != nil && extern.IsBadFloat(val.FloatVal) {
+		extern.CheckFloat(context, s.Pos.String(), val.FloatVal)
+	}
+	if isbool, _ := isCompOp[lex.TokType(s.Expr.Op)]; isbool && !val.DataType.IsTypeUndef() {
+		val.BoolExpr(s.Expr.Op)
+	}
+}
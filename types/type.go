@@ -13,6 +13,18 @@ const (
 	TVFloat
 	TVBool
 	TVString
+	TVSet  //set of string
+	TVRule //rule labels
+	TVDuration
+	TVTime //timestamp
+	TVUint
+	//map[string]T, see mapElems
+	TVMapInt
+	TVMapFloat
+	TVMapBool
+	TVMapString
+	TVMapDuration
+	TVMapTime
 	NTypesVal
 )
 
@@ -21,12 +33,23 @@ type TypeVal struct {
 }
 
 var typeValNames = []string{
-	TVUndef:  "undef",
-	TVUniv:   "univ",
-	TVInt:    "int",
-	TVFloat:  "float",
-	TVBool:   "bool",
-	TVString: "string",
+	TVUndef:       "undef",
+	TVUniv:        "univ",
+	TVInt:         "int",
+	TVFloat:       "float",
+	TVBool:        "bool",
+	TVString:      "string",
+	TVSet:         "set",
+	TVRule:        "rule",
+	TVDuration:    "duration",
+	TVTime:        "time",
+	TVUint:        "uint",
+	TVMapInt:      "map[string]int",
+	TVMapFloat:    "map[string]float",
+	TVMapBool:     "map[string]bool",
+	TVMapString:   "map[string]string",
+	TVMapDuration: "map[string]duration",
+	TVMapTime:     "map[string]time",
 }
 
 func (tvp *TypeVal) String() string {
@@ -37,18 +60,66 @@ func (tvp *TypeVal) String() string {
 }
 
 var TypeVals = []*TypeVal{
-	TVUndef:  {TVUndef},
-	TVUniv:   {TVUniv},
-	TVInt:    {TVInt},
-	TVFloat:  {TVFloat},
-	TVBool:   {TVBool},
-	TVString: {TVString},
+	TVUndef:       {TVUndef},
+	TVUniv:        {TVUniv},
+	TVInt:         {TVInt},
+	TVFloat:       {TVFloat},
+	TVBool:        {TVBool},
+	TVString:      {TVString},
+	TVSet:         {TVSet},
+	TVRule:        {TVRule},
+	TVDuration:    {TVDuration},
+	TVTime:        {TVTime},
+	TVUint:        {TVUint},
+	TVMapInt:      {TVMapInt},
+	TVMapFloat:    {TVMapFloat},
+	TVMapBool:     {TVMapBool},
+	TVMapString:   {TVMapString},
+	TVMapDuration: {TVMapDuration},
+	TVMapTime:     {TVMapTime},
 }
 var TypeValsFromNames = map[string]*TypeVal{
-	"int":    TypeVals[TVInt],
-	"float":  TypeVals[TVFloat],
-	"bool":   TypeVals[TVBool],
-	"string": TypeVals[TVString],
+	"int":      TypeVals[TVInt],
+	"float":    TypeVals[TVFloat],
+	"bool":     TypeVals[TVBool],
+	"string":   TypeVals[TVString],
+	"set":      TypeVals[TVSet], //only "set of string", see the parser
+	"duration": TypeVals[TVDuration],
+	"time":     TypeVals[TVTime],
+	"uint":     TypeVals[TVUint],
+}
+
+// the type of the elements of the maps, the keys are strings
+var mapElems = map[int]int{
+	TVMapInt:      TVInt,
+	TVMapFloat:    TVFloat,
+	TVMapBool:     TVBool,
+	TVMapString:   TVString,
+	TVMapDuration: TVDuration,
+	TVMapTime:     TVTime,
+}
+
+// map[string]elem, nil if there is no such map
+func MapOf(elem *TypeVal) *TypeVal {
+	for m, e := range mapElems {
+		if elem != nil && e == elem.Id {
+			return TypeVals[m]
+		}
+	}
+	return nil
+}
+
+func (tvp *TypeVal) IsMap() bool {
+	if tvp == nil {
+		return false
+	}
+	_, ok := mapElems[tvp.Id]
+	return ok
+}
+
+// the type of the elements of a map
+func (tvp *TypeVal) MapElem() *TypeVal {
+	return TypeVals[mapElems[tvp.Id]]
 }
 
 type TypeExpr struct {
@@ -138,6 +209,24 @@ func (tep *TypeExpr) IsTypeExprCompat(tep2 *TypeExpr) bool {
 	return tep.Id == tep2.Id
 }
 
+// The most specific of two compatible types of expression,
+// undef if they are not compatible.
+func (tep *TypeExpr) Join(tep2 *TypeExpr) *TypeExpr {
+	switch {
+	case tep == TypeExprs[TEUndef] || tep2 == TypeExprs[TEUndef]:
+		return TypeExprs[TEUndef]
+	case tep == TypeExprs[TEExpr]:
+		return tep2
+	case tep2 == TypeExprs[TEExpr] || tep == tep2:
+		return tep
+	case tep.IsTypeExprCompatSpecial(tep2):
+		return tep2
+	case tep2.IsTypeExprCompatSpecial(tep):
+		return tep
+	}
+	return TypeExprs[TEUndef]
+}
+
 func (tp Type) IsTypeCompat(tp2 Type) bool {
 	tvp, tep := tp.TVal, tp.TExpr
 	tvp2, tep2 := tp2.TVal, tp2.TExpr
@@ -157,15 +246,66 @@ func isCompareOp(op int) bool {
 	return false
 }
 
+func isShiftOp(op int) bool {
+	return lex.TokType(op) == lex.TokLShift || lex.TokType(op) == lex.TokRShift
+}
+
+type mixedOp struct {
+	left  int
+	op    lex.TokType
+	right int
+}
+
+// Operations whose result is not of the type of the operands,
+// durations and times are nanoseconds (int64). The count of a shift
+// may be an int or a uint, the result is the type of the left operand.
+var mixedOps = map[mixedOp]int{
+	{TVTime, lex.TokMin, TVTime}:         TVDuration,
+	{TVTime, lex.TokAdd, TVDuration}:     TVTime,
+	{TVTime, lex.TokMin, TVDuration}:     TVTime,
+	{TVDuration, lex.TokAdd, TVTime}:     TVTime,
+	{TVDuration, lex.TokMul, TVInt}:      TVDuration,
+	{TVInt, lex.TokMul, TVDuration}:      TVDuration,
+	{TVDuration, lex.TokDiv, TVInt}:      TVDuration,
+	{TVDuration, lex.TokDiv, TVDuration}: TVInt,
+	{TVInt, lex.TokLShift, TVUint}:       TVInt,
+	{TVInt, lex.TokRShift, TVUint}:       TVInt,
+	{TVUint, lex.TokLShift, TVInt}:       TVUint,
+	{TVUint, lex.TokRShift, TVInt}:       TVUint,
+	//indexing a map
+	{TVMapInt, lex.TokLBrack, TVString}:      TVInt,
+	{TVMapFloat, lex.TokLBrack, TVString}:    TVFloat,
+	{TVMapBool, lex.TokLBrack, TVString}:     TVBool,
+	{TVMapString, lex.TokLBrack, TVString}:   TVString,
+	{TVMapDuration, lex.TokLBrack, TVString}: TVDuration,
+	{TVMapTime, lex.TokLBrack, TVString}:     TVTime,
+}
+
+// The type of the value of a binary operation, ok is false if
+// it is not a mixed one (the type is the one of the operands).
+func (tp Type) MixedOp(tp2 Type, op int) (tvp *TypeVal, ok bool) {
+	if tp.TVal == nil || tp2.TVal == nil {
+		return nil, false
+	}
+	tv, ok := mixedOps[mixedOp{tp.TVal.Id, lex.TokType(op), tp2.TVal.Id}]
+	if !ok {
+		return nil, false
+	}
+	return TypeVals[tv], true
+}
+
 func (tvp *TypeVal) IsCompatOp(op int) bool {
 	switch tvp {
-	case TypeVals[TVInt]:
+	case TypeVals[TVInt], TypeVals[TVUint]:
 		if strings.ContainsRune("+-*/%&|~^", rune(op)) {
 			return true
 		}
+		if isShiftOp(op) {
+			return true
+		}
 		return isCompareOp(op) //two chars...
 	case TypeVals[TVFloat]:
-		if strings.ContainsRune("+-*/", rune(op)) {
+		if strings.ContainsRune("+-*/%", rune(op)) {
 			return true
 		}
 		return isCompareOp(op) //two chars...
@@ -185,6 +325,22 @@ func (tvp *TypeVal) IsCompatOp(op int) bool {
 			return true
 		}
 		return isCompareOp(op) //two chars...
+	case TypeVals[TVDuration]:
+		if strings.ContainsRune("+-%", rune(op)) {
+			return true
+		}
+		return isCompareOp(op) //two chars...
+	case TypeVals[TVTime]: //see mixedOps for the arithmetic
+		return isCompareOp(op)
+	case TypeVals[TVSet]: // | union, & intersection
+		if strings.ContainsRune("|&", rune(op)) {
+			return true
+		}
+		switch lex.TokType(op) {
+		case lex.TokEq, lex.TokNEq:
+			return true
+		}
+		return false
 	case TypeVals[TVUniv]:
 		return true
 	case TypeVals[TVUndef]:
@@ -194,12 +350,28 @@ func (tvp *TypeVal) IsCompatOp(op int) bool {
 	}
 }
 
+// string in set
+func (tp Type) isInCompat(tp2 *Type) bool {
+	if tp2 == nil || !tp.TExpr.IsTypeExprCompat(tp2.TExpr) {
+		return false
+	}
+	isstr := tp.TVal.IsTypeValCompat(TypeVals[TVString])
+	isset := tp2.TVal.IsTypeValCompat(TypeVals[TVSet])
+	return isstr && isset
+}
+
 func (tp Type) IsCompat(tp2 *Type, op int) bool {
+	if lex.TokType(op) == lex.TokIn {
+		return tp.isInCompat(tp2)
+	}
 	tvp := tp.TVal
 	opC := tvp.IsCompatOp(op)
 	if tp2 == nil { //unary operations
 		return opC
 	}
+	if _, ismixed := tp.MixedOp(*tp2, op); ismixed {
+		return tp.TExpr.IsTypeExprCompat(tp2.TExpr)
+	}
 	return tp.IsTypeCompat(*tp2) && opC
 }
 
@@ -212,6 +384,11 @@ var FloatType = Type{TypeVals[TVFloat], TypeExprs[TEExpr]}
 var IntType = Type{TypeVals[TVInt], TypeExprs[TEExpr]}
 var StringType = Type{TypeVals[TVString], TypeExprs[TEExpr]}
 var BoolType = Type{TypeVals[TVBool], TypeExprs[TEExpr]}
+var SetType = Type{TypeVals[TVSet], TypeExprs[TEExpr]}
+var RuleType = Type{TypeVals[TVRule], TypeExprs[TEExpr]}
+var DurationType = Type{TypeVals[TVDuration], TypeExprs[TEExpr]}
+var TimeType = Type{TypeVals[TVTime], TypeExprs[TEExpr]}
+var UintType = Type{TypeVals[TVUint], TypeExprs[TEExpr]}
 var UnivType = Type{TypeVals[TVUniv], TypeExprs[TEExpr]}
 var UndefType = Type{TypeVals[TVUndef], TypeExprs[TEUndef]}
 var UndefExprType = Type{TypeVals[TVUndef], TypeExprs[TEExpr]}
@@ -221,6 +398,8 @@ var GraphType = Type{TypeVals[TVUniv], TypeExprs[TEGraph]}
 
 var MsgStrType = Type{TypeVals[TVString], TypeExprs[TEMsg]}
 var MsgIntType = Type{TypeVals[TVInt], TypeExprs[TEMsg]}
+var MsgFloatType = Type{TypeVals[TVFloat], TypeExprs[TEMsg]}
+var MsgBoolType = Type{TypeVals[TVBool], TypeExprs[TEMsg]}
 
 var GraphStrType = Type{TypeVals[TVString], TypeExprs[TEGraph]}
 var GraphIntType = Type{TypeVals[TVInt], TypeExprs[TEGraph]}
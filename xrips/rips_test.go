@@ -7,13 +7,18 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"rips/rips/diag"
 	"rips/rips/extern"
 	"rips/rips/lex"
+	"rips/rips/tree"
+	"rips/rips/types"
 	"rips/rips/xrips"
 	"runtime/debug"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed examples/onemsg1
@@ -240,12 +245,20 @@ func TestErrOut(t *testing.T) {
 		t.Fatal(err)
 	}
 	r.Close()
-	lines := bytes.Split(content, []byte{'\n'})
+	//the lines of the snippet go with their error
+	var errs [][]byte
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		if n := len(errs); n > 0 && bytes.HasPrefix(line, []byte{' '}) {
+			errs[n-1] = append(append(errs[n-1], '\n'), line...)
+			continue
+		}
+		errs = append(errs, append([]byte{}, line...))
+	}
 
 	//Order so they appear in order regardless of filesystem, etc.
-	sort.Sort(lexicalorder(lines))
+	sort.Sort(lexicalorder(errs))
 
-	content = bytes.Join(lines, []byte{'\n'})
+	content = bytes.Join(errs, []byte{'\n'})
 
 	out, err := os.CreateTemp("/tmp", "rips_test")
 	if err != nil {
@@ -283,97 +296,935 @@ func TestErrOut(t *testing.T) {
 		os.Remove(out.Name())
 		return
 	}
-	t.Fatalf("Diff output: run:\n\t9 diff -n %s %s\n", out.Name(), okout.Name())
-}
-
-//go:embed examples/initvarerr.rul
-var initvarerr string
-
-// testing of constant init var
-func TestInitVar(t *testing.T) {
-	pfile := strings.NewReader(initvarerr)
-	deblevel := 0
-	out := ioutil.Discard
-	if testing.Verbose() {
-		out = os.Stderr
+	t.Fatalf("Diff output: run:\n\t9 diff -n %s %s\n", out.Name(), okout.Name())
+}
+
+//go:embed examples/initvarerr.rul
+var initvarerr string
+
+// testing of constant init var
+func TestInitVar(t *testing.T) {
+	pfile := strings.NewReader(initvarerr)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/initvarerr.rul", pfile, deblevel, out)
+	n, err := r.BuildAst(nil)
+	//should err
+	if err == nil {
+		t.Fatal(err)
+	}
+	if n <= 0 || n > 1 {
+		t.Fatal("should only be one error")
+	}
+}
+
+//go:embed examples/count.rul
+var count string
+
+//go:embed examples/msg1
+var msgs string
+
+func TestMsgCount(t *testing.T) {
+	pfile := strings.NewReader(count)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/count.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msgs)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	execEnv := r.Program.NewExecEnv(context)
+	for {
+		var rosmsg extern.RosMsg
+		err = rd.Decode(&rosmsg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("decoding ../extern/examples/onemsg1 message")
+		}
+		msgr := extern.NewMsg(&rosmsg)
+		context.Update(msgr)
+		r.Program.Interp(context, execEnv)
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	svar := execEnv.GetSym("nmsg")
+	// egrep '^event:' examples/msg1|grep message|wc -l
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 532 {
+		t.Fatalf("wrong number of msgs: %d", svar.Val.IntVal)
+	}
+	r.Program.Done(execEnv)
+}
+
+// testing of various false conditions
+func TestRun(t *testing.T) {
+	pfile := strings.NewReader(count)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/count.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/msg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	r.Program.Interp(context, execEnv)
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/msgfield.rul
+var msgfield string
+
+// testing of msg fields, undef fields should not fire the rule
+func TestMsgField(t *testing.T) {
+	pfile := strings.NewReader(msgfield)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/msgfield.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	r.Program.Interp(context, execEnv)
+	svar := execEnv.GetSym("npose")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 1 {
+		t.Fatal("npose should be 1")
+	}
+	svar = execEnv.GetSym("theta")
+	if svar == nil || svar.Val == nil || svar.Val.FloatVal != 1.684814691543579 {
+		t.Fatal("theta not set from the msg")
+	}
+	svar = execEnv.GetSym("isundef")
+	if svar == nil || svar.Val == nil || svar.Val.BoolVal {
+		t.Fatal("undef field should not fire the rule")
+	}
+	svar = execEnv.GetSym("ndata")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 0 {
+		t.Fatal("missing field should not fire the rule")
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/sets.rul
+var sets string
+
+// testing of sets, constant, literals and vars
+func TestSets(t *testing.T) {
+	pfile := strings.NewReader(sets)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/sets.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	r.Program.Interp(context, execEnv)
+	svar := execEnv.GetSym("nin")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 1 {
+		t.Fatal("topic should be in the set")
+	}
+	svar = execEnv.GetSym("nall")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("intersection should have two elements")
+	}
+	svar = execEnv.GetSym("seen")
+	if svar == nil || svar.Val == nil || !extern.SetEq(svar.Val.StrSetVal, extern.NewStrSet("pose", "ALEV")) {
+		t.Fatalf("bad union: %s", svar.Val)
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/funcs.rul
+var funcs string
+
+// testing of user functions
+func TestFuncs(t *testing.T) {
+	pfile := strings.NewReader(funcs)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/funcs.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	r.Program.Interp(context, execEnv)
+	svar := execEnv.GetSym("nin")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("pose should be inside and the result doubled")
+	}
+	svar = execEnv.GetSym("nbad")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 1 {
+		t.Fatal("publishers should be wrong")
+	}
+	svar = execEnv.GetSym("nundef")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 0 {
+		t.Fatal("undef should propagate out of the function")
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/rulelabel.rul
+var rulelabel string
+
+// testing of rule labels, enable and disable
+func TestRuleLabel(t *testing.T) {
+	pfile := strings.NewReader(rulelabel)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/rulelabel.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 5; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("nspoof")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 3 {
+		t.Fatal("nospoof should be disabled for one message")
+	}
+	svar = execEnv.GetSym("reenabled")
+	if svar == nil || svar.Val == nil || !svar.Val.BoolVal {
+		t.Fatal("nospoof should be enabled again")
+	}
+	if extern.Enabled(context, "report") {
+		t.Fatal("report should be disabled")
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/first.rul
+var first string
+
+//go:embed examples/stop.rul
+var stop string
+
+func TestFirst(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   string
+		counts map[string]int64
+	}{
+		{"examples/first.rul", first, map[string]int64{"nfirst": 2, "nother": 3}},
+		{"examples/stop.rul", stop, map[string]int64{"nall": 5, "nrest": 3}},
+	}
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	for _, tt := range tests {
+		pfile := strings.NewReader(tt.prog)
+		r := xrips.NewRips(tt.name, pfile, deblevel, out)
+		_, err := r.BuildAst(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := strings.NewReader(msg)
+		context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+		for _, level := range r.Program.Levels {
+			context.AddLevel(level.Name)
+		}
+		context.Conn = conn
+		context.Fatal = Nop
+		context.RConn = bytes.NewBufferString("")
+
+		rd := extern.NewRosDecoder(context.Conn)
+
+		var rosmsg extern.RosMsg
+
+		execEnv := r.Program.NewExecEnv(context)
+		err = rd.Decode(&rosmsg)
+		if err != nil {
+			t.Fatal("decoding ../extern/examples/onemsg1 message")
+		}
+		msg := extern.NewMsg(&rosmsg)
+		context.Update(msg)
+		for i := 0; i < 5; i++ {
+			r.Program.Interp(context, execEnv)
+		}
+		for name, n := range tt.counts {
+			svar := execEnv.GetSym(name)
+			if svar == nil || svar.Val == nil || svar.Val.IntVal != n {
+				t.Fatalf("%s: %s should be %d", tt.name, name, n)
+			}
+		}
+		r.Program.Done(execEnv)
+	}
+}
+
+//go:embed examples/include.rul
+var include string
+
+func TestInclude(t *testing.T) {
+	pfile := strings.NewReader(include)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/include.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Program.Levels) != 2 || len(r.Program.RuleSects) != 1 {
+		t.Fatal("included sections missing")
+	}
+	pos := r.Program.RuleSects[0].Rules[0].Pos
+	if pos.File != "examples/include/counter.rul" || pos.Line != 4 {
+		t.Fatalf("bad position for included rule %s", pos)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 3; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("nmsg")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 3 {
+		t.Fatal("included rules should count the messages")
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/durations.rul
+var durations string
+
+func TestDurations(t *testing.T) {
+	pfile := strings.NewReader(durations)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/durations.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 3; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("nlate")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 3 {
+		t.Fatal("nlate should count the messages")
+	}
+	svar = execEnv.GetSym("last")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal == 0 {
+		t.Fatal("last should be set to the uptime")
+	}
+	if svar.Val.DataType.TVal != types.TypeVals[types.TVDuration] {
+		t.Fatalf("last should be a duration, is %s", svar.Val.DataType)
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/timer.rul
+var timer string
+
+func TestTimers(t *testing.T) {
+	pfile := strings.NewReader(timer)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/timer.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timers := r.Program.Timers()
+	if len(timers) != 2 || timers[0] != 100*time.Millisecond || timers[1] != time.Second {
+		t.Fatalf("bad timers %v", timers)
+	}
+	if tick := extern.PollPeriod(timers); tick != 100*time.Millisecond {
+		t.Fatalf("poll period should be 100ms, is %s", tick)
+	}
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	execEnv := r.Program.NewExecEnv(context)
+	context.Update(nil)
+	context.Timer = extern.TimerName(100 * time.Millisecond)
+	for i := 0; i < 12; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	context.Timer = extern.TimerName(time.Second)
+	r.Program.Interp(context, execEnv)
+	context.Timer = ""
+	counts := map[string]int64{"nmsg": 0, "nfast": 10, "nslow": 1}
+	for name, n := range counts {
+		svar := execEnv.GetSym(name)
+		if svar == nil || svar.Val == nil || svar.Val.IntVal != n {
+			t.Fatalf("%s should be %d", name, n)
+		}
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/softafter.rul
+var softafter string
+
+func TestSoftAfter(t *testing.T) {
+	pfile := strings.NewReader(softafter)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/softafter.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afters := r.Program.Afters()
+	if len(afters) != 3 || afters[0] != 0 || afters[1] != 5*time.Minute || afters[2] != 30*time.Second {
+		t.Fatalf("bad quiet periods %v", afters)
+	}
+	context := extern.NewContext(nil, "../extern/examples/scripts", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	execEnv := r.Program.NewExecEnv(context)
+	context.Update(nil)
+	r.Program.Interp(context, execEnv)
+	if context.CurrLevel != 2 {
+		t.Fatalf("level should be ALERT, is %d", context.CurrLevel)
+	}
+	//as the Dispatcher does, one step each quiet period
+	now := time.Now()
+	if !extern.Deescalate(context, afters, now.Add(time.Minute)) {
+		t.Fatal("ALERT should de-escalate after 30s")
+	}
+	r.Program.Interp(context, execEnv)
+	if extern.Deescalate(context, afters, now.Add(2*time.Minute)) {
+		t.Fatal("B should not de-escalate before 5m")
+	}
+	if !extern.Deescalate(context, afters, now.Add(10*time.Minute)) {
+		t.Fatal("B should de-escalate after 5m")
+	}
+	r.Program.Interp(context, execEnv)
+	counts := map[string]int64{"nalerts": 2, "nquiet": 1}
+	for name, n := range counts {
+		svar := execEnv.GetSym(name)
+		if svar == nil || svar.Val == nil || svar.Val.IntVal != n {
+			t.Fatalf("%s should be %d", name, n)
+		}
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/transitions.rul
+var transitions string
+
+func TestTransitions(t *testing.T) {
+	pfile := strings.NewReader(transitions)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/transitions.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Program.Transitions) != 4 || !r.Program.Transitions[extern.Transition{From: 1, To: 0}] {
+		t.Fatalf("bad transitions %v", r.Program.Transitions)
+	}
+	context := extern.NewContext(nil, "../extern/examples/scripts", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Transitions = r.Program.Transitions
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	execEnv := r.Program.NewExecEnv(context)
+	context.Update(nil)
+	r.Program.Interp(context, execEnv)
+	if context.CurrLevel != 1 {
+		t.Fatalf("level should be ALERT, is %d", context.CurrLevel)
+	}
+	//ALERT -> HALT is not declared, it is rejected
+	r.Program.Interp(context, execEnv)
+	if context.CurrLevel != 3 {
+		t.Fatalf("level should be HALT through COMPROMISED, is %d", context.CurrLevel)
+	}
+	svar := execEnv.GetSym("nrejected")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("the trigger to HALT from ALERT should be rejected twice")
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/lint.rul
+var lint string
+
+func TestLint(t *testing.T) {
+	pfile := strings.NewReader(lint)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/lint.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := r.Program.Lint()
+	lints := []string{
+		"examples/lint.rul:9:2: warning[W001]: const unused is never used",
+		"examples/lint.rul:18:3: warning[W002]: the actions after crash(\"too many\") are never run",
+		"examples/lint.rul:20:3: warning[W003]: trigger(B) when CurrLevel is B does nothing",
+		"examples/lint.rul:21:2: warning[W006]: condition maxlimit > 2 is always true",
+		"examples/lint.rul:23:2: warning[W007]: condition maxlimit < 2 is always false, the rule is dropped",
+		"examples/lint.rul:25:2: warning[W004]: regexp \"topic with spaces\" can never match a topic name",
+		"examples/lint.rul:27:2: warning[W004]: regexp \"^chatter$\" can never match a topic name",
+		"examples/lint.rul:31:2: warning[W005]: duplicate rule, the same as the one at examples/lint.rul:17",
+		"examples/lint.rul:32:3: warning[W002]: the actions after crash(\"too many\") are never run",
+		"examples/lint.rul:33:2: warning[W008]: Uptime as an int (nanoseconds) is deprecated, use a duration or int(Uptime)",
+	}
+	if len(ws) != len(lints) {
+		t.Fatalf("%d warnings, should be %d: %v", len(ws), len(lints), ws)
+	}
+	for i, w := range ws {
+		if w.String() != lints[i] {
+			t.Errorf("warning %q, should be %q", w, lints[i])
+		}
+	}
+	js, err := diag.JSON(ws[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"col": 2`) || !strings.Contains(string(js), `"code": "W001"`) {
+		t.Fatalf("bad json for the warnings %s", js)
+	}
+}
+
+//go:embed examples/windows.rul
+var windows string
+
+func TestWindows(t *testing.T) {
+	pfile := strings.NewReader(windows)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/windows.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 5; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	//count and countif count once for each run, not for each call
+	counts := map[string]int64{"nburst": 3, "nrate": 3, "nalerts": 1, "nposes": 5, "nact": 4}
+	for name, n := range counts {
+		svar := execEnv.GetSym(name)
+		if svar == nil || svar.Val == nil || svar.Val.IntVal != n {
+			t.Fatalf("%s should be %d", name, n)
+		}
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/strfuncs.rul
+var strfuncs string
+
+func TestStrFuncs(t *testing.T) {
+	pfile := strings.NewReader(strfuncs)
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/strfuncs.rul", pfile, deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 2; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("nmatch")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("nmatch should count the messages")
+	}
+	info := "turtle1 13 .turtle1.pose true 1.5 RIPS-3"
+	svar = execEnv.GetSym("info")
+	if svar == nil || svar.Val == nil || svar.Val.StrVal != info {
+		t.Fatalf("info should be %q", info)
+	}
+	r.Program.Done(execEnv)
+}
+
+//go:embed examples/math.rul
+var mathrul string
+
+//go:embed examples/mathnan.rul
+var mathnan string
+
+func TestMath(t *testing.T) {
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/math.rul", strings.NewReader(mathrul), deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rn := xrips.NewRips("examples/mathnan.rul", strings.NewReader(mathnan), deblevel, out)
+	_, err = rn.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	errout := &bytes.Buffer{}
+	context := extern.NewContext(nil, "", len(r.Program.Levels), errout, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	nfatal := 0
+	context.Fatal = func() { nfatal++ }
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 2; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("ndist")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("ndist should count the messages")
+	}
+	svar = execEnv.GetSym("dist")
+	if svar == nil || svar.Val == nil || svar.Val.FloatVal != 6.76 {
+		t.Fatal("dist should be 6.76")
+	}
+	if nfatal != 0 {
+		t.Fatalf("unexpected runtime errors: %s", errout)
 	}
-	r := xrips.NewRips("examples/initvarerr.rul", pfile, deblevel, out)
-	n, err := r.BuildAst(nil)
-	//should err
-	if err == nil {
-		t.Fatal(err)
+	r.Program.Done(execEnv)
+
+	execEnv = rn.Program.NewExecEnv(context)
+	rn.Program.Interp(context, execEnv)
+	nanerr := "examples/mathnan.rul:13 error evaluating, undefined behaviour: result is NaN"
+	if nfatal != 2 || !strings.Contains(errout.String(), nanerr) {
+		t.Fatalf("sqrt of a negative should be a runtime error, got: %q", errout)
 	}
-	if n <= 0 || n > 1 {
-		t.Fatal("should only be one error")
+	converr := "examples/mathnan.rul:15 error evaluating, undefined behaviour: 5.994543075561523e+30 does not fit in an int"
+	if !strings.Contains(errout.String(), converr) {
+		t.Fatalf("int of a float out of range should be a runtime error, got: %q", errout)
 	}
+	rn.Program.Done(execEnv)
 }
 
-//go:embed examples/count.rul
-var count string
-
-//go:embed examples/msg1
-var msgs string
+//go:embed examples/maps.rul
+var mapsrul string
 
-func TestMsgCount(t *testing.T) {
-	pfile := strings.NewReader(count)
+func TestMaps(t *testing.T) {
 	deblevel := 0
 	out := ioutil.Discard
 	if testing.Verbose() {
 		out = os.Stderr
 	}
-	r := xrips.NewRips("examples/count.rul", pfile, deblevel, out)
+	r := xrips.NewRips("examples/maps.rul", strings.NewReader(mapsrul), deblevel, out)
 	_, err := r.BuildAst(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	conn := strings.NewReader(msgs)
+	conn := strings.NewReader(msg)
 	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
 	context.Conn = conn
 	context.Fatal = Nop
 	context.RConn = bytes.NewBufferString("")
 
 	rd := extern.NewRosDecoder(context.Conn)
 
+	var rosmsg extern.RosMsg
+
 	execEnv := r.Program.NewExecEnv(context)
-	for {
-		var rosmsg extern.RosMsg
-		err = rd.Decode(&rosmsg)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			t.Fatal("decoding ../extern/examples/onemsg1 message")
-		}
-		msgr := extern.NewMsg(&rosmsg)
-		context.Update(msgr)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 3; i++ {
 		r.Program.Interp(context, execEnv)
 	}
-	if err != io.EOF {
-		t.Fatal(err)
+	svar := execEnv.GetSym("npubs")
+	if svar == nil || svar.Val == nil || svar.Val.MapVal == nil {
+		t.Fatal("npubs should be a map")
 	}
-	svar := execEnv.GetSym("nmsg")
-	// egrep '^event:' examples/msg1|grep message|wc -l
-	if svar == nil || svar.Val == nil || svar.Val.IntVal != 532 {
-		t.Fatalf("wrong number of msgs: %d", svar.Val.IntVal)
+	npubs := svar.Val.MapVal
+	if npubs.Len() != 1 || npubs.Get(msg.Topic()) != int64(3) {
+		t.Fatalf("npubs should count the messages of the topic: %s", npubs)
+	}
+	for i := 0; i < 2; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	//deleted when greater than MaxPubs, then counted again
+	if npubs.Len() != 1 || npubs.Get(msg.Topic()) != int64(1) {
+		t.Fatalf("npubs should be deleted after 4 messages: %s", npubs)
+	}
+	svar = execEnv.GetSym("names")
+	if svar == nil || svar.Val == nil || svar.Val.MapVal.Get(strings.ToUpper(msg.Topic())) != msg.Topic() {
+		t.Fatal("names should map the topic in upper case to the topic")
+	}
+	if names := svar.Val.MapVal; names.Len() != 1 || names.Has("first") {
+		t.Fatalf("names is bounded to one key, the last set: %s", names)
 	}
 	r.Program.Done(execEnv)
 }
 
-// testing of various false conditions
-func TestRun(t *testing.T) {
-	pfile := strings.NewReader(count)
+//go:embed examples/ttl.rul
+var ttlrul string
+
+func TestTTL(t *testing.T) {
 	deblevel := 0
 	out := ioutil.Discard
 	if testing.Verbose() {
 		out = os.Stderr
 	}
-	r := xrips.NewRips("examples/count.rul", pfile, deblevel, out)
+	r := xrips.NewRips("examples/ttl.rul", strings.NewReader(ttlrul), deblevel, out)
 	_, err := r.BuildAst(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	conn := strings.NewReader(msg)
 	context := extern.NewContext(nil, "", len(r.Program.Levels), out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
 	context.Conn = conn
 	context.Fatal = Nop
 	context.RConn = bytes.NewBufferString("")
@@ -385,11 +1236,31 @@ func TestRun(t *testing.T) {
 	execEnv := r.Program.NewExecEnv(context)
 	err = rd.Decode(&rosmsg)
 	if err != nil {
-		t.Fatal("decoding ../extern/examples/msg1 message")
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
 	}
 	msg := extern.NewMsg(&rosmsg)
 	context.Update(msg)
+	for i := 0; i < 3; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	svar := execEnv.GetSym("nsuspicious")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 1 {
+		t.Fatal("suspicious should be set once until it expires")
+	}
+	if d := extern.TTL(context, "suspicious"); d <= 29*int64(time.Second) {
+		t.Fatalf("ttl of suspicious %s should be about 30s", time.Duration(d))
+	}
+	//as the Dispatcher does, the vars are restored in the next run
+	context.Expire(time.Now().Add(45 * time.Second))
+	svar = execEnv.GetSym("mode")
+	if svar == nil || svar.Val == nil || svar.Val.StrVal != "watch" {
+		t.Fatal("mode should not have expired yet")
+	}
 	r.Program.Interp(context, execEnv)
+	svar = execEnv.GetSym("nsuspicious")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("suspicious should be set again after it expired")
+	}
 	r.Program.Done(execEnv)
 }
 
@@ -582,3 +1453,489 @@ func FuzzRipsDead(f *testing.F) {
 }
 
 //TODO error testing, make sure errors are reported...
+
+//go:embed examples/uint.rul
+var uintrul string
+
+//go:embed examples/uintshift.rul
+var uintshift string
+
+func TestUint(t *testing.T) {
+	deblevel := 0
+	out := ioutil.Discard
+	if testing.Verbose() {
+		out = os.Stderr
+	}
+	r := xrips.NewRips("examples/uint.rul", strings.NewReader(uintrul), deblevel, out)
+	_, err := r.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := xrips.NewRips("examples/uintshift.rul", strings.NewReader(uintshift), deblevel, out)
+	_, err = rs.BuildAst(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := strings.NewReader(msg)
+	errout := &bytes.Buffer{}
+	context := extern.NewContext(nil, "", len(r.Program.Levels), errout, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Conn = conn
+	nfatal := 0
+	context.Fatal = func() { nfatal++ }
+	context.RConn = bytes.NewBufferString("")
+
+	rd := extern.NewRosDecoder(context.Conn)
+
+	var rosmsg extern.RosMsg
+
+	execEnv := r.Program.NewExecEnv(context)
+	err = rd.Decode(&rosmsg)
+	if err != nil {
+		t.Fatal("decoding ../extern/examples/onemsg1 message")
+	}
+	msg := extern.NewMsg(&rosmsg)
+	context.Update(msg)
+	for i := 0; i < 2; i++ {
+		r.Program.Interp(context, execEnv)
+	}
+	//status is 59 (0b111011)
+	svar := execEnv.GetSym("flags")
+	if svar == nil || svar.Val == nil || uint64(svar.Val.IntVal) != 0xf {
+		t.Fatal("flags should be 0xf")
+	}
+	svar = execEnv.GetSym("nfault")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 2 {
+		t.Fatal("nfault should count the messages with the fault bit")
+	}
+	svar = execEnv.GetSym("bits")
+	if svar == nil || svar.Val == nil || svar.Val.IntVal != 8 {
+		t.Fatal("bits should be 8")
+	}
+	if nfatal != 0 {
+		t.Fatalf("unexpected runtime errors: %s", errout)
+	}
+	r.Program.Done(execEnv)
+
+	execEnv = rs.Program.NewExecEnv(context)
+	rs.Program.Interp(context, execEnv)
+	shifterr := "examples/uintshift.rul:12 error evaluating, undefined behaviour: runtime error: negative shift amount"
+	if nfatal != 1 || !strings.Contains(errout.String(), shifterr) {
+		t.Fatalf("a negative shift count should be a runtime error, got: %q", errout)
+	}
+	rs.Program.Done(execEnv)
+}
+
+// the examples not run for the corpus, with the reason
+var vmSkip = map[string]string{
+	"examples/countstr.rul":   "a fuzz seed, its vars are set and never used",
+	"examples/lint.rul":       "crash() exits the test",
+	"examples/plug.rul":       "runs a plugin process for every message",
+	"examples/scenario1.rul":  "exec() writes to the stdout of the test",
+	"examples/stringerr2.rul": "a syntax error, see TestErrOut",
+}
+
+// how runCorpus runs the rules
+const (
+	runPlain = iota //walking the tree without memo, reorder nor topic index, the reference
+	runTree         //walking the tree
+	runVM           //with the compiled code
+)
+
+type runResult struct {
+	out   string
+	rout  string
+	level int64
+	vars  []string
+}
+
+// runs the messages (of msg1) through the rules in fname, every
+// 100 messages the timers fire too, as how says
+func runCorpus(t *testing.T, fname string, rosmsgs []extern.RosMsg, how int) (res runResult) {
+	var out bytes.Buffer
+	r := xrips.NewRips(fname, strings.NewReader(readExample(t, fname)), 0, ioutil.Discard)
+	r.Plain = how == runPlain
+	if _, err := r.BuildAst(nil); err != nil {
+		t.Fatalf("%s: %s", fname, err)
+	}
+	context := extern.NewContext(nil, "", len(r.Program.Levels), &out, nil)
+	for _, level := range r.Program.Levels {
+		context.AddLevel(level.Name)
+	}
+	context.Fatal = Nop
+	context.NoMemo = how == runPlain
+	rout := bytes.NewBufferString("")
+	context.RConn = rout
+
+	execEnv := r.Program.NewExecEnv(context)
+	vm := r.Program.NewVM(execEnv)
+	interp := func() {
+		if how == runVM {
+			vm.Interp(context)
+		} else {
+			r.Program.Interp(context, execEnv)
+		}
+	}
+	for n := range rosmsgs {
+		context.Update(extern.NewMsg(&rosmsgs[n]))
+		interp()
+		if (n+1)%100 != 0 {
+			continue
+		}
+		for _, period := range r.Program.Timers() {
+			context.Timer = extern.TimerName(period)
+			interp()
+		}
+		context.Timer = ""
+	}
+	for name, v := range r.Program.Env {
+		if v.SType != tree.SVar {
+			continue
+		}
+		val := execEnv.GetSym(name).Val
+		if isClock(val.DataType.TVal) {
+			//they come from the clock, different in each run
+			res.vars = append(res.vars, fmt.Sprintf("%s %s", name, val.DataType))
+			continue
+		}
+		s := fmt.Sprintf("%s %s %d %v %q %v %v", name, val.DataType, val.IntVal, val.FloatVal, val.StrVal, val.BoolVal, val.StrSetVal)
+		if val.MapVal != nil {
+			s += " " + val.MapVal.String()
+		}
+		res.vars = append(res.vars, s)
+	}
+	sort.Strings(res.vars)
+	r.Program.Done(execEnv)
+	res.out, res.rout, res.level = out.String(), rout.String(), context.CurrLevel
+	return res
+}
+
+func isClock(tvp *types.TypeVal) bool {
+	switch tvp {
+	case types.TypeVals[types.TVTime], types.TypeVals[types.TVDuration], types.TypeVals[types.TVMapTime]:
+		return true
+	}
+	return false
+}
+
+func readExample(t *testing.T, fname string) string {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// the first max messages of examples/msg1, all if max is 0
+func decodeMsgs(t *testing.T, max int) (rosmsgs []extern.RosMsg) {
+	rd := extern.NewRosDecoder(strings.NewReader(msgs))
+	for max == 0 || len(rosmsgs) < max {
+		var rosmsg extern.RosMsg
+		err := rd.Decode(&rosmsg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("decoding examples/msg1 message")
+		}
+		rosmsgs = append(rosmsgs, rosmsg)
+	}
+	return rosmsgs
+}
+
+func sameRun(t *testing.T, fname string, name1 string, res1 runResult, name2 string, res2 runResult) {
+	if res1.out != res2.out || res1.rout != res2.rout {
+		t.Errorf("%s: different output, %s:\n%s%s\n%s:\n%s%s", fname, name1, res1.out, res1.rout, name2, res2.out, res2.rout)
+	}
+	if res1.level != res2.level {
+		t.Errorf("%s: level %d, should be %d", fname, res2.level, res1.level)
+	}
+	if strings.Join(res1.vars, "\n") != strings.Join(res2.vars, "\n") {
+		t.Errorf("%s: different vars, %s:\n%s\n%s:\n%s", fname, name1, res1.vars, name2, res2.vars)
+	}
+}
+
+// the examples of the corpus, the ones with errors (named
+// ...err.rul) and the ones in vmSkip are left out
+func corpus(t *testing.T) (fnames []string) {
+	all, err := filepath.Glob("examples/*.rul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range all {
+		if strings.HasSuffix(fname, "err.rul") || vmSkip[fname] != "" {
+			continue
+		}
+		fnames = append(fnames, fname)
+	}
+	return fnames
+}
+
+// the VM gives the same results as the plain tree interpreter
+func TestVM(t *testing.T) {
+	rosmsgs := decodeMsgs(t, 0)
+	nrun := 0
+	for _, fname := range corpus(t) {
+		pres := runCorpus(t, fname, rosmsgs, runPlain)
+		vres := runCorpus(t, fname, rosmsgs, runVM)
+		sameRun(t, fname, "plain", pres, "vm", vres)
+		nrun++
+	}
+	if nrun < 30 {
+		t.Fatalf("only %d examples run", nrun)
+	}
+}
+
+//go:embed examples/pure.rul
+var pure string
+
+//go:embed examples/builtins.rul
+var builtinsrul string
+
+// the examples evaluated without allocating, pure expressions
+// and calls to builtins
+var allocTests = []struct {
+	fname string
+	src   string
+}{
+	{"examples/pure.rul", pure},
+	{"examples/builtins.rul", builtinsrul},
+}
+
+// the program of fname and the context for the message of onemsg1
+func allocPrep(tb testing.TB, fname string, src string) (r *xrips.Rips, context *extern.Ctx, execEnv *tree.StkEnv) {
+	r = xrips.NewRips(fname, strings.NewReader(src), 0, ioutil.Discard)
+	if _, err := r.BuildAst(nil); err != nil {
+		tb.Fatal(err)
+	}
+	context = extern.NewContext(nil, "", len(r.Program.Levels), ioutil.Discard, nil)
+	context.Fatal = Nop
+	context.RConn = bytes.NewBufferString("")
+	var rosmsg extern.RosMsg
+	if err := extern.NewRosDecoder(strings.NewReader(msg)).Decode(&rosmsg); err != nil {
+		tb.Fatal("decoding examples/onemsg1 message")
+	}
+	execEnv = r.Program.NewExecEnv(context)
+	context.Update(extern.NewMsg(&rosmsg))
+	return r, context, execEnv
+}
+
+func nRules(p *tree.Prog) (n int) {
+	for _, rs := range p.RuleSects {
+		n += len(rs.Rules)
+	}
+	return n
+}
+
+// evaluating the rules of the examples does not allocate
+func TestVMAllocs(t *testing.T) {
+	for _, tt := range allocTests {
+		r, context, execEnv := allocPrep(t, tt.fname, tt.src)
+		vm := r.Program.NewVM(execEnv)
+		vm.Interp(context)
+		if n := testing.AllocsPerRun(100, func() { vm.Interp(context) }); n != 0 {
+			t.Errorf("%s: %v allocations for %d rules, should be 0", tt.fname, n, nRules(r.Program))
+		}
+		r.Program.Done(execEnv)
+	}
+}
+
+func BenchmarkVM(b *testing.B) {
+	for _, tt := range allocTests {
+		b.Run(strings.TrimSuffix(filepath.Base(tt.fname), ".rul"), func(b *testing.B) {
+			r, context, execEnv := allocPrep(b, tt.fname, tt.src)
+			vm := r.Program.NewVM(execEnv)
+			vm.Interp(context)
+			if n := testing.AllocsPerRun(10, func() { vm.Interp(context) }); n != 0 {
+				b.Fatalf("%v allocations for %d rules, should be 0", n, nRules(r.Program))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vm.Interp(context)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*nRules(r.Program)), "ns/rule")
+		})
+	}
+}
+
+// walking the tree, to compare
+func BenchmarkInterp(b *testing.B) {
+	for _, tt := range allocTests {
+		b.Run(strings.TrimSuffix(filepath.Base(tt.fname), ".rul"), func(b *testing.B) {
+			r, context, execEnv := allocPrep(b, tt.fname, tt.src)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Program.Interp(context, execEnv)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*nRules(r.Program)), "ns/rule")
+		})
+	}
+}
+
+const memorul = `levels:
+	L0;
+
+vars:
+	n int = 0;
+
+rules Msg:
+	plugin(%q) ?
+		set(n, n + 1);
+	plugin(%q) && n > 0 ?
+		set(n, n + 1);
+	msgint("x") > 3 ?
+		set(n, n + 10);
+	msgint("x") <= 3 ?
+		set(n, n + 10);
+`
+
+// the plugin and msgint, pure builtins, run once for each message,
+// even if the rules call them twice
+func TestMemo(t *testing.T) {
+	dir := t.TempDir()
+	plug := filepath.Join(dir, "plug.sh")
+	script := "#!/bin/sh\ncat > /dev/null\necho run >> " + filepath.Join(dir, "runs") + "\n"
+	if err := os.WriteFile(plug, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	nmsgint := 0
+	for _, b := range tree.Builtins {
+		if b.IsPure && b.IsAction {
+			t.Fatalf("builtin %s is pure and an action", b.Name)
+		}
+		if b.Name == "msgint" {
+			msgint := b.Typed.(func(*extern.Ctx, string) (int64, bool))
+			b.Typed = func(context *extern.Ctx, path string) (int64, bool) {
+				nmsgint++
+				return msgint(context, path)
+			}
+			defer func(b *tree.Builtin) { b.Typed = msgint }(b)
+		}
+	}
+	rosmsgs := decodeMsgs(t, 10)
+	for _, isvm := range []bool{false, true} {
+		os.Remove(filepath.Join(dir, "runs"))
+		nmsgint = 0
+		src := fmt.Sprintf(memorul, plug, plug)
+		r := xrips.NewRips("memo.rul", strings.NewReader(src), 0, ioutil.Discard)
+		if _, err := r.BuildAst(nil); err != nil {
+			t.Fatal(err)
+		}
+		context := extern.NewContext(nil, "", len(r.Program.Levels), ioutil.Discard, nil)
+		for _, level := range r.Program.Levels {
+			context.AddLevel(level.Name)
+		}
+		context.Fatal = Nop
+		context.RConn = bytes.NewBufferString("")
+		execEnv := r.Program.NewExecEnv(context)
+		vm := r.Program.NewVM(execEnv)
+		nmsg := 0
+		for n := range rosmsgs {
+			context.Update(extern.NewMsg(&rosmsgs[n]))
+			if context.CurrentMsg.Type() == "Msg" {
+				nmsg++
+			}
+			if isvm {
+				vm.Interp(context)
+			} else {
+				r.Program.Interp(context, execEnv)
+			}
+		}
+		runs, err := os.ReadFile(filepath.Join(dir, "runs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nruns := strings.Count(string(runs), "run\n"); nmsg == 0 || nruns != nmsg {
+			t.Fatalf("vm %v: the plugin ran %d times for %d messages", isvm, nruns, nmsg)
+		}
+		if nmsgint != nmsg {
+			t.Fatalf("vm %v: msgint ran %d times for %d messages", isvm, nmsgint, nmsg)
+		}
+		if n := execEnv.GetSym("n").Val.IntVal; n != int64(2*nmsg) {
+			t.Fatalf("vm %v: n is %d, both rules should run for each message", isvm, n)
+		}
+		r.Program.Done(execEnv)
+	}
+}
+
+//go:embed examples/reorder.rul
+var reorder string
+
+// the cheap operands go first, the ones which may be undef, stop the
+// program or change something stay in place
+func TestReorder(t *testing.T) {
+	r := xrips.NewRips("examples/reorder.rul", strings.NewReader(reorder), 0, ioutil.Discard)
+	if _, err := r.BuildAst(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`((isvideo() && topicin({"/videocorridor"})) && msghas("data"))`,
+		`(((ncorr > 3) || topicin({"/rosout"})) || msgbool("data"))`,
+		`((msghas("data") && (count("data", 1m0s) > 2)) && topicin({"/videooffice"}))`,
+		`((msgint("data") > 0) || topicin({"/videooffice"}))`,
+		`(msghas("data") && ((100 / (ncorr + 1)) > 2))`,
+	}
+	rules := r.Program.RuleSects[0].Rules
+	for i, w := range want {
+		cond := fmt.Sprintf("%s", (*tree.USym)(rules[i].Expr))
+		if cond != w {
+			t.Errorf("rule %d is %s, should be %s", i, cond, w)
+		}
+		if isre := rules[i].Reordered != ""; isre != (i < 2) {
+			t.Errorf("rule %d reordered %v, was %q", i, isre, rules[i].Reordered)
+		}
+	}
+}
+
+//go:embed examples/topics.rul
+var topicsrul string
+
+// the rules run only for the topics of their topicin guards, with
+// the same results as running all of them
+func TestTopicIndex(t *testing.T) {
+	r := xrips.NewRips("examples/topics.rul", strings.NewReader(topicsrul), 0, ioutil.Discard)
+	if _, err := r.BuildAst(nil); err != nil {
+		t.Fatal(err)
+	}
+	rs := r.Program.RuleSects[0]
+	if rs.Index == nil {
+		t.Fatal("the Msg section should be indexed by topic")
+	}
+	want := map[string][]int{
+		"/parameter_events": {3, 4, 5, 6, 7},
+		"/videocorridor":    {0, 3, 4, 5, 7},
+		"/videooffice":      {1, 2, 3, 4, 5, 6, 7},
+		"":                  {3, 4, 5, 7},
+	}
+	for topic, w := range want {
+		rules, ok := rs.Index.Topics[topic]
+		if topic == "" {
+			rules, ok = rs.Index.Residual, true
+		}
+		got := []int{}
+		for _, rule := range rules {
+			for i := range rs.Rules {
+				if rs.Rules[i] == rule {
+					got = append(got, i)
+				}
+			}
+		}
+		if !ok || fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Errorf("topic %q: rules %v, should be %v", topic, got, w)
+		}
+	}
+	if len(rs.Index.Topics) != len(want)-1 {
+		t.Errorf("%d topics indexed, should be %d", len(rs.Index.Topics), len(want)-1)
+	}
+
+	rosmsgs := decodeMsgs(t, 0)
+	for _, fname := range corpus(t) {
+		pres := runCorpus(t, fname, rosmsgs, runPlain)
+		tres := runCorpus(t, fname, rosmsgs, runTree)
+		sameRun(t, fname, "plain", pres, "tree", tres)
+	}
+}
@@ -2,11 +2,13 @@ package xrips
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"rips/rips/diag"
 	"rips/rips/extern"
 	"rips/rips/lex"
 	"rips/rips/parser"
@@ -22,12 +24,18 @@ type Rips struct {
 	ExecEnv     *tree.StkEnv
 	Program     *tree.Prog
 	DebLevel    int
+	Errout      io.Writer  //where BuildAst writes the errors
+	Diags       *diag.List //of BuildAst
+	Plain       bool       //build it without reorder nor topic index, see tree.Prog
+
+	fname  string
+	source bytes.Buffer //the program read, for the snippets of the errors
 }
 
 func NewRips(progfname string, progfile io.Reader, deblevel int, out io.Writer) (r *Rips) {
-	r = &Rips{}
-	bufrd := bufio.NewReader(progfile)
-	l, err := lex.NewLexerRd(bufrd, progfname, out)
+	r = &Rips{Errout: out, Diags: &diag.List{}, fname: progfname}
+	bufrd := bufio.NewReader(io.TeeReader(progfile, &r.source))
+	l, err := lex.NewLexerRd(bufrd, progfname, r.Diags)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -41,12 +49,18 @@ func NewRips(progfname string, progfile io.Reader, deblevel int, out io.Writer)
 func (r *Rips) BuildAst(xstats *stats.Stats) (nerr int, err error) {
 	xstats.Start(stats.Compiling)
 	defer xstats.End(stats.Compiling)
+	defer func() {
+		r.PrintDiags(r.Errout, r.Diags.Diags)
+	}()
+	r.Diags.Stage = diag.Parse
 	prog, err := r.Parser.Parse()
 	if err != nil {
 		nerr = r.Parser.NErrors()
 		return nerr, err
 	}
 	r.Program = prog
+	prog.Plain = r.Plain
+	prog.Sources = diag.Sources{r.fname: r.source.Bytes()}
 	if r.DebLevel > 2 {
 		fmt.Fprintf(os.Stderr, "############Before typing###########\n%s", prog)
 		fmt.Fprintf(os.Stderr, "##################################\n")
@@ -55,7 +69,8 @@ func (r *Rips) BuildAst(xstats *stats.Stats) (nerr int, err error) {
 		s := fmt.Sprintf("There were parsing/lexing errors")
 		return nerr, errors.New(s)
 	}
-	nerr += r.Program.TypeCheck(r.Lexer.Errout())
+	r.Diags.Stage = diag.Types
+	nerr += r.Program.TypeCheck(r.Diags)
 	if nerr > 0 {
 		s := fmt.Sprintf("There were type errors")
 		return nerr, errors.New(s)
@@ -64,22 +79,35 @@ func (r *Rips) BuildAst(xstats *stats.Stats) (nerr int, err error) {
 		fmt.Fprintf(os.Stderr, "############Before folding###########\n%s", prog)
 		fmt.Fprintf(os.Stderr, "##################################\n")
 	}
-	nerr += r.Program.Fold(r.Lexer.Errout())
+	r.Diags.Stage = diag.Fold
+	nerr += r.Program.Fold(r.Diags)
 	if nerr > 0 {
 		s := fmt.Sprintf("There were optimization/constant evaluation errors")
 		return nerr, errors.New(s)
 	}
-	nerr += r.Program.StatesCheck(r.Lexer.Errout())
+	r.Diags.Stage = diag.Levels
+	nerr += r.Program.StatesCheck(r.Diags)
 	if nerr > 0 {
 		s := fmt.Sprintf("There were state machine errors")
 		return nerr, errors.New(s)
 	}
+	r.Program.IndexTopics()
+	r.Program.Compile()
 	if r.DebLevel > 0 {
 		fmt.Fprintf(os.Stderr, "%s", r.Program)
 	}
+	if r.DebLevel > 1 {
+		fmt.Fprintf(os.Stderr, "%s", r.Program.Code)
+	}
 	return nerr, nil
 }
 
+// PrintDiags writes the diagnostics with their lines of the program
+func (r *Rips) PrintDiags(w io.Writer, ds []*diag.Diagnostic) {
+	srcs := diag.Sources{r.fname: r.source.Bytes()}
+	diag.Print(w, ds, srcs)
+}
+
 func CheckLevelScripts(p *tree.Prog, context *extern.Ctx) (nerr int) {
 	for _, ls := range p.Levels {
 		fromname := fmt.Sprintf(extern.LevelFromFmt, ls.Name)